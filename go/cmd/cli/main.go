@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -15,6 +18,12 @@ var (
 	host    = flag.String("host", "127.0.0.1", "JsonDB host")
 	port    = flag.String("port", "7989", "JsonDB port")
 	command = flag.String("c", "", "Execute single command and exit")
+	user    = flag.String("user", "", "Username for AUTH")
+	pass    = flag.String("pass", "", "Password for AUTH (env JSONDB_PASSWORD)")
+	useTLS  = flag.Bool("tls", false, "Connect using TLS")
+	caCert  = flag.String("cacert", "", "CA certificate file (PEM) to verify the server, defaults to system roots")
+	cert    = flag.String("cert", "", "Client certificate file (PEM) for mTLS")
+	key     = flag.String("key", "", "Client private key file (PEM) for mTLS")
 )
 
 func main() {
@@ -26,7 +35,7 @@ func main() {
 		fmt.Printf("Connecting to JsonDB at %s\n", addr)
 	}
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err := dial(addr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", addr, err)
 		fmt.Fprintf(os.Stderr, "Make sure JsonDB is running.")
@@ -52,6 +61,43 @@ func main() {
 	}
 }
 
+func dial(addr string) (net.Conn, error) {
+	if !*useTLS {
+		if *caCert != "" || *cert != "" || *key != "" {
+			return nil, fmt.Errorf("-cacert, -cert and -key require -tls")
+		}
+		return net.Dial("tcp", addr)
+	}
+
+	config := &tls.Config{
+		ServerName: *host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if *caCert != "" {
+		data, err := os.ReadFile(*caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", *caCert)
+		}
+		config.RootCAs = pool
+	}
+
+	if *cert != "" || *key != "" {
+		pair, err := tls.LoadX509KeyPair(*cert, *key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return tls.Dial("tcp", addr, config)
+}
+
 func exec(conn net.Conn, cmd string) error {
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
@@ -62,6 +108,10 @@ func exec(conn net.Conn, cmd string) error {
 		return fmt.Errorf("error reading connecting: %v", err)
 	}
 
+	if err := auth(reader, writer); err != nil {
+		return err
+	}
+
 	_, err = writer.WriteString(cmd + "\n")
 	if err != nil {
 		return fmt.Errorf("error sending: %v", err)
@@ -97,6 +147,11 @@ func cli(conn net.Conn) error {
 	}
 
 	welcome := string(buffer[:n])
+
+	if err := auth(reader, writer); err != nil {
+		return err
+	}
+
 	fmt.Print(welcome)
 
 	for {
@@ -150,3 +205,33 @@ func cli(conn net.Conn) error {
 	fmt.Println("Disconnected from JsonDB")
 	return nil
 }
+
+// * 有指定使用者時，連線後先送出 AUTH
+func auth(reader *bufio.Reader, writer *bufio.Writer) error {
+	if *user == "" {
+		return nil
+	}
+
+	password := *pass
+	if password == "" {
+		password = os.Getenv("JSONDB_PASSWORD")
+	}
+
+	writer.WriteString(fmt.Sprintf("AUTH %s %s\n", strconv.Quote(*user), strconv.Quote(password)))
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error sending AUTH: %v", err)
+	}
+
+	buffer := make([]byte, 1024)
+	n, err := reader.Read(buffer)
+	if err != nil {
+		return fmt.Errorf("error reading AUTH response: %v", err)
+	}
+
+	// * 回應後接著提示符，只取第一行
+	res, _, _ := strings.Cut(string(buffer[:n]), "\n")
+	if msg, isError := strings.CutPrefix(res, "Error: "); isError {
+		return fmt.Errorf("authentication failed: %s", msg)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go-jsondb/internal/server"
+	"go-jsondb/internal/storage"
+	"go-jsondb/internal/testutil"
+)
+
+// * 回傳收到內容的測試伺服器，tls-auth-clients 為空字串時使用明文
+func echoServer(t *testing.T, certs testutil.Certs, authClients string) string {
+	t.Helper()
+
+	var listener net.Listener
+	var err error
+	if authClients == "" {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	} else {
+		option := storage.NewConfig().Option
+		option.TLSPort = 1
+		option.TLSCertFile = certs.ServerCert
+		option.TLSKeyFile = certs.ServerKey
+		option.TLSCACertFile = certs.CA
+		option.TLSAuthClients = authClients
+
+		var config *tls.Config
+		config, err = server.TLSConfig(option)
+		if err != nil {
+			t.Fatalf("TLSConfig: %v", err)
+		}
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", config)
+	}
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func roundTrip(conn net.Conn) error {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("PING\n")); err != nil {
+		return err
+	}
+	_, err := bufio.NewReader(conn).ReadString('\n')
+	return err
+}
+
+func TestDialTLSFlags(t *testing.T) {
+	certs := testutil.WriteCerts(t, t.TempDir())
+
+	tests := []struct {
+		name        string
+		authClients string
+		useTLS      bool
+		caCert      string
+		cert, key   string
+		dialErr     string
+		ok          bool
+	}{
+		{name: "plaintext", ok: true},
+		{name: "-cacert without -tls", caCert: certs.CA, dialErr: "require -tls"},
+		{name: "-cert without -tls", cert: certs.ClientCert, key: certs.ClientKey, dialErr: "require -tls"},
+		{name: "-tls with -cacert", authClients: "no", useTLS: true, caCert: certs.CA, ok: true},
+		{name: "-tls without -cacert", authClients: "no", useTLS: true, dialErr: "certificate"},
+		{name: "-tls with a missing -cacert file", authClients: "no", useTLS: true, caCert: certs.CA + ".missing", dialErr: "failed to read CA certificate"},
+		{name: "-cert without -key", authClients: "no", useTLS: true, caCert: certs.CA, cert: certs.ClientCert, dialErr: "failed to load client certificate"},
+		{name: "-cert and -key for mTLS", authClients: "yes", useTLS: true, caCert: certs.CA, cert: certs.ClientCert, key: certs.ClientKey, ok: true},
+		{name: "mTLS without -cert", authClients: "yes", useTLS: true, caCert: certs.CA},
+		{name: "optional mTLS without -cert", authClients: "optional", useTLS: true, caCert: certs.CA, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := echoServer(t, certs, tt.authClients)
+
+			*host = "127.0.0.1"
+			*useTLS, *caCert, *cert, *key = tt.useTLS, tt.caCert, tt.cert, tt.key
+
+			conn, err := dial(addr)
+			if tt.dialErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.dialErr) {
+					t.Fatalf("dial error = %v, want %q", err, tt.dialErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+
+			// * TLS 1.3 的客戶端在伺服器驗證憑證前就完成握手，拒絕要到第一次讀取才會出現
+			err = roundTrip(conn)
+			if tt.ok && err != nil {
+				t.Fatalf("connection rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatalf("connection accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// * 量測並行 GET 的吞吐量，背景同時以固定數量的連線持續 SET，驗證讀取是否隨連線數與 CPU 核心數擴展
+var (
+	host      = flag.String("host", "127.0.0.1", "Server host")
+	port      = flag.Int("port", 7989, "Server port")
+	keys      = flag.Int("keys", 10000, "Number of keys to preload and read")
+	valueSize = flag.Int("value-size", 64, "Size of each value in bytes")
+	clients   = flag.String("clients", "", "Comma separated reader counts (default 1,2,4,... up to 2x CPU cores)")
+	writers   = flag.Int("writers", 2, "Concurrent SET connections running during every round")
+	duration  = flag.Duration("duration", 3*time.Second, "Duration of each round")
+	db        = flag.Int("db", 0, "Database used for the benchmark keys")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: jsondb-benchmark [options]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	rounds, err := parseClients(*clients)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	value := strconv.Quote(strings.Repeat("x", *valueSize))
+
+	fmt.Printf("Preloading %d keys into DB %d on %s\n", *keys, *db, addr)
+	if err := preload(addr, value); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to preload keys: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("CPU cores: %d, writers: %d, duration: %s\n\n", runtime.NumCPU(), *writers, *duration)
+	fmt.Printf("%8s %14s %14s %10s\n", "readers", "GET ops/sec", "SET ops/sec", "scaling")
+
+	var base float64
+	for _, n := range rounds {
+		reads, writes, err := round(addr, value, n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Round with %d readers failed: %v\n", n, err)
+			os.Exit(1)
+		}
+
+		if base == 0 {
+			base = reads
+		}
+		fmt.Printf("%8d %14.0f %14.0f %9.2fx\n", n, reads, writes, reads/base)
+	}
+}
+
+func parseClients(str string) ([]int, error) {
+	if str == "" {
+		var list []int
+		for n := 1; n <= runtime.NumCPU()*2; n *= 2 {
+			list = append(list, n)
+		}
+		return list, nil
+	}
+
+	var list []int
+	for _, e := range strings.Split(str, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(e))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid client count '%s'", e)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func preload(addr, value string) error {
+	conn, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i := 0; i < *keys; i++ {
+		if _, err := conn.do("SET", key(i), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * 執行一輪測試，回傳 GET 與 SET 的每秒次數
+func round(addr, value string, readers int) (float64, float64, error) {
+	var (
+		reads  atomic.Int64
+		writes atomic.Int64
+		stop   atomic.Bool
+		wg     sync.WaitGroup
+		errMu  sync.Mutex
+		first  error
+	)
+
+	fail := func(err error) {
+		errMu.Lock()
+		if first == nil {
+			first = err
+		}
+		errMu.Unlock()
+		stop.Store(true)
+	}
+
+	run := func(counter *atomic.Int64, command string, args func(r *rand.Rand) []string) {
+		defer wg.Done()
+
+		conn, err := dial(addr)
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer conn.Close()
+
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for !stop.Load() {
+			if _, err := conn.do(command, args(r)...); err != nil {
+				fail(err)
+				return
+			}
+			counter.Add(1)
+		}
+	}
+
+	for i := 0; i < *writers; i++ {
+		wg.Add(1)
+		go run(&writes, "SET", func(r *rand.Rand) []string {
+			return []string{key(r.Intn(*keys)), value}
+		})
+	}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go run(&reads, "GET", func(r *rand.Rand) []string {
+			return []string{key(r.Intn(*keys))}
+		})
+	}
+
+	started := time.Now()
+	time.Sleep(*duration)
+	stop.Store(true)
+	wg.Wait()
+	elapsed := time.Since(started).Seconds()
+
+	if first != nil {
+		return 0, 0, first
+	}
+	return float64(reads.Load()) / elapsed, float64(writes.Load()) / elapsed, nil
+}
+
+func key(i int) string {
+	return "bench:" + strconv.Itoa(i)
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func dial(addr string) (*conn, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+
+	client := &conn{Conn: c, reader: bufio.NewReader(c), writer: bufio.NewWriter(c)}
+	if *db != 0 {
+		if _, err := client.do("SELECT", strconv.Itoa(*db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// * 以 RESP 送出指令並讀取單一回覆，錯誤回覆轉為 error
+func (c *conn) do(command string, args ...string) (string, error) {
+	fmt.Fprintf(c.writer, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(command), command)
+	for _, arg := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.writer.Flush(); err != nil {
+		return "", fmt.Errorf("failed to send command: %v", err)
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read reply: %v", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '-':
+		return "", fmt.Errorf("%s", line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid bulk length '%s'", line[1:])
+		}
+		if size < 0 {
+			return "", nil
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return "", fmt.Errorf("failed to read reply: %v", err)
+		}
+		return string(data[:size]), nil
+	default:
+		return line[1:], nil
+	}
+}
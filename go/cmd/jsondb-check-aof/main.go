@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go-jsondb/internal/storage"
+)
+
+// * 離線檢查 AOF 檔案，-fix 時截斷第一個損壞紀錄之後的內容
+var fix = flag.Bool("fix", false, "Truncate the AOF at the first bad record")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: jsondb-check-aof [-fix] <file.aof>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	status := 0
+	for _, path := range flag.Args() {
+		if !check(path) {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+func check(path string) bool {
+	scan, err := storage.ScanAOF(path, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return false
+	}
+
+	if !scan.Corrupt {
+		fmt.Printf("%s: OK (%d records, %d bytes)\n", path, scan.Records, scan.Size)
+		return true
+	}
+
+	kind := "corrupted record"
+	if scan.TornTail {
+		kind = "incomplete tail"
+	}
+
+	fmt.Printf("%s: %s at line %d (offset %d): %v\n", path, kind, scan.Line, scan.ValidSize, scan.Error)
+	fmt.Printf("%s: %d valid records, %d of %d bytes would be discarded\n", path, scan.Records, scan.Size-scan.ValidSize, scan.Size)
+
+	if !*fix {
+		fmt.Printf("%s: run with -fix to truncate at offset %d\n", path, scan.ValidSize)
+		return false
+	}
+
+	if err := storage.TruncateAOF(path, scan.ValidSize); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return false
+	}
+
+	fmt.Printf("%s: truncated to %d bytes\n", path, scan.ValidSize)
+	return true
+}
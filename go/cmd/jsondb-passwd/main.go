@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go-jsondb/internal/storage"
+)
+
+// * 產生使用者檔案中的密碼雜湊，密碼由標準輸入讀取，避免留在 shell 歷史紀錄
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: echo -n <password> | jsondb-passwd\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+		os.Exit(1)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "Password must not be empty")
+		os.Exit(1)
+	}
+
+	hash, err := storage.HashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(hash)
+}
@@ -2,62 +2,452 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"go-jsondb/internal/command"
 	"go-jsondb/internal/server"
+	"go-jsondb/internal/storage"
+	"go-jsondb/internal/util"
 )
 
+const (
+	// * 自動判斷協定時，等待客戶端第一個位元組的時間
+	detectTimeout = 300 * time.Millisecond
+	// * TLS 握手需在期限內完成，避免未完成握手的連線佔用資源
+	handshakeTimeout = 10 * time.Second
+)
+
+// * 設定優先順序: 預設值 < 設定檔 < 環境變數 < 命令列參數
+var (
+	configFile = flag.String("config", "", "Path to JSON config file (env JSONDB_CONFIG)")
+	protocol   string
+)
+
+// * 命令列參數只記錄字串，實際驗證與套用交給 server.ApplySetting
+type settingFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *settingFlag) String() string     { return f.value }
+func (f *settingFlag) Set(v string) error { f.value = v; return nil }
+func (f *settingFlag) IsBoolFlag() bool   { return f.isBool }
+
+func init() {
+	defaults := storage.NewConfig().Option
+
+	for _, e := range []struct {
+		name, value, usage string
+		isBool             bool
+	}{
+		{"bind", defaults.Bind, "Bind address", false},
+		{"port", fmt.Sprint(defaults.Port), "Plaintext listen port (0 = disabled)", false},
+		{"tls-port", "0", "TLS listen port (0 = disabled)", false},
+		{"tls-cert-file", "", "TLS certificate file (PEM)", false},
+		{"tls-key-file", "", "TLS private key file (PEM)", false},
+		{"tls-ca-cert-file", "", "CA certificate file (PEM) used to verify client certificates", false},
+		{"tls-auth-clients", defaults.TLSAuthClients, "Client certificate verification: no, optional or yes", false},
+		{"protocol", defaults.Protocol, "Wire protocol: auto, resp or text", false},
+		{"dir", defaults.DBPath, "Data directory", false},
+		{"databases", fmt.Sprint(defaults.Databases), "Number of databases", false},
+		{"users-file", "", "JSON users file with password hashes and ACL rules (empty = no authentication)", false},
+		{"appendfsync", defaults.AppendFsync, "AOF fsync policy: always, everysec or no", false},
+		{"auto-aof-rewrite-percentage", fmt.Sprint(defaults.AutoRewritePercentage), "AOF growth percentage that triggers a rewrite (0 = disabled)", false},
+		{"auto-aof-rewrite-min-size", fmt.Sprint(defaults.AutoRewriteMinSize), "Minimum AOF size for automatic rewrite, e.g. 64mb", false},
+		{"aof-repair", "no", "Truncate a corrupted AOF at the first bad record on startup", true},
+		{"maxmemory", "0", "Memory limit for cached values, e.g. 512mb (0 = unlimited)", false},
+		{"maxmemory-policy", defaults.MaxMemoryPolicy, "Eviction policy: noeviction, allkeys-lru, allkeys-lfu, volatile-lru or volatile-ttl", false},
+		{"maxmemory-samples", fmt.Sprint(defaults.MaxMemorySamples), "Keys sampled per eviction", false},
+		{"expire-interval", fmt.Sprint(defaults.ExpireInterval), "Seconds between expired key reports and access stats saves", false},
+		{"hz", fmt.Sprint(defaults.Hz), "Active expiry cycles per second", false},
+		{"log-level", defaults.LogLevel, "Log level: debug, info, warn or error", false},
+		{"shutdown-timeout", fmt.Sprint(defaults.ShutdownTimeout), "Seconds to wait for in-flight commands on shutdown", false},
+		{"warm", "yes", "Preload the hottest evicted keys in background on startup", true},
+		{"warm-keys", "0", "Maximum keys to preload when warming (0 = unlimited)", false},
+		{"warm-bytes", "0", "Maximum bytes to preload when warming, e.g. 256mb (0 = unlimited)", false},
+	} {
+		flag.Var(&settingFlag{value: e.value, isBool: e.isBool}, e.name, e.usage)
+	}
+}
+
+func loadConfig() (storage.Config, error) {
+	config := storage.NewConfig()
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("JSONDB_CONFIG")
+	}
+	if path != "" {
+		if err := storage.LoadConfigFile(path, &config); err != nil {
+			return config, err
+		}
+	}
+
+	if err := server.ApplyEnv(&config); err != nil {
+		return config, err
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if err == nil && server.IsSetting(f.Name) {
+			err = server.ApplySetting(&config, f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return config, err
+	}
+
+	// * 設定檔中的值也需經過同樣的驗證
+	return config, server.ValidateConfig(config)
+}
+
 func main() {
-	fmt.Println("JsonDB starting on 127.0.0.1:7989")
+	flag.Parse()
 
-	server, err := server.NewServer()
+	config, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	level, _ := util.ParseLogLevel(config.Option.LogLevel)
+	slog.SetLogLoggerLevel(level)
+	protocol = config.Option.Protocol
+
+	tlsConfig, err := server.TLSConfig(config.Option)
+	if err != nil {
+		slog.Error("Invalid TLS configuration", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("JsonDB starting", "version", server.Version)
+
+	jsondbServer, err := server.NewServer(config)
+	if err != nil {
+		slog.Error("Failed to create server", "error", err)
+		os.Exit(1)
 	}
-	defer server.Close()
 
 	parser := command.NewParser()
 
-	listener, err := net.Listen("tcp", "127.0.0.1:7989")
+	listeners, err := listen(config.Option, tlsConfig)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		jsondbServer.Close()
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	conns := newConnSet()
+	for _, listener := range listeners {
+		go accept(listener, conns, jsondbServer, parser)
+	}
+
+	slog.Info("JsonDB ready to connect")
+
+	select {
+	case sig := <-signals:
+		slog.Info("Received signal, shutting down", "signal", sig)
+	case <-jsondbServer.ShutdownRequested():
+		slog.Info("SHUTDOWN requested, shutting down")
+	}
+
+	os.Exit(shutdown(listeners, conns, jsondbServer, signals))
+}
+
+// * 明文與 TLS 使用不同連接埠，可同時開啟
+func listen(option storage.Option, tlsConfig *tls.Config) ([]net.Listener, error) {
+	var list []net.Listener
+
+	if option.Port != 0 {
+		addr := net.JoinHostPort(option.Bind, strconv.Itoa(option.Port))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("JsonDB listening", "addr", addr)
+		list = append(list, listener)
+	}
+
+	if tlsConfig != nil {
+		addr := net.JoinHostPort(option.Bind, strconv.Itoa(option.TLSPort))
+		listener, err := tls.Listen("tcp", addr, tlsConfig)
+		if err != nil {
+			for _, e := range list {
+				e.Close()
+			}
+			return nil, err
+		}
+		slog.Info("JsonDB listening", "addr", addr, "tls", true, "client_certificates", option.TLSAuthClients)
+		list = append(list, listener)
+	}
+
+	return list, nil
+}
+
+// * 停止接受連線，等待進行中的指令完成 (逾時或再次收到信號則強制中斷)，最後 fsync 並關閉 AOF
+func shutdown(listeners []net.Listener, conns *connSet, jsondbServer *server.Server, signals chan os.Signal) int {
+	for _, listener := range listeners {
+		listener.Close()
 	}
-	defer listener.Close()
 
-	fmt.Println("JsonDB ready to connect")
+	timeout := jsondbServer.ShutdownTimeout()
+	count := conns.drain()
+	slog.Info("Waiting for clients to finish", "clients", count, "timeout", timeout)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-conns.done():
+	case <-timer.C:
+		slog.Warn("Shutdown timeout exceeded, closing remaining clients", "clients", conns.closeAll())
+		<-conns.done()
+	case sig := <-signals:
+		slog.Warn("Received signal again, closing remaining clients", "signal", sig, "clients", conns.closeAll())
+		<-conns.done()
+	}
+
+	if err := jsondbServer.Close(); err != nil {
+		slog.Error("JsonDB stopped with error", "error", err)
+		return 1
+	}
+
+	slog.Info("JsonDB stopped, all AOF files flushed")
+	return 0
+}
 
+func accept(listener net.Listener, conns *connSet, jsondbServer *server.Server, parser *command.Parser) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Error connect: %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Error("Failed to accept connection", "error", err)
 			continue
 		}
 
-		go newConn(conn, server, parser)
+		if !conns.add(conn) {
+			conn.Close()
+			continue
+		}
+
+		go newConn(conn, conns, jsondbServer, parser)
+	}
+}
+
+// * 追蹤目前的連線，關閉時用於等待進行中的指令
+type connSet struct {
+	mu       sync.Mutex
+	list     map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	draining bool
+}
+
+func newConnSet() *connSet {
+	return &connSet{list: make(map[net.Conn]struct{})}
+}
+
+func (set *connSet) add(conn net.Conn) bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if set.draining {
+		return false
+	}
+
+	set.list[conn] = struct{}{}
+	set.wg.Add(1)
+	return true
+}
+
+func (set *connSet) remove(conn net.Conn) {
+	set.mu.Lock()
+	delete(set.list, conn)
+	set.mu.Unlock()
+
+	set.wg.Done()
+}
+
+func (set *connSet) isDraining() bool {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	return set.draining
+}
+
+// * 讓等待中的讀取立即返回，執行中的指令仍會完成並送出回覆
+func (set *connSet) drain() int {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.draining = true
+	for conn := range set.list {
+		conn.SetReadDeadline(time.Now())
+	}
+	return len(set.list)
+}
+
+func (set *connSet) closeAll() int {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for conn := range set.list {
+		conn.Close()
 	}
+	return len(set.list)
+}
+
+func (set *connSet) done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		set.wg.Wait()
+		close(done)
+	}()
+	return done
 }
 
-func newConn(conn net.Conn, jsondbServer *server.Server, parser *command.Parser) {
+func newConn(conn net.Conn, conns *connSet, jsondbServer *server.Server, parser *command.Parser) {
+	defer conns.remove(conn)
 	defer conn.Close()
 
 	addr := conn.RemoteAddr().String()
-	fmt.Printf("New client connected: %s\n", addr)
+
+	// * 先完成握手，協定判斷的短期限才不會被握手時間吃掉
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tlsConn.Handshake()
+		tlsConn.SetDeadline(time.Time{})
+		if err != nil {
+			slog.Warn("TLS handshake failed", "addr", addr, "error", err)
+			return
+		}
+	}
+
+	slog.Info("New client connected", "addr", addr)
 
 	session := jsondbServer.NewClient()
-	reader := bufio.NewScanner(conn)
+	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
-	writer.WriteString("JsonDB Go 0.1.0\n")
+	useRESP := isRESP(conn, reader)
+
+	// * 協定判斷期間開始關閉時，判斷結束會清除讀取期限，需重新設定
+	if conns.isDraining() {
+		conn.SetReadDeadline(time.Now())
+	}
+
+	if useRESP {
+		serveRESP(addr, reader, writer, session, parser)
+	} else {
+		serveText(addr, reader, writer, session, parser)
+	}
+
+	slog.Info("Client disconnected", "addr", addr)
+}
+
+// * RESP 客戶端連線後會立即送出 * 開頭的請求，逾時未收到則視為互動式文字協定
+func isRESP(conn net.Conn, reader *bufio.Reader) bool {
+	switch protocol {
+	case "resp":
+		return true
+	case "text":
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(detectTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	first, err := reader.Peek(1)
+	return err == nil && first[0] == '*'
+}
+
+func serveRESP(addr string, reader *bufio.Reader, writer *bufio.Writer, session *server.Client, parser *command.Parser) {
+	for {
+		// * 通過驗證前只接受小型請求，避免未驗證的連線佔用大量記憶體
+		limits := command.DefaultLimits
+		if !session.Authenticated() {
+			limits = command.UnauthLimits
+		}
+
+		args, err := command.ReadRESP(reader, limits)
+		if err != nil {
+			// * 讀取期限到期代表伺服器正在關閉
+			if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+				server.Errorf("%v", err).WriteRESP(writer, session.Proto())
+				writer.Flush()
+				slog.Warn("Failed to read from client", "addr", addr, "error", err)
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if strings.EqualFold(args[0], "quit") {
+			server.Status("OK").WriteRESP(writer, session.Proto())
+			writer.Flush()
+			return
+		}
+
+		var res server.Reply
+		cmd, err := parser.ParseArgs(args)
+		if err != nil {
+			res = server.Errorf("%v", err)
+		} else {
+			res = session.Exec(cmd)
+		}
+
+		res.WriteRESP(writer, session.Proto())
+
+		// * pipeline 中的請求處理完再一次送出
+		if reader.Buffered() == 0 {
+			writer.Flush()
+		}
+	}
+}
+
+func serveText(addr string, reader *bufio.Reader, writer *bufio.Writer, session *server.Client, parser *command.Parser) {
+	writer.WriteString("JsonDB Go " + server.Version + "\n")
 	writer.WriteString("Type 'help' for available commands or 'quit' to exit\n")
 	writer.WriteString("jsondb[0]> ")
 	writer.Flush()
 
-	for reader.Scan() {
-		line := strings.TrimSpace(reader.Text())
+	for {
+		// * 與 RESP 相同，通過驗證前只接受小型請求
+		limit := command.DefaultLimits.Inline
+		if !session.Authenticated() {
+			limit = command.UnauthLimits.Inline
+		}
+
+		// * 連線關閉前最後一行沒有換行時仍執行，執行完再結束
+		line, readErr := command.ReadLine(reader, limit)
+		line = strings.TrimSpace(line)
+		if readErr != nil && (readErr != io.EOF || line == "") {
+			if readErr != io.EOF && !errors.Is(readErr, os.ErrDeadlineExceeded) {
+				writer.WriteString(fmt.Sprintf("Error: %v\n", readErr))
+				writer.Flush()
+				slog.Warn("Failed to read from client", "addr", addr, "error", readErr)
+			}
+			return
+		}
 
 		// * 無內容，直接顯示提示符
 		if line == "" {
@@ -69,7 +459,7 @@ func newConn(conn net.Conn, jsondbServer *server.Server, parser *command.Parser)
 		if strings.EqualFold(line, "quit") {
 			writer.WriteString("Bye\n")
 			writer.Flush()
-			break
+			return
 		}
 
 		var res string
@@ -77,17 +467,15 @@ func newConn(conn net.Conn, jsondbServer *server.Server, parser *command.Parser)
 		if err != nil {
 			res = fmt.Sprintf("Error: %v", err)
 		} else {
-			res = session.Exec(cmd)
+			res = session.Exec(cmd).Text()
 		}
 
 		writer.WriteString(res + "\n")
 		writer.WriteString(fmt.Sprintf("jsondb[%d]> ", session.GetDB()))
 		writer.Flush()
-	}
 
-	if err := reader.Err(); err != nil {
-		log.Printf("Error reading from client %s: %v", addr, err)
+		if readErr != nil {
+			return
+		}
 	}
-
-	fmt.Printf("Client disconnected: %s\n", addr)
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-jsondb/internal/command"
+	"go-jsondb/internal/server"
+	"go-jsondb/internal/storage"
+	"go-jsondb/internal/testutil"
+)
+
+// * 以實際的連接埠啟動伺服器，回傳明文與 TLS 的位址 (未開啟時為空字串)
+func startServer(t *testing.T, option storage.Option) (string, string) {
+	t.Helper()
+
+	protocol = "resp"
+	if option.Protocol == "text" {
+		protocol = "text"
+	}
+
+	config := storage.NewConfig()
+	config.Option = option
+	config.Option.Bind = "127.0.0.1"
+	config.Option.Protocol = protocol
+	config.Option.DBPath = t.TempDir()
+	config.Option.WarmOnStart = false
+
+	tlsConfig, err := server.TLSConfig(config.Option)
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+
+	jsondbServer, err := server.NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	listeners, err := listen(config.Option, tlsConfig)
+	if err != nil {
+		jsondbServer.Close()
+		t.Fatalf("listen: %v", err)
+	}
+
+	conns := newConnSet()
+	for _, listener := range listeners {
+		go accept(listener, conns, jsondbServer, command.NewParser())
+	}
+
+	t.Cleanup(func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		conns.closeAll()
+		<-conns.done()
+		jsondbServer.Close()
+	})
+
+	plainAddr, tlsAddr := "", ""
+	if option.Port != 0 {
+		plainAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(option.Port))
+	}
+	if option.TLSPort != 0 {
+		tlsAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(option.TLSPort))
+	}
+	return plainAddr, tlsAddr
+}
+
+func clientTLS(t *testing.T, certs testutil.Certs, certFile, keyFile string) *tls.Config {
+	t.Helper()
+
+	pool, err := server.LoadCertPool(certs.CA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+	if certFile != "" {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// * 不論伺服器接受哪些 CA 都送出憑證，才能測到伺服器拒絕不信任的憑證
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &pair, nil
+		}
+	}
+	return config
+}
+
+// * TLS 1.3 的客戶端在伺服器驗證憑證前就完成握手，拒絕要到第一次讀取才會出現
+func ping(conn net.Conn) error {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != "+PONG\r\n" {
+		return fmt.Errorf("unexpected reply: %q", line)
+	}
+	return nil
+}
+
+func pingPlain(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	return ping(conn)
+}
+
+func pingTLS(addr string, config *tls.Config) error {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	return ping(conn)
+}
+
+func TestPlaintextAndTLSPorts(t *testing.T) {
+	certs := testutil.WriteCerts(t, t.TempDir())
+
+	option := storage.NewConfig().Option
+	option.Port = testutil.FreePort(t)
+	option.TLSPort = testutil.FreePort(t)
+	option.TLSCertFile = certs.ServerCert
+	option.TLSKeyFile = certs.ServerKey
+	plainAddr, tlsAddr := startServer(t, option)
+
+	if err := pingPlain(plainAddr); err != nil {
+		t.Errorf("plaintext port: %v", err)
+	}
+	if err := pingTLS(tlsAddr, clientTLS(t, certs, "", "")); err != nil {
+		t.Errorf("TLS port: %v", err)
+	}
+	if err := pingPlain(tlsAddr); err == nil {
+		t.Errorf("plaintext client accepted on the TLS port")
+	}
+	if err := pingTLS(plainAddr, clientTLS(t, certs, "", "")); err == nil {
+		t.Errorf("TLS client accepted on the plaintext port")
+	}
+}
+
+func TestTLSOnly(t *testing.T) {
+	certs := testutil.WriteCerts(t, t.TempDir())
+
+	option := storage.NewConfig().Option
+	option.Port = 0
+	option.TLSPort = testutil.FreePort(t)
+	option.TLSCertFile = certs.ServerCert
+	option.TLSKeyFile = certs.ServerKey
+	_, tlsAddr := startServer(t, option)
+
+	if err := pingTLS(tlsAddr, clientTLS(t, certs, "", "")); err != nil {
+		t.Errorf("TLS port: %v", err)
+	}
+}
+
+func TestTLSAuthClients(t *testing.T) {
+	certs := testutil.WriteCerts(t, t.TempDir())
+
+	const (
+		noCert = iota
+		validCert
+		untrustedCert
+	)
+
+	tests := []struct {
+		mode   string
+		client int
+		ok     bool
+	}{
+		{"no", noCert, true},
+		{"no", validCert, true},
+		{"no", untrustedCert, true},
+		{"optional", noCert, true},
+		{"optional", validCert, true},
+		{"optional", untrustedCert, false},
+		{"yes", noCert, false},
+		{"yes", validCert, true},
+		{"yes", untrustedCert, false},
+	}
+
+	names := []string{"without client cert", "with client cert", "with untrusted client cert"}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+names[tt.client], func(t *testing.T) {
+			option := storage.NewConfig().Option
+			option.Port = 0
+			option.TLSPort = testutil.FreePort(t)
+			option.TLSCertFile = certs.ServerCert
+			option.TLSKeyFile = certs.ServerKey
+			option.TLSCACertFile = certs.CA
+			option.TLSAuthClients = tt.mode
+			_, tlsAddr := startServer(t, option)
+
+			config := clientTLS(t, certs, "", "")
+			switch tt.client {
+			case validCert:
+				config = clientTLS(t, certs, certs.ClientCert, certs.ClientKey)
+			case untrustedCert:
+				config = clientTLS(t, certs, certs.OtherCert, certs.OtherKey)
+			}
+
+			err := pingTLS(tlsAddr, config)
+			if tt.ok && err != nil {
+				t.Fatalf("connection rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatalf("connection accepted")
+			}
+		})
+	}
+}
+
+// * 讀取到下一個提示符為止，回傳提示符之前的內容
+func readPrompt(reader *bufio.Reader) (string, error) {
+	var text strings.Builder
+	for {
+		chunk, err := reader.ReadString(' ')
+		text.WriteString(chunk)
+		if err != nil {
+			return text.String(), err
+		}
+
+		if str := text.String(); strings.HasSuffix(str, "]> ") {
+			return str[:strings.LastIndex(str, "jsondb[")], nil
+		}
+	}
+}
+
+func dialText(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+
+	reader := bufio.NewReader(conn)
+	if _, err := readPrompt(reader); err != nil {
+		t.Fatalf("banner: %v", err)
+	}
+	return conn, reader
+}
+
+func TestTextLineLimitBeforeAuth(t *testing.T) {
+	option := storage.NewConfig().Option
+	option.Port = testutil.FreePort(t)
+	option.Protocol = "text"
+	option.UsersFile = testutil.WriteUsers(t, t.TempDir(), []storage.User{
+		{Name: "admin", Password: "secret", Commands: []string{"+@all"}},
+	})
+	addr, _ := startServer(t, option)
+
+	// * 通過驗證前超過上限的行直接回傳錯誤並中斷連線，不會緩衝整行
+	conn, reader := dialText(t, addr)
+	long := strings.Repeat("x", command.UnauthLimits.Inline+1)
+	if _, err := conn.Write([]byte("SET k " + long + "\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	reply, err := reader.ReadString('\n')
+	if err != nil || !strings.Contains(reply, "too big request") {
+		t.Fatalf("reply = %q (%v), want too big request", reply, err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("connection still open after oversized line: %v", err)
+	}
+
+	// * 通過驗證後改用一般的上限
+	conn, reader = dialText(t, addr)
+	if _, err := conn.Write([]byte("AUTH admin secret\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if reply, err := readPrompt(reader); err != nil || reply != "OK\n" {
+		t.Fatalf("AUTH = %q (%v)", reply, err)
+	}
+
+	if _, err := conn.Write([]byte("SET k " + long + "\nGET k\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if reply, err := readPrompt(reader); err != nil || reply != "OK\n" {
+		t.Fatalf("SET = %q (%v)", reply, err)
+	}
+	if reply, err := readPrompt(reader); err != nil || reply != long+"\n" {
+		t.Fatalf("GET returned %d bytes (%v), want %d", len(reply), err, len(long)+1)
+	}
+
+	// * 關閉連線前最後一行沒有換行時仍會執行
+	if _, err := conn.Write([]byte("EXISTS k")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	if reply, err := reader.ReadString('\n'); err != nil || reply != "(integer) 1\n" {
+		t.Fatalf("EXISTS = %q (%v)", reply, err)
+	}
+}
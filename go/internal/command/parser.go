@@ -1,10 +1,15 @@
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
+
+	"go-jsondb/internal/query"
+	"go-jsondb/internal/util"
 )
 
 type Parser struct{}
@@ -14,7 +19,16 @@ func NewParser() *Parser {
 }
 
 func (p *Parser) Parse(input string) (*Command, error) {
-	parts := strings.Fields(input)
+	parts, err := Tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ParseArgs(parts)
+}
+
+// * 已拆解好的參數，RESP 請求直接使用
+func (p *Parser) ParseArgs(parts []string) (*Command, error) {
 	if len(parts) == 0 {
 		return nil, fmt.Errorf("no command")
 	}
@@ -36,20 +50,50 @@ func (p *Parser) Parse(input string) (*Command, error) {
 		return p.EXISTS(parts)
 	case "KEYS":
 		return p.KEYS(parts)
+	case "SCAN":
+		return p.SCAN(parts)
 	case "TYPE":
 		return p.TYPE(parts)
+	case "INCR":
+		return p.INCR(parts)
+	case "DECR":
+		return p.DECR(parts)
+	case "INCRBY":
+		return p.INCRBY(parts)
+	case "DECRBY":
+		return p.DECRBY(parts)
+	case "INCRBYFLOAT":
+		return p.INCRBYFLOAT(parts)
 
 	// * DOC 操作
 	case "FIND":
 		return p.FIND(parts)
+	case "FSCAN":
+		return p.FSCAN(parts)
+	case "SORT":
+		return p.SORT(parts)
 	case "ADD":
 		return p.ADD(parts)
+	case "UPDATE":
+		return p.UPDATE(parts)
+	case "REMOVE":
+		return p.REMOVE(parts)
 
 	// * TTL 操作
 	case "TTL":
 		return p.TTL(parts)
+	case "PTTL":
+		return p.PTTL(parts)
+	case "EXPIRETIME":
+		return p.EXPIRETIME(parts)
 	case "EXPIRE":
 		return p.EXPIRE(parts)
+	case "PEXPIRE":
+		return p.PEXPIRE(parts)
+	case "EXPIREAT":
+		return p.EXPIREAT(parts)
+	case "PEXPIREAT":
+		return p.PEXPIREAT(parts)
 	case "PERSIST":
 		return p.PERSIST(parts)
 
@@ -58,6 +102,24 @@ func (p *Parser) Parse(input string) (*Command, error) {
 		return p.HELP(parts)
 	case "PING":
 		return p.PING(parts)
+	case "HELLO":
+		return p.HELLO(parts)
+	case "INFO":
+		return p.INFO(parts)
+	case "REWRITEAOF", "BGREWRITEAOF":
+		return p.REWRITEAOF(parts)
+	case "SAVE":
+		return p.SAVE(parts)
+	case "BGSAVE":
+		return p.BGSAVE(parts)
+	case "WARM":
+		return p.WARM(parts)
+	case "CONFIG":
+		return p.CONFIG(parts)
+	case "SHUTDOWN":
+		return p.SHUTDOWN(parts)
+	case "AUTH":
+		return p.AUTH(parts)
 
 	default:
 		return nil, fmt.Errorf("unknown command: %s", cmd)
@@ -74,8 +136,9 @@ func (p *Parser) SELECT(part []string) (*Command, error) {
 		return nil, fmt.Errorf("invalid database number: %s", part[1])
 	}
 
-	if num < 0 || num > 15 {
-		return nil, fmt.Errorf("database must be between 0 and 15")
+	// * 上限依伺服器的 databases 設定，由伺服器檢查
+	if num < 0 {
+		return nil, fmt.Errorf("database number must not be negative")
 	}
 
 	cmd := NewCommand(SELECT)
@@ -93,43 +156,161 @@ func (p *Parser) GET(part []string) (*Command, error) {
 	return cmd, nil
 }
 
+// * SET <key> <value> [ttl_second|expire_time]
+// * SET <key> <value> [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp-ms|KEEPTTL] [NX|XX] [GET]
 func (p *Parser) SET(part []string) (*Command, error) {
-	if len(part) < 3 || len(part) > 4 {
-		return nil, fmt.Errorf("usage: SET <key> <value> [ttl_second|expire_time]")
+	usage := fmt.Errorf("usage: SET <key> <value> [ttl_second|expire_time] or SET <key> <value> [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp-ms|KEEPTTL] [NX|XX] [GET]")
+	if len(part) < 3 {
+		return nil, usage
 	}
 
 	cmd := NewCommand(SET)
 	cmd.SetArg("key", part[1])
 	cmd.SetArg("value", part[2])
 
-	if len(part) == 4 {
-		ttl, err := parseTTL(part[3])
-		if err != nil {
+	// * 舊版語法: 第四個參數直接為秒數或日期
+	if len(part) == 4 && !isSetOption(part[3]) {
+		ttl, err := parseTTL(part[3], time.Second)
+		if err != nil || ttl < 0 {
 			return nil, fmt.Errorf("invalid expire time: %s", part[3])
 		}
 
 		cmd.SetArg("ttl", ttl)
+		return cmd, nil
+	}
+
+	hasExpire := false
+	for i := 3; i < len(part); i++ {
+		option := strings.ToUpper(part[i])
+
+		switch option {
+		case "NX", "XX":
+			if _, isExist := cmd.GetArg("condition"); isExist {
+				return nil, usage
+			}
+			cmd.SetArg("condition", option)
+		case "GET":
+			cmd.SetArg("get", true)
+		case "KEEPTTL":
+			if hasExpire {
+				return nil, usage
+			}
+			hasExpire = true
+			cmd.SetArg("keepttl", true)
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || i+1 >= len(part) {
+				return nil, usage
+			}
+			hasExpire = true
+			i++
+
+			value, err := strconv.ParseInt(part[i], 10, 64)
+			if err != nil || value <= 0 {
+				return nil, fmt.Errorf("invalid expire time in 'SET' command: %s", part[i])
+			}
+
+			switch option {
+			case "EX":
+				err = setTTL(cmd, value, time.Second)
+			case "PX":
+				err = setTTL(cmd, value, time.Millisecond)
+			case "EXAT":
+				err = setExpireAt(cmd, value, time.Second)
+			case "PXAT":
+				err = setExpireAt(cmd, value, time.Millisecond)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid expire time in 'SET' command: %s", part[i])
+			}
+		default:
+			return nil, usage
+		}
 	}
 
 	return cmd, nil
 }
 
-func parseTTL(value string) (uint64, error) {
-	list := []string{
-		"2006-01-02 15:04:05",
-		"2006-01-02 15:04",
-		"2006-01-02 15",
-		"2006-01-02",
-		"2006-01",
+func isSetOption(value string) bool {
+	switch strings.ToUpper(value) {
+	case "EX", "PX", "EXAT", "PXAT", "KEEPTTL", "NX", "XX", "GET":
+		return true
 	}
+	return false
+}
 
-	for _, format := range list {
+var dateFormats = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15",
+	"2006-01-02",
+	"2006-01",
+}
+
+func parseDate(value string) (time.Time, bool) {
+	for _, format := range dateFormats {
 		if expireTime, err := time.ParseInLocation(format, value, time.Local); err == nil {
-			return uint64(time.Until(expireTime).Seconds()), nil
+			return expireTime, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// * 相對的過期時間，以秒為單位時也接受日期，已過的日期為負值
+func parseTTL(value string, unit time.Duration) (time.Duration, error) {
+	if unit == time.Second {
+		if expireTime, ok := parseDate(value); ok {
+			return time.Until(expireTime), nil
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, fmt.Errorf("value is out of range")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// * 絕對的過期時間，回傳 Unix 毫秒，以秒為單位時也接受日期
+func parseTimestamp(value string, unit time.Duration) (int64, error) {
+	if unit == time.Second {
+		if expireTime, ok := parseDate(value); ok {
+			return expireTime.UnixMilli(), nil
 		}
 	}
 
-	return strconv.ParseUint(value, 10, 64)
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return toMilli(n, unit)
+}
+
+func toMilli(n int64, unit time.Duration) (int64, error) {
+	scale := int64(unit / time.Millisecond)
+	if n > math.MaxInt64/scale || n < math.MinInt64/scale {
+		return 0, fmt.Errorf("value is out of range")
+	}
+	return n * scale, nil
+}
+
+func setTTL(cmd *Command, value int64, unit time.Duration) error {
+	if value > math.MaxInt64/int64(unit) {
+		return fmt.Errorf("value is out of range")
+	}
+	cmd.SetArg("ttl", time.Duration(value)*unit)
+	return nil
+}
+
+func setExpireAt(cmd *Command, value int64, unit time.Duration) error {
+	expireAt, err := toMilli(value, unit)
+	if err != nil {
+		return err
+	}
+	cmd.SetArg("expire_at", expireAt)
+	return nil
 }
 
 func (p *Parser) DEL(part []string) (*Command, error) {
@@ -162,6 +343,45 @@ func (p *Parser) KEYS(part []string) (*Command, error) {
 	return cmd, nil
 }
 
+// * SCAN <cursor> [MATCH pattern] [COUNT count] [TYPE type]
+func (p *Parser) SCAN(part []string) (*Command, error) {
+	usage := fmt.Errorf("usage: SCAN <cursor> [MATCH pattern] [COUNT count] [TYPE type]")
+	if len(part) < 2 || len(part)%2 != 0 {
+		return nil, usage
+	}
+
+	cmd := NewCommand(SCAN)
+	cmd.SetArg("cursor", part[1])
+	cmd.SetArg("pattern", "*")
+	cmd.SetArg("count", 10)
+
+	for i := 2; i < len(part); i += 2 {
+		switch strings.ToUpper(part[i]) {
+		case "MATCH":
+			cmd.SetArg("pattern", part[i+1])
+		case "COUNT":
+			if err := parseCount(cmd, part[i+1]); err != nil {
+				return nil, err
+			}
+		case "TYPE":
+			cmd.SetArg("type", part[i+1])
+		default:
+			return nil, usage
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseCount(cmd *Command, value string) error {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return fmt.Errorf("invalid count: %s", value)
+	}
+	cmd.SetArg("count", count)
+	return nil
+}
+
 func (p *Parser) TYPE(part []string) (*Command, error) {
 	if len(part) != 2 {
 		return nil, fmt.Errorf("usage: TYPE <key>")
@@ -172,18 +392,202 @@ func (p *Parser) TYPE(part []string) (*Command, error) {
 	return cmd, nil
 }
 
-// TODO: 實作 filters 細節
 func (p *Parser) FIND(part []string) (*Command, error) {
-	if len(part) < 2 {
-		return nil, fmt.Errorf("usage: FIND <key> [filters]")
+	if len(part) < 2 || len(part) > 5 {
+		return nil, fmt.Errorf("usage: FIND <key> [filters] [page] [offset]")
 	}
 
 	cmd := NewCommand(FIND)
 	cmd.SetArg("key", part[1])
 
+	filters := make(map[string]interface{})
 	if len(part) > 2 {
-		filters := strings.Join(part[2:], " ")
-		cmd.SetArg("filters", filters)
+		obj, err := parseObject(part[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filters: %v", err)
+		}
+		filters = obj
+	}
+	cmd.SetArg("filters", filters)
+
+	if len(part) > 3 {
+		if err := parsePage(cmd, part[3:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return cmd, nil
+}
+
+// * 解析單一 JSON 物件參數
+func parseObject(token string) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(token), &obj); err != nil {
+		return nil, err
+	}
+
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+
+	return obj, nil
+}
+
+// * 分頁參數上限，page * offset 不會溢位
+const (
+	MaxPage       = math.MaxInt32
+	MaxPageOffset = 1 << 20
+)
+
+// * 分頁參數: page 從 0 開始，offset 為每頁筆數
+func parsePage(cmd *Command, part []string) error {
+	if len(part) > 0 {
+		page, err := strconv.Atoi(part[0])
+		if err != nil || page < 0 || page > MaxPage {
+			return fmt.Errorf("invalid page: %s (0-%d)", part[0], MaxPage)
+		}
+		cmd.SetArg("page", page)
+	}
+
+	if len(part) > 1 {
+		offset, err := strconv.Atoi(part[1])
+		if err != nil || offset <= 0 || offset > MaxPageOffset {
+			return fmt.Errorf("invalid offset: %s (1-%d)", part[1], MaxPageOffset)
+		}
+		cmd.SetArg("offset", offset)
+	}
+
+	return nil
+}
+
+func (p *Parser) INCR(part []string) (*Command, error) {
+	if len(part) != 2 {
+		return nil, fmt.Errorf("usage: INCR <key>")
+	}
+
+	cmd := NewCommand(INCR)
+	cmd.SetArg("key", part[1])
+	cmd.SetArg("increment", int64(1))
+	return cmd, nil
+}
+
+func (p *Parser) DECR(part []string) (*Command, error) {
+	if len(part) != 2 {
+		return nil, fmt.Errorf("usage: DECR <key>")
+	}
+
+	cmd := NewCommand(DECR)
+	cmd.SetArg("key", part[1])
+	cmd.SetArg("increment", int64(-1))
+	return cmd, nil
+}
+
+func (p *Parser) INCRBY(part []string) (*Command, error) {
+	if len(part) != 3 {
+		return nil, fmt.Errorf("usage: INCRBY <key> <increment>")
+	}
+
+	increment, err := strconv.ParseInt(part[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+
+	cmd := NewCommand(INCRBY)
+	cmd.SetArg("key", part[1])
+	cmd.SetArg("increment", increment)
+	return cmd, nil
+}
+
+func (p *Parser) DECRBY(part []string) (*Command, error) {
+	if len(part) != 3 {
+		return nil, fmt.Errorf("usage: DECRBY <key> <decrement>")
+	}
+
+	decrement, err := strconv.ParseInt(part[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	if decrement == math.MinInt64 {
+		return nil, fmt.Errorf("decrement would overflow")
+	}
+
+	cmd := NewCommand(DECRBY)
+	cmd.SetArg("key", part[1])
+	cmd.SetArg("increment", -decrement)
+	return cmd, nil
+}
+
+func (p *Parser) INCRBYFLOAT(part []string) (*Command, error) {
+	if len(part) != 3 {
+		return nil, fmt.Errorf("usage: INCRBYFLOAT <key> <increment>")
+	}
+
+	increment, err := strconv.ParseFloat(part[2], 64)
+	if err != nil || math.IsNaN(increment) || math.IsInf(increment, 0) {
+		return nil, fmt.Errorf("value is not a valid float")
+	}
+
+	cmd := NewCommand(INCRBYFLOAT)
+	cmd.SetArg("key", part[1])
+	cmd.SetArg("increment", increment)
+	return cmd, nil
+}
+
+// * FSCAN <key> <cursor> [MATCH filters] [COUNT count]
+func (p *Parser) FSCAN(part []string) (*Command, error) {
+	usage := fmt.Errorf("usage: FSCAN <key> <cursor> [MATCH filters] [COUNT count]")
+	if len(part) < 3 || len(part)%2 != 1 {
+		return nil, usage
+	}
+
+	cmd := NewCommand(FSCAN)
+	cmd.SetArg("key", part[1])
+	cmd.SetArg("cursor", part[2])
+	cmd.SetArg("filters", make(map[string]interface{}))
+	cmd.SetArg("count", 10)
+
+	for i := 3; i < len(part); i += 2 {
+		switch strings.ToUpper(part[i]) {
+		case "MATCH":
+			filters, err := parseObject(part[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid filters: %v", err)
+			}
+			cmd.SetArg("filters", filters)
+		case "COUNT":
+			if err := parseCount(cmd, part[i+1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, usage
+		}
+	}
+
+	return cmd, nil
+}
+
+func (p *Parser) SORT(part []string) (*Command, error) {
+	if len(part) < 4 || len(part) > 6 {
+		return nil, fmt.Errorf("usage: SORT <key> <filters> <sort_by> [page] [offset]")
+	}
+
+	cmd := NewCommand(SORT)
+	cmd.SetArg("key", part[1])
+
+	filters, err := parseObject(part[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid filters: %v", err)
+	}
+	cmd.SetArg("filters", filters)
+
+	fields, err := query.ParseSort([]byte(part[3]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sort: %v", err)
+	}
+	cmd.SetArg("sort", fields)
+
+	if err := parsePage(cmd, part[4:]); err != nil {
+		return nil, err
 	}
 
 	return cmd, nil
@@ -191,50 +595,137 @@ func (p *Parser) FIND(part []string) (*Command, error) {
 
 func (p *Parser) ADD(part []string) (*Command, error) {
 	if len(part) != 3 {
-		return nil, fmt.Errorf("usage: ADD <key> <value>")
+		return nil, fmt.Errorf("usage: ADD <key> <document>")
 	}
 
 	cmd := NewCommand(ADD)
 	cmd.SetArg("key", part[1])
-	cmd.SetArg("value", part[2])
+
+	doc, err := parseObject(part[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+
+	cmd.SetArg("value", doc)
+	return cmd, nil
+}
+
+func (p *Parser) UPDATE(part []string) (*Command, error) {
+	if len(part) != 4 {
+		return nil, fmt.Errorf("usage: UPDATE <key> <filters> <update>")
+	}
+
+	cmd := NewCommand(UPDATE)
+	cmd.SetArg("key", part[1])
+
+	filters, err := parseObject(part[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid filters: %v", err)
+	}
+	cmd.SetArg("filters", filters)
+
+	update, err := parseObject(part[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid update: %v", err)
+	}
+
+	cmd.SetArg("update", update)
+	return cmd, nil
+}
+
+func (p *Parser) REMOVE(part []string) (*Command, error) {
+	if len(part) < 3 || len(part) > 4 {
+		return nil, fmt.Errorf("usage: REMOVE <key> <filters> [limit]")
+	}
+
+	cmd := NewCommand(REMOVE)
+	cmd.SetArg("key", part[1])
+
+	filters, err := parseObject(part[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid filters: %v", err)
+	}
+	cmd.SetArg("filters", filters)
+
+	if len(part) == 4 {
+		limit, err := strconv.Atoi(part[3])
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit: %s", part[3])
+		}
+		cmd.SetArg("limit", limit)
+	}
+
 	return cmd, nil
 }
 
 func (p *Parser) TTL(part []string) (*Command, error) {
+	return p.ttl(TTL, "TTL", part)
+}
+
+func (p *Parser) PTTL(part []string) (*Command, error) {
+	return p.ttl(PTTL, "PTTL", part)
+}
+
+func (p *Parser) EXPIRETIME(part []string) (*Command, error) {
+	return p.ttl(EXPIRETIME, "EXPIRETIME", part)
+}
+
+func (p *Parser) ttl(cmdType CommandType, name string, part []string) (*Command, error) {
 	if len(part) < 2 {
-		return nil, fmt.Errorf("usage: TTL <key> [filters]")
+		return nil, fmt.Errorf("usage: %s <key> [filters]", name)
 	}
 
-	cmd := NewCommand(TTL)
+	cmd := NewCommand(cmdType)
 	cmd.SetArg("key", part[1])
 
-	if len(part) > 2 {
-		filters := strings.Join(part[2:], " ")
-		cmd.SetArg("filters", filters)
+	if err := parseFilters(cmd, part[2:]); err != nil {
+		return nil, err
 	}
 
 	return cmd, nil
 }
 
 func (p *Parser) EXPIRE(part []string) (*Command, error) {
+	return p.expire(EXPIRE, "EXPIRE <key> <ttl_second|expire_time>", part, false, time.Second)
+}
+
+func (p *Parser) PEXPIRE(part []string) (*Command, error) {
+	return p.expire(PEXPIRE, "PEXPIRE <key> <milliseconds>", part, false, time.Millisecond)
+}
+
+func (p *Parser) EXPIREAT(part []string) (*Command, error) {
+	return p.expire(EXPIREAT, "EXPIREAT <key> <timestamp|expire_time>", part, true, time.Second)
+}
+
+func (p *Parser) PEXPIREAT(part []string) (*Command, error) {
+	return p.expire(PEXPIREAT, "PEXPIREAT <key> <timestamp-ms>", part, true, time.Millisecond)
+}
+
+// * 相對時間存為 ttl，絕對時間換算為 Unix 毫秒存為 expire_at，由伺服器在執行時計算到期時間
+func (p *Parser) expire(cmdType CommandType, usage string, part []string, absolute bool, unit time.Duration) (*Command, error) {
 	if len(part) < 3 {
-		return nil, fmt.Errorf("usage: EXPIRE <key> <ttl_second|expire_time> [filters]")
+		return nil, fmt.Errorf("usage: %s [filters]", usage)
 	}
 
-	cmd := NewCommand(EXPIRE)
+	cmd := NewCommand(cmdType)
 	cmd.SetArg("key", part[1])
 
-	ttl, err := parseTTL(part[2])
-	if err != nil {
-		return nil, fmt.Errorf("invalid expire time: %s", part[2])
+	if absolute {
+		expireAt, err := parseTimestamp(part[2], unit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expire time: %s", part[2])
+		}
+		cmd.SetArg("expire_at", expireAt)
+	} else {
+		ttl, err := parseTTL(part[2], unit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expire time: %s", part[2])
+		}
+		cmd.SetArg("ttl", ttl)
 	}
 
-	// TODO: 檢查
-	cmd.SetArg("ttl", ttl)
-
-	if len(part) > 3 {
-		filters := strings.Join(part[3:], " ")
-		cmd.SetArg("filters", filters)
+	if err := parseFilters(cmd, part[3:]); err != nil {
+		return nil, err
 	}
 
 	return cmd, nil
@@ -251,14 +742,32 @@ func (p *Parser) PERSIST(part []string) (*Command, error) {
 	// TODO: 檢查
 	cmd.SetArg("ttl", 0)
 
-	if len(part) > 2 {
-		filters := strings.Join(part[3:], " ")
-		cmd.SetArg("filters", filters)
+	if err := parseFilters(cmd, part[2:]); err != nil {
+		return nil, err
 	}
 
 	return cmd, nil
 }
 
+// * 選填的文檔條件，有提供時改為針對 COLLECTION 內的文檔操作
+func parseFilters(cmd *Command, part []string) error {
+	if len(part) == 0 {
+		return nil
+	}
+
+	if len(part) > 1 {
+		return fmt.Errorf("too many arguments: %s", strings.Join(part[1:], " "))
+	}
+
+	filters, err := parseObject(part[0])
+	if err != nil {
+		return fmt.Errorf("invalid filters: %v", err)
+	}
+
+	cmd.SetArg("filters", filters)
+	return nil
+}
+
 func (p *Parser) HELP(part []string) (*Command, error) {
 	return NewCommand(HELP), nil
 }
@@ -266,3 +775,173 @@ func (p *Parser) HELP(part []string) (*Command, error) {
 func (p *Parser) PING(part []string) (*Command, error) {
 	return NewCommand(PING), nil
 }
+
+// * HELLO [protover [AUTH <user> <password>]]
+func (p *Parser) HELLO(part []string) (*Command, error) {
+	cmd := NewCommand(HELLO)
+
+	if len(part) > 1 {
+		version, err := strconv.Atoi(part[1])
+		if err != nil {
+			return nil, fmt.Errorf("Protocol version is not an integer or out of range")
+		}
+		cmd.SetArg("version", version)
+	}
+
+	for i := 2; i < len(part); i++ {
+		if !strings.EqualFold(part[i], "AUTH") || i+2 >= len(part) {
+			return nil, fmt.Errorf("Syntax error in HELLO option '%s'", part[i])
+		}
+		cmd.SetArg("user", part[i+1])
+		cmd.SetArg("password", part[i+2])
+		i += 2
+	}
+
+	return cmd, nil
+}
+
+func (p *Parser) INFO(part []string) (*Command, error) {
+	if len(part) > 2 {
+		return nil, fmt.Errorf("usage: INFO [section]")
+	}
+
+	cmd := NewCommand(INFO)
+	if len(part) == 2 {
+		cmd.SetArg("section", strings.ToLower(part[1]))
+	}
+	return cmd, nil
+}
+
+func (p *Parser) REWRITEAOF(part []string) (*Command, error) {
+	if len(part) != 1 {
+		return nil, fmt.Errorf("usage: REWRITEAOF")
+	}
+
+	return NewCommand(REWRITEAOF), nil
+}
+
+func (p *Parser) SAVE(part []string) (*Command, error) {
+	if len(part) != 1 {
+		return nil, fmt.Errorf("usage: SAVE")
+	}
+
+	return NewCommand(SAVE), nil
+}
+
+func (p *Parser) BGSAVE(part []string) (*Command, error) {
+	if len(part) != 1 {
+		return nil, fmt.Errorf("usage: BGSAVE")
+	}
+
+	return NewCommand(BGSAVE), nil
+}
+
+// * WARM <db> [pattern] [LIMIT <keys>] [BYTES <size>]
+func (p *Parser) WARM(part []string) (*Command, error) {
+	usage := fmt.Errorf("usage: WARM <db> [pattern] [LIMIT <keys>] [BYTES <size>]")
+	if len(part) < 2 {
+		return nil, usage
+	}
+
+	db, err := strconv.Atoi(part[1])
+	if err != nil || db < 0 {
+		return nil, fmt.Errorf("invalid database number: %s", part[1])
+	}
+
+	cmd := NewCommand(WARM)
+	cmd.SetArg("db", db)
+	cmd.SetArg("pattern", "*")
+
+	rest := part[2:]
+	if len(rest) > 0 && !strings.EqualFold(rest[0], "LIMIT") && !strings.EqualFold(rest[0], "BYTES") {
+		cmd.SetArg("pattern", rest[0])
+		rest = rest[1:]
+	}
+
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return nil, usage
+		}
+
+		switch strings.ToUpper(rest[0]) {
+		case "LIMIT":
+			keys, err := strconv.Atoi(rest[1])
+			if err != nil || keys < 0 {
+				return nil, fmt.Errorf("invalid key limit: %s", rest[1])
+			}
+			cmd.SetArg("limit", keys)
+		case "BYTES":
+			size, err := util.ParseBytes(rest[1])
+			if err != nil {
+				return nil, err
+			}
+			cmd.SetArg("bytes", size)
+		default:
+			return nil, usage
+		}
+		rest = rest[2:]
+	}
+
+	return cmd, nil
+}
+
+func (p *Parser) CONFIG(part []string) (*Command, error) {
+	if len(part) < 2 {
+		return nil, fmt.Errorf("usage: CONFIG GET <pattern> | CONFIG SET <name> <value> [name value ...] | CONFIG REWRITE")
+	}
+
+	cmd := NewCommand(CONFIG)
+	action := strings.ToUpper(part[1])
+	cmd.SetArg("action", action)
+
+	switch action {
+	case "GET":
+		if len(part) != 3 {
+			return nil, fmt.Errorf("usage: CONFIG GET <pattern>")
+		}
+		cmd.SetArg("pattern", part[2])
+	case "SET":
+		if len(part) < 4 || len(part)%2 != 0 {
+			return nil, fmt.Errorf("usage: CONFIG SET <name> <value> [name value ...]")
+		}
+		cmd.SetArg("pairs", part[2:])
+	case "REWRITE":
+		if len(part) != 2 {
+			return nil, fmt.Errorf("usage: CONFIG REWRITE")
+		}
+	default:
+		return nil, fmt.Errorf("unknown CONFIG subcommand: %s", part[1])
+	}
+
+	return cmd, nil
+}
+
+// * SHUTDOWN [NOSAVE|SAVE]
+func (p *Parser) SHUTDOWN(part []string) (*Command, error) {
+	usage := fmt.Errorf("usage: SHUTDOWN [NOSAVE|SAVE]")
+	if len(part) > 2 {
+		return nil, usage
+	}
+
+	cmd := NewCommand(SHUTDOWN)
+	cmd.SetArg("mode", "")
+	if len(part) == 2 {
+		mode := strings.ToUpper(part[1])
+		if mode != "NOSAVE" && mode != "SAVE" {
+			return nil, usage
+		}
+		cmd.SetArg("mode", mode)
+	}
+	return cmd, nil
+}
+
+func (p *Parser) AUTH(part []string) (*Command, error) {
+	if len(part) != 3 {
+		return nil, fmt.Errorf("usage: AUTH <user> <password>")
+	}
+
+	cmd := NewCommand(AUTH)
+	cmd.SetArg("user", part[1])
+	cmd.SetArg("password", part[2])
+	return cmd, nil
+}
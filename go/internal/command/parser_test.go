@@ -0,0 +1,86 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSET(t *testing.T) {
+	options := []string{"ttl", "expire_at", "keepttl", "condition", "get"}
+
+	tests := []struct {
+		input string
+		want  map[string]interface{}
+	}{
+		{`SET k v`, map[string]interface{}{}},
+		{`SET k v 60`, map[string]interface{}{"ttl": 60 * time.Second}},
+		{`SET k v EX 10`, map[string]interface{}{"ttl": 10 * time.Second}},
+		{`SET k v px 1500`, map[string]interface{}{"ttl": 1500 * time.Millisecond}},
+		{`SET k v EXAT 4102444800`, map[string]interface{}{"expire_at": int64(4102444800000)}},
+		{`SET k v PXAT 4102444800123`, map[string]interface{}{"expire_at": int64(4102444800123)}},
+		{`SET k v KEEPTTL`, map[string]interface{}{"keepttl": true}},
+		{`SET k v NX`, map[string]interface{}{"condition": "NX"}},
+		{`SET k v xx`, map[string]interface{}{"condition": "XX"}},
+		{`SET k v GET`, map[string]interface{}{"get": true}},
+		{`SET k v EX 10 NX`, map[string]interface{}{"ttl": 10 * time.Second, "condition": "NX"}},
+		{`SET k v NX EX 10`, map[string]interface{}{"ttl": 10 * time.Second, "condition": "NX"}},
+		{`SET k v XX PX 5 GET`, map[string]interface{}{"ttl": 5 * time.Millisecond, "condition": "XX", "get": true}},
+		{`SET k v GET KEEPTTL XX`, map[string]interface{}{"keepttl": true, "condition": "XX", "get": true}},
+		{`SET k v NX GET EXAT 4102444800`, map[string]interface{}{"expire_at": int64(4102444800000), "condition": "NX", "get": true}},
+		{`SET k v GET GET`, map[string]interface{}{"get": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			cmd, err := NewParser().Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.input, err)
+			}
+			if cmd.Type != SET || cmd.GetStr("key") != "k" || cmd.GetStr("value") != "v" {
+				t.Fatalf("Parse(%q) = %+v", tt.input, cmd)
+			}
+
+			for _, name := range options {
+				got, isExist := cmd.GetArg(name)
+				want, isWanted := tt.want[name]
+				if isExist != isWanted || !reflect.DeepEqual(got, want) {
+					t.Errorf("Parse(%q) %s = %v (%v), want %v (%v)", tt.input, name, got, isExist, want, isWanted)
+				}
+			}
+		})
+	}
+}
+
+func TestParseSETErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`SET k`, "usage: SET"},
+		{`SET k v EX`, "usage: SET"},
+		{`SET k v EX 10 PX 10`, "usage: SET"},
+		{`SET k v EX 10 KEEPTTL`, "usage: SET"},
+		{`SET k v KEEPTTL EXAT 4102444800`, "usage: SET"},
+		{`SET k v NX XX`, "usage: SET"},
+		{`SET k v NX NX`, "usage: SET"},
+		{`SET k v EX 10 FOO`, "usage: SET"},
+		{`SET k v EX 0`, "invalid expire time in 'SET' command: 0"},
+		{`SET k v PX -1`, "invalid expire time in 'SET' command: -1"},
+		{`SET k v EX abc`, "invalid expire time in 'SET' command: abc"},
+		{`SET k v EX 9223372036854775807`, "invalid expire time in 'SET' command"},
+		{`SET k v EXAT 9223372036854775807`, "invalid expire time in 'SET' command"},
+		{`SET k v -5`, "invalid expire time: -5"},
+		{`SET k v soon`, "invalid expire time: soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := NewParser().Parse(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Parse(%q) error = %v, want %q", tt.input, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// * 單一請求的大小上限
+type Limits struct {
+	Bulk   int
+	Array  int
+	Inline int
+}
+
+var (
+	DefaultLimits = Limits{
+		Bulk:   512 * 1024 * 1024,
+		Array:  1024 * 1024,
+		Inline: 64 * 1024 * 1024,
+	}
+
+	// * 尚未通過驗證的連線只需送出 AUTH 或 HELLO ... AUTH，與 Redis 相同使用較小的上限
+	UnauthLimits = Limits{
+		Bulk:   16 * 1024,
+		Array:  10,
+		Inline: 16 * 1024,
+	}
+)
+
+// * 大型 bulk string 依實際收到的資料分段配置，宣告的長度不會直接決定配置大小
+const bulkChunk = 64 * 1024
+
+// * 讀取一個 RESP 請求 (*N 陣列)，非 * 開頭時視為 inline 指令
+func ReadRESP(reader *bufio.Reader, limits Limits) ([]string, error) {
+	line, err := ReadLine(reader, limits.Inline)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return Tokenize(line)
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count > limits.Array {
+		return nil, fmt.Errorf("Protocol error: invalid multibulk length")
+	}
+
+	list := make([]string, 0, min(max(count, 0), 64))
+	for i := 0; i < count; i++ {
+		header, err := ReadLine(reader, limits.Inline)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("Protocol error: expected '$', got '%.1s'", header)
+		}
+
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 || size > limits.Bulk {
+			return nil, fmt.Errorf("Protocol error: invalid bulk length")
+		}
+
+		data, err := readBulk(reader, size)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, data)
+	}
+
+	return list, nil
+}
+
+func readBulk(reader *bufio.Reader, size int) (string, error) {
+	total := size + 2
+	data := make([]byte, 0, min(total, bulkChunk))
+
+	for len(data) < total {
+		if len(data) == cap(data) {
+			data = slices.Grow(data, min(len(data), total-len(data)))
+		}
+
+		n, err := reader.Read(data[len(data):min(cap(data), total)])
+		data = data[:len(data)+n]
+		if err != nil {
+			if err == io.EOF && len(data) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return "", fmt.Errorf("Protocol error: bulk string not terminated by CRLF")
+	}
+	return string(data[:size]), nil
+}
+
+// * 讀取一行並去除結尾的 CRLF，超過 limit 時回傳錯誤，不會先配置整行的緩衝區
+// * 與 bufio.Reader.ReadString 相同，檔案結尾前沒有換行時同時回傳已讀到的內容與 io.EOF
+func ReadLine(reader *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > limit+2 {
+			return "", fmt.Errorf("Protocol error: too big request")
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return strings.TrimRight(string(line), "\r\n"), err
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
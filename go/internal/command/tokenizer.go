@@ -0,0 +1,138 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+)
+
+// * 拆解指令參數，支援引號字串與完整的 JSON {...} / [...] 參數
+func Tokenize(input string) ([]string, error) {
+	var list []string
+
+	for i := 0; i < len(input); {
+		switch input[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+			continue
+		}
+
+		var token string
+		var next int
+		var err error
+
+		switch input[i] {
+		case '"', '\'':
+			token, next, err = readQuoted(input, i)
+		case '{', '[':
+			token, next, err = readJSON(input, i)
+		default:
+			next = i
+			for next < len(input) && !isSpace(input[next]) {
+				next++
+			}
+			token = input[i:next]
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if next < len(input) && !isSpace(input[next]) {
+			return nil, fmt.Errorf("unexpected character after argument at position %d", next)
+		}
+
+		list = append(list, token)
+		i = next
+	}
+
+	return list, nil
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
+
+// * 雙引號支援 \n \t \r \\ \" 等跳脫，單引號僅支援 \' 與 \\
+func readQuoted(input string, start int) (string, int, error) {
+	quote := input[start]
+	var builder strings.Builder
+
+	for i := start + 1; i < len(input); i++ {
+		ch := input[i]
+
+		if ch == quote {
+			return builder.String(), i + 1, nil
+		}
+
+		if ch == '\\' && i+1 < len(input) {
+			next := input[i+1]
+
+			if quote == '\'' {
+				if next == '\'' || next == '\\' {
+					builder.WriteByte(next)
+					i++
+					continue
+				}
+				builder.WriteByte(ch)
+				continue
+			}
+
+			switch next {
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			case 'r':
+				builder.WriteByte('\r')
+			default:
+				builder.WriteByte(next)
+			}
+			i++
+			continue
+		}
+
+		builder.WriteByte(ch)
+	}
+
+	return "", 0, fmt.Errorf("unterminated quoted string at position %d", start)
+}
+
+// * 讀取括號平衡的 JSON 內容，忽略字串中的括號
+func readJSON(input string, start int) (string, int, error) {
+	var stack []byte
+	inString := false
+
+	for i := start; i < len(input); i++ {
+		ch := input[i]
+
+		if inString {
+			switch ch {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != ch {
+				return "", 0, fmt.Errorf("unbalanced %c at position %d", ch, i)
+			}
+
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return input[start : i+1], i + 1, nil
+			}
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated JSON argument at position %d", start)
+}
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{``, nil},
+		{`   `, nil},
+		{`GET k`, []string{"GET", "k"}},
+		{" SET\tk \r\n v ", []string{"SET", "k", "v"}},
+		{`SET k "hello world"`, []string{"SET", "k", "hello world"}},
+		{`SET k ""`, []string{"SET", "k", ""}},
+		{`SET k "a\"b\\c"`, []string{"SET", "k", `a"b\c`}},
+		{`SET k "a\nb\tc\rd\x"`, []string{"SET", "k", "a\nb\tc\rdx"}},
+		{`SET k 'it\'s'`, []string{"SET", "k", "it's"}},
+		{`SET k 'a\\b\n'`, []string{"SET", "k", `a\b\n`}},
+		{`SET k '"5"'`, []string{"SET", "k", `"5"`}},
+		{`SET k "it's"`, []string{"SET", "k", "it's"}},
+		{`FIND k {"a": {"b": [1, 2]}} 0 10`, []string{"FIND", "k", `{"a": {"b": [1, 2]}}`, "0", "10"}},
+		{`SET k [1, [2, {"x": 3}]]`, []string{"SET", "k", `[1, [2, {"x": 3}]]`}},
+		{`FIND k {"a": "}]{["}`, []string{"FIND", "k", `{"a": "}]{["}`}},
+		{`FIND k {"a": "q\"}"}`, []string{"FIND", "k", `{"a": "q\"}"}`}},
+		{`SET k {}`, []string{"SET", "k", "{}"}},
+		{`SET k a"b`, []string{"SET", "k", `a"b`}},
+		{`SET k a{b}`, []string{"SET", "k", "a{b}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Tokenize(tt.input)
+			if err != nil {
+				t.Fatalf("Tokenize(%q): %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Tokenize(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{`SET k "abc`, "unterminated quoted string at position 6"},
+		{`SET k 'abc`, "unterminated quoted string at position 6"},
+		{`SET k "abc\"`, "unterminated quoted string"},
+		{`FIND k {"a":1`, "unterminated JSON argument at position 7"},
+		{`FIND k {"a":"}"`, "unterminated JSON argument"},
+		{`FIND k [1, 2`, "unterminated JSON argument"},
+		{`FIND k {"a":[1}`, "unbalanced } at position 14"},
+		{`FIND k [1}`, "unbalanced } at position 9"},
+		{`SET k "a"b`, "unexpected character after argument at position 9"},
+		{`SET k 'a'b`, "unexpected character after argument at position 9"},
+		{`FIND k {}x`, "unexpected character after argument at position 9"},
+		{`FIND k [1]]`, "unexpected character after argument at position 10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Tokenize(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Tokenize(%q) error = %v, want %q", tt.input, err, tt.err)
+			}
+		})
+	}
+}
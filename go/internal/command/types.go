@@ -6,6 +6,8 @@
 
 package command
 
+import "time"
+
 type CommandType int
 
 const (
@@ -17,10 +19,17 @@ const (
 	DEL
 	EXISTS
 	KEYS
+	SCAN
 	TYPE
+	INCR
+	DECR
+	INCRBY
+	DECRBY
+	INCRBYFLOAT
 
 	// * DOC 操作
 	FIND
+	FSCAN
 	SORT
 	ADD
 	UPDATE
@@ -28,13 +37,26 @@ const (
 
 	// * TTL 操作
 	TTL
+	PTTL
+	EXPIRETIME
 	EXPIRE
+	PEXPIRE
 	EXPIREAT
+	PEXPIREAT
 	PERSIST
 
 	// * 其他操作
 	HELP
 	PING
+	HELLO
+	INFO
+	REWRITEAOF
+	SAVE
+	BGSAVE
+	WARM
+	CONFIG
+	SHUTDOWN
+	AUTH
 )
 
 type Command struct {
@@ -85,6 +107,24 @@ func (c *Command) GetInt(key string) int {
 	return 0
 }
 
+func (c *Command) GetInt64(key string) int64 {
+	if value, isExist := c.Args[key]; isExist {
+		if i, ok := value.(int64); ok {
+			return i
+		}
+	}
+	return 0
+}
+
+func (c *Command) GetFloat64(key string) float64 {
+	if value, isExist := c.Args[key]; isExist {
+		if f, ok := value.(float64); ok {
+			return f
+		}
+	}
+	return 0
+}
+
 func (c *Command) GetUint64(key string) uint64 {
 	if value, isExist := c.Args[key]; isExist {
 		if u, ok := value.(uint64); ok {
@@ -93,3 +133,28 @@ func (c *Command) GetUint64(key string) uint64 {
 	}
 	return 0
 }
+
+func (c *Command) GetMap(key string) map[string]interface{} {
+	if value, isExist := c.Args[key]; isExist {
+		if m, ok := value.(map[string]interface{}); ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// * 指令指定的過期時間 (Unix 毫秒)，ttl 為相對 now (Unix 毫秒) 的時間，expire_at 為絕對時間
+func (c *Command) ExpireAt(now int64) (int64, bool) {
+	if value, isExist := c.Args["expire_at"]; isExist {
+		if expireAt, ok := value.(int64); ok {
+			return expireAt, true
+		}
+	}
+
+	if value, isExist := c.Args["ttl"]; isExist {
+		if ttl, ok := value.(time.Duration); ok {
+			return now + ttl.Milliseconds(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,129 @@
+package query
+
+import (
+	"sort"
+	"strings"
+)
+
+// * 跨型別排序順序: null < number < string < object < array < boolean
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case float64, int, int64:
+		return 1
+	case string:
+		return 2
+	case map[string]interface{}:
+		return 3
+	case []interface{}:
+		return 4
+	case bool:
+		return 5
+	default:
+		return 6
+	}
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// * 比較任意兩個 JSON 值，回傳 -1 / 0 / 1
+func Compare(a, b interface{}) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	switch va := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return strings.Compare(va, b.(string))
+	case bool:
+		vb := b.(bool)
+		if va == vb {
+			return 0
+		}
+		if !va {
+			return -1
+		}
+		return 1
+	case map[string]interface{}:
+		return compareObject(va, b.(map[string]interface{}))
+	case []interface{}:
+		return compareArray(va, b.([]interface{}))
+	}
+
+	fa, fb := toFloat(a), toFloat(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func compareArray(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if result := Compare(a[i], b[i]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func compareObject(a, b map[string]interface{}) int {
+	keysA := sortedKeys(a)
+	keysB := sortedKeys(b)
+
+	for i := 0; i < len(keysA) && i < len(keysB); i++ {
+		if result := strings.Compare(keysA[i], keysB[i]); result != 0 {
+			return result
+		}
+		if result := Compare(a[keysA[i]], b[keysB[i]]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case len(keysA) < len(keysB):
+		return -1
+	case len(keysA) > len(keysB):
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Equal(a, b interface{}) bool {
+	return Compare(a, b) == 0
+}
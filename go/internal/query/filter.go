@@ -0,0 +1,335 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Filter struct {
+	list []matcher
+}
+
+type matcher interface {
+	match(doc map[string]interface{}) bool
+}
+
+type fieldMatcher struct {
+	path string
+	ops  []operator
+}
+
+type operator struct {
+	name  string
+	value interface{}
+	regex *regexp.Regexp
+	not   *fieldMatcher
+}
+
+type logicMatcher struct {
+	name string
+	list []*Filter
+}
+
+// * 編譯 MongoDB 風格的查詢條件，空條件代表全部符合
+func Compile(filter map[string]interface{}) (*Filter, error) {
+	result := &Filter{}
+
+	for _, key := range sortedKeys(filter) {
+		value := filter[key]
+
+		if strings.HasPrefix(key, "$") {
+			m, err := compileLogic(key, value)
+			if err != nil {
+				return nil, err
+			}
+			result.list = append(result.list, m)
+			continue
+		}
+
+		if obj, ok := value.(map[string]interface{}); ok && isOperator(obj) {
+			m, err := compileField(key, obj)
+			if err != nil {
+				return nil, err
+			}
+			result.list = append(result.list, m)
+			continue
+		}
+
+		result.list = append(result.list, &fieldMatcher{
+			path: key,
+			ops:  []operator{{name: "$eq", value: value}},
+		})
+	}
+
+	return result, nil
+}
+
+func (f *Filter) Match(doc map[string]interface{}) bool {
+	for _, m := range f.list {
+		if !m.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func isOperator(obj map[string]interface{}) bool {
+	if len(obj) == 0 {
+		return false
+	}
+
+	for key := range obj {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+func compileLogic(name string, value interface{}) (matcher, error) {
+	switch name {
+	case "$and", "$or", "$nor":
+	default:
+		return nil, fmt.Errorf("unknown operator: %s", name)
+	}
+
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s requires a non-empty array", name)
+	}
+
+	m := &logicMatcher{name: name}
+	for _, e := range list {
+		obj, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s entries must be objects", name)
+		}
+
+		filter, err := Compile(obj)
+		if err != nil {
+			return nil, err
+		}
+		m.list = append(m.list, filter)
+	}
+
+	return m, nil
+}
+
+func (m *logicMatcher) match(doc map[string]interface{}) bool {
+	switch m.name {
+	case "$and":
+		for _, filter := range m.list {
+			if !filter.Match(doc) {
+				return false
+			}
+		}
+		return true
+	case "$or":
+		for _, filter := range m.list {
+			if filter.Match(doc) {
+				return true
+			}
+		}
+		return false
+	default:
+		for _, filter := range m.list {
+			if filter.Match(doc) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func compileField(path string, obj map[string]interface{}) (*fieldMatcher, error) {
+	m := &fieldMatcher{path: path}
+
+	for _, name := range sortedKeys(obj) {
+		value := obj[name]
+		op := operator{name: name, value: value}
+
+		switch name {
+		case "$eq", "$ne", "$lt", "$lte", "$gt", "$gte":
+		case "$in", "$nin":
+			if _, ok := value.([]interface{}); !ok {
+				return nil, fmt.Errorf("%s requires an array", name)
+			}
+		case "$exists":
+			if _, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("$exists requires a boolean")
+			}
+		case "$regex":
+			pattern, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("$regex requires a string")
+			}
+
+			if options, ok := obj["$options"].(string); ok && options != "" {
+				pattern = "(?" + options + ")" + pattern
+			}
+
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid $regex: %v", err)
+			}
+			op.regex = regex
+		case "$options":
+			if _, ok := obj["$regex"]; !ok {
+				return nil, fmt.Errorf("$options requires $regex")
+			}
+			continue
+		case "$not":
+			sub, ok := value.(map[string]interface{})
+			if !ok || !isOperator(sub) {
+				return nil, fmt.Errorf("$not requires an operator object")
+			}
+
+			not, err := compileField(path, sub)
+			if err != nil {
+				return nil, err
+			}
+			op.not = not
+		default:
+			return nil, fmt.Errorf("unknown operator: %s", name)
+		}
+
+		m.ops = append(m.ops, op)
+	}
+
+	return m, nil
+}
+
+func (m *fieldMatcher) match(doc map[string]interface{}) bool {
+	value, isExist := Lookup(doc, m.path)
+
+	for _, op := range m.ops {
+		if !op.eval(doc, value, isExist) {
+			return false
+		}
+	}
+	return true
+}
+
+func (op operator) eval(doc map[string]interface{}, value interface{}, isExist bool) bool {
+	switch op.name {
+	case "$eq":
+		return matchEqual(value, isExist, op.value)
+	case "$ne":
+		return !matchEqual(value, isExist, op.value)
+	case "$lt", "$lte", "$gt", "$gte":
+		if !isExist {
+			return false
+		}
+		return anyOf(value, func(v interface{}) bool {
+			// * 僅同型別比較大小
+			if typeRank(v) != typeRank(op.value) {
+				return false
+			}
+
+			result := Compare(v, op.value)
+			switch op.name {
+			case "$lt":
+				return result < 0
+			case "$lte":
+				return result <= 0
+			case "$gt":
+				return result > 0
+			default:
+				return result >= 0
+			}
+		})
+	case "$in", "$nin":
+		isIn := false
+		for _, target := range op.value.([]interface{}) {
+			if matchEqual(value, isExist, target) {
+				isIn = true
+				break
+			}
+		}
+		return isIn == (op.name == "$in")
+	case "$exists":
+		return isExist == op.value.(bool)
+	case "$regex":
+		if !isExist {
+			return false
+		}
+		return anyOf(value, func(v interface{}) bool {
+			str, ok := v.(string)
+			return ok && op.regex.MatchString(str)
+		})
+	case "$not":
+		return !op.not.match(doc)
+	}
+	return false
+}
+
+// * 欄位為陣列時，陣列本身或任一元素符合即可
+func anyOf(value interface{}, fn func(interface{}) bool) bool {
+	if fn(value) {
+		return true
+	}
+
+	if list, ok := value.([]interface{}); ok {
+		for _, e := range list {
+			if fn(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchEqual(value interface{}, isExist bool, target interface{}) bool {
+	if !isExist {
+		return target == nil
+	}
+	return anyOf(value, func(v interface{}) bool {
+		return Equal(v, target)
+	})
+}
+
+// * 以 a.b.c 路徑取得欄位，支援陣列索引與子文檔陣列
+func Lookup(doc map[string]interface{}, path string) (interface{}, bool) {
+	return lookup(doc, strings.Split(path, "."))
+}
+
+func lookup(current interface{}, segments []string) (interface{}, bool) {
+	if len(segments) == 0 {
+		return current, true
+	}
+
+	switch v := current.(type) {
+	case map[string]interface{}:
+		next, isExist := v[segments[0]]
+		if !isExist {
+			return nil, false
+		}
+		return lookup(next, segments[1:])
+	case []interface{}:
+		if index, err := strconv.Atoi(segments[0]); err == nil {
+			if index < 0 || index >= len(v) {
+				return nil, false
+			}
+			return lookup(v[index], segments[1:])
+		}
+
+		var list []interface{}
+		for _, e := range v {
+			if _, ok := e.(map[string]interface{}); !ok {
+				continue
+			}
+			if value, isExist := lookup(e, segments); isExist {
+				list = append(list, value)
+			}
+		}
+
+		if len(list) == 0 {
+			return nil, false
+		}
+		return list, true
+	}
+
+	return nil, false
+}
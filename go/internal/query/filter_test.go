@@ -0,0 +1,183 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decode(t *testing.T, text string) map[string]interface{} {
+	t.Helper()
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &obj); err != nil {
+		t.Fatalf("invalid JSON %s: %v", text, err)
+	}
+	return obj
+}
+
+func decodeValue(t *testing.T, text string) interface{} {
+	t.Helper()
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		t.Fatalf("invalid JSON %s: %v", text, err)
+	}
+	return value
+}
+
+func TestMatch(t *testing.T) {
+	doc := `{"name":"John","age":30,"score":9.5,"tags":["a","b"],"active":true,"none":null,
+		"address":{"city":"Taipei","zip":"100"},
+		"items":[{"sku":"x1","qty":2},{"sku":"x2","qty":5}],
+		"matrix":[[1,2],[3]]}`
+
+	tests := []struct {
+		name   string
+		filter string
+		want   bool
+	}{
+		{"empty filter", `{}`, true},
+		{"implicit $eq", `{"name":"John"}`, true},
+		{"implicit $eq mismatch", `{"name":"Jane"}`, false},
+		{"int equals float", `{"age":30.0}`, true},
+		{"implicit AND", `{"name":"John","age":30}`, true},
+		{"implicit AND with one mismatch", `{"name":"John","age":31}`, false},
+		{"$eq", `{"age":{"$eq":30}}`, true},
+		{"$ne", `{"age":{"$ne":30}}`, false},
+		{"$ne on a missing field", `{"missing":{"$ne":1}}`, true},
+		{"$lt", `{"age":{"$lt":31}}`, true},
+		{"$lt equal", `{"age":{"$lt":30}}`, false},
+		{"$lte", `{"age":{"$lte":30}}`, true},
+		{"$gt", `{"score":{"$gt":9}}`, true},
+		{"$gte", `{"score":{"$gte":9.5}}`, true},
+		{"$gte mismatch", `{"score":{"$gte":10}}`, false},
+		{"range", `{"age":{"$gt":20,"$lt":40}}`, true},
+		{"range mismatch", `{"age":{"$gt":20,"$lt":30}}`, false},
+		{"$gt across types", `{"name":{"$gt":1}}`, false},
+		{"$lt on a missing field", `{"missing":{"$lt":1}}`, false},
+		{"string range", `{"name":{"$gte":"J","$lt":"K"}}`, true},
+		{"$in", `{"age":{"$in":[1,30]}}`, true},
+		{"$in mismatch", `{"age":{"$in":[1,2]}}`, false},
+		{"$nin", `{"age":{"$nin":[1,2]}}`, true},
+		{"$nin mismatch", `{"age":{"$nin":[30]}}`, false},
+		{"$in null matches a missing field", `{"missing":{"$in":[null]}}`, true},
+		{"$regex", `{"name":{"$regex":"^Jo"}}`, true},
+		{"$regex mismatch", `{"name":{"$regex":"^jo"}}`, false},
+		{"$regex with $options", `{"name":{"$regex":"^jo","$options":"i"}}`, true},
+		{"$regex on a number", `{"age":{"$regex":"30"}}`, false},
+		{"$exists", `{"name":{"$exists":true}}`, true},
+		{"$exists false", `{"missing":{"$exists":false}}`, true},
+		{"$exists on null", `{"none":{"$exists":true}}`, true},
+		{"null matches a missing field", `{"missing":null}`, true},
+		{"null matches a null field", `{"none":null}`, true},
+		{"$not", `{"age":{"$not":{"$gt":40}}}`, true},
+		{"$not mismatch", `{"age":{"$not":{"$lt":40}}}`, false},
+		{"dotted path", `{"address.city":"Taipei"}`, true},
+		{"embedded document", `{"address":{"city":"Taipei","zip":"100"}}`, true},
+		{"embedded document with other field order", `{"address":{"zip":"100","city":"Taipei"}}`, true},
+		{"embedded document subset", `{"address":{"city":"Taipei"}}`, false},
+		{"array contains element", `{"tags":"a"}`, true},
+		{"array contains element mismatch", `{"tags":"c"}`, false},
+		{"array equals whole", `{"tags":["a","b"]}`, true},
+		{"array order matters", `{"tags":["b","a"]}`, false},
+		{"array $in", `{"tags":{"$in":["z","b"]}}`, true},
+		{"array $nin", `{"tags":{"$nin":["b"]}}`, false},
+		{"array $regex", `{"tags":{"$regex":"^b$"}}`, true},
+		{"array index", `{"tags.1":"b"}`, true},
+		{"array index out of range", `{"tags.5":{"$exists":true}}`, false},
+		{"array of documents", `{"items.sku":"x2"}`, true},
+		{"array of documents range", `{"items.qty":{"$gt":4}}`, true},
+		{"array of documents range mismatch", `{"items.qty":{"$gt":5}}`, false},
+		{"nested array element", `{"matrix":[3]}`, true},
+		{"$and", `{"$and":[{"age":30},{"name":"John"}]}`, true},
+		{"$and mismatch", `{"$and":[{"age":30},{"name":"Jane"}]}`, false},
+		{"$or", `{"$or":[{"age":1},{"name":"John"}]}`, true},
+		{"$or mismatch", `{"$or":[{"age":1},{"name":"Jane"}]}`, false},
+		{"$nor", `{"$nor":[{"age":1},{"name":"Jane"}]}`, true},
+		{"$nor mismatch", `{"$nor":[{"age":30}]}`, false},
+		{"boolean", `{"active":true}`, true},
+		{"boolean is not a number", `{"active":1}`, false},
+	}
+
+	data := decode(t, doc)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := Compile(decode(t, tt.filter))
+			if err != nil {
+				t.Fatalf("Compile %s: %v", tt.filter, err)
+			}
+			if got := filter.Match(data); got != tt.want {
+				t.Fatalf("Match %s = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{`{"age":{"$foo":1}}`, "unknown operator: $foo"},
+		{`{"$xor":[{"a":1}]}`, "unknown operator: $xor"},
+		{`{"age":{"$in":1}}`, "$in requires an array"},
+		{`{"age":{"$nin":"a"}}`, "$nin requires an array"},
+		{`{"age":{"$exists":1}}`, "$exists requires a boolean"},
+		{`{"name":{"$regex":1}}`, "$regex requires a string"},
+		{`{"name":{"$regex":"("}}`, "invalid $regex"},
+		{`{"name":{"$regex":"a","$options":"z"}}`, "invalid $regex"},
+		{`{"name":{"$options":"i"}}`, "$options requires $regex"},
+		{`{"age":{"$not":1}}`, "$not requires an operator object"},
+		{`{"age":{"$not":{"$foo":1}}}`, "unknown operator: $foo"},
+		{`{"$and":[]}`, "$and requires a non-empty array"},
+		{`{"$or":{"a":1}}`, "$or requires a non-empty array"},
+		{`{"$nor":[1]}`, "$nor entries must be objects"},
+		{`{"$and":[{"a":{"$bad":1}}]}`, "unknown operator: $bad"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := Compile(decode(t, tt.filter))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Compile %s error = %v, want %q", tt.filter, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// * 依跨型別順序排列: null < number < string < object < array < boolean
+	ordered := []string{
+		`null`,
+		`-1`, `0`, `1.5`, `2`,
+		`""`, `"a"`, `"ab"`, `"b"`,
+		`{}`, `{"a":1}`, `{"a":2}`, `{"a":2,"b":1}`, `{"b":0}`,
+		`[]`, `[1]`, `[1,2]`, `[2]`,
+		`false`, `true`,
+	}
+
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+
+			if got := Compare(decodeValue(t, a), decodeValue(t, b)); got != want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+
+	// * Go 的整數與 JSON 解出的浮點數視為相同數值
+	if got := Compare(2, 2.0); got != 0 {
+		t.Errorf("Compare(2, 2.0) = %d, want 0", got)
+	}
+	if got := Compare(int64(3), 2.5); got != 1 {
+		t.Errorf("Compare(int64(3), 2.5) = %d, want 1", got)
+	}
+}
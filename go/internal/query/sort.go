@@ -0,0 +1,66 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type SortField struct {
+	Path  string
+	Order int
+}
+
+// * 依序解析排序條件，保留 JSON 欄位順序，1 為升冪、-1 為降冪
+func ParseSort(data []byte) ([]SortField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("sort specification must be an object")
+	}
+
+	var list []SortField
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		path := token.(string)
+
+		var order float64
+		if err := decoder.Decode(&order); err != nil || (order != 1 && order != -1) {
+			return nil, fmt.Errorf("sort order of %s must be 1 or -1", path)
+		}
+
+		list = append(list, SortField{
+			Path:  path,
+			Order: int(order),
+		})
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("sort specification is empty")
+	}
+
+	return list, nil
+}
+
+// * 穩定排序，欄位相同時保留原順序
+func Sort(list []map[string]interface{}, fields []SortField) {
+	sort.SliceStable(list, func(i, j int) bool {
+		for _, field := range fields {
+			a, _ := Lookup(list[i], field.Path)
+			b, _ := Lookup(list[j], field.Path)
+
+			if result := Compare(a, b); result != 0 {
+				return result*field.Order < 0
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,146 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decodeList(t *testing.T, text string) []map[string]interface{} {
+	t.Helper()
+
+	var list []map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &list); err != nil {
+		t.Fatalf("invalid JSON %s: %v", text, err)
+	}
+	return list
+}
+
+func ids(list []map[string]interface{}) string {
+	result := make([]string, 0, len(list))
+	for _, doc := range list {
+		id, _ := doc["_id"].(string)
+		result = append(result, id)
+	}
+	return strings.Join(result, ",")
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		name string
+		docs string
+		sort string
+		want string
+	}{
+		{
+			name: "ascending",
+			docs: `[{"_id":"a","n":3},{"_id":"b","n":1},{"_id":"c","n":2}]`,
+			sort: `{"n":1}`,
+			want: "b,c,a",
+		},
+		{
+			name: "descending",
+			docs: `[{"_id":"a","n":3},{"_id":"b","n":1},{"_id":"c","n":2}]`,
+			sort: `{"n":-1}`,
+			want: "a,c,b",
+		},
+		{
+			name: "multiple fields",
+			docs: `[{"_id":"a","g":2,"n":1},{"_id":"b","g":1,"n":1},{"_id":"c","g":2,"n":3},{"_id":"d","g":1,"n":2}]`,
+			sort: `{"g":1,"n":-1}`,
+			want: "d,b,c,a",
+		},
+		{
+			name: "field order decides priority",
+			docs: `[{"_id":"a","g":2,"n":1},{"_id":"b","g":1,"n":1},{"_id":"c","g":2,"n":3},{"_id":"d","g":1,"n":2}]`,
+			sort: `{"n":-1,"g":1}`,
+			want: "c,d,b,a",
+		},
+		{
+			name: "mixed types follow the type order",
+			docs: `[{"_id":"bool","v":true},{"_id":"arr","v":[1]},{"_id":"obj","v":{"a":1}},{"_id":"str","v":"x"},{"_id":"num","v":5},{"_id":"null","v":null}]`,
+			sort: `{"v":1}`,
+			want: "null,num,str,obj,arr,bool",
+		},
+		{
+			name: "mixed types descending",
+			docs: `[{"_id":"num","v":5},{"_id":"str","v":"x"},{"_id":"bool","v":false}]`,
+			sort: `{"v":-1}`,
+			want: "bool,str,num",
+		},
+		{
+			name: "integer and float values compare numerically",
+			docs: `[{"_id":"a","v":2.5},{"_id":"b","v":2},{"_id":"c","v":10}]`,
+			sort: `{"v":1}`,
+			want: "b,a,c",
+		},
+		{
+			name: "missing field sorts like null",
+			docs: `[{"_id":"a","v":1},{"_id":"b"},{"_id":"c","v":null},{"_id":"d","v":0}]`,
+			sort: `{"v":1}`,
+			want: "b,c,d,a",
+		},
+		{
+			name: "missing field last when descending",
+			docs: `[{"_id":"b"},{"_id":"a","v":1},{"_id":"d","v":0}]`,
+			sort: `{"v":-1}`,
+			want: "a,d,b",
+		},
+		{
+			name: "dotted path",
+			docs: `[{"_id":"a","p":{"x":2}},{"_id":"b","p":{"x":1}}]`,
+			sort: `{"p.x":1}`,
+			want: "b,a",
+		},
+		{
+			name: "stable for equal keys",
+			docs: `[{"_id":"a","n":1},{"_id":"b","n":0},{"_id":"c","n":1},{"_id":"d","n":0},{"_id":"e","n":1}]`,
+			sort: `{"n":1}`,
+			want: "b,d,a,c,e",
+		},
+		{
+			name: "stable for equal keys descending",
+			docs: `[{"_id":"a","n":1},{"_id":"b","n":0},{"_id":"c","n":1},{"_id":"d","n":0},{"_id":"e","n":1}]`,
+			sort: `{"n":-1}`,
+			want: "a,c,e,b,d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := ParseSort([]byte(tt.sort))
+			if err != nil {
+				t.Fatalf("ParseSort %s: %v", tt.sort, err)
+			}
+
+			list := decodeList(t, tt.docs)
+			Sort(list, fields)
+			if got := ids(list); got != tt.want {
+				t.Fatalf("Sort %s = %s, want %s", tt.sort, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	fields, err := ParseSort([]byte(`{"b":1,"a":-1,"c.d":1}`))
+	if err != nil {
+		t.Fatalf("ParseSort: %v", err)
+	}
+
+	want := []SortField{{"b", 1}, {"a", -1}, {"c.d", 1}}
+	if len(fields) != len(want) {
+		t.Fatalf("ParseSort = %v, want %v", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Fatalf("ParseSort = %v, want %v", fields, want)
+		}
+	}
+
+	for _, input := range []string{`[]`, `{}`, `{"a":0}`, `{"a":"asc"}`, `{"a":2}`, `{"a":1`} {
+		if _, err := ParseSort([]byte(input)); err == nil {
+			t.Errorf("ParseSort %s: expected error", input)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Update struct {
+	list []updateOp
+}
+
+type updateOp struct {
+	name   string
+	path   string
+	value  interface{}
+	filter *Filter
+}
+
+// * 編譯更新操作，僅接受 $ 開頭的更新運算子
+func CompileUpdate(update map[string]interface{}) (*Update, error) {
+	if len(update) == 0 {
+		return nil, fmt.Errorf("update is empty")
+	}
+
+	result := &Update{}
+
+	for _, name := range sortedKeys(update) {
+		fields, ok := update[name].(map[string]interface{})
+		if !ok {
+			if !strings.HasPrefix(name, "$") {
+				return nil, fmt.Errorf("update requires operators, got field: %s", name)
+			}
+			return nil, fmt.Errorf("%s requires an object", name)
+		}
+
+		for _, path := range sortedKeys(fields) {
+			if path == "_id" || strings.HasPrefix(path, "_id.") {
+				return nil, fmt.Errorf("field _id is immutable")
+			}
+
+			op := updateOp{
+				name:  name,
+				path:  path,
+				value: fields[path],
+			}
+
+			switch name {
+			case "$set", "$unset", "$push", "$addToSet":
+			case "$inc", "$mul":
+				if typeRank(op.value) != 1 {
+					return nil, fmt.Errorf("%s on %s requires a number", name, path)
+				}
+			case "$rename":
+				target, ok := op.value.(string)
+				if !ok || target == "" {
+					return nil, fmt.Errorf("$rename on %s requires a field name", path)
+				}
+				if target == "_id" || strings.HasPrefix(target, "_id.") {
+					return nil, fmt.Errorf("field _id is immutable")
+				}
+			case "$pull":
+				filter, err := compilePull(op.value)
+				if err != nil {
+					return nil, err
+				}
+				op.filter = filter
+			default:
+				return nil, fmt.Errorf("unknown update operator: %s", name)
+			}
+
+			result.list = append(result.list, op)
+		}
+	}
+
+	return result, nil
+}
+
+// * $pull 條件: 運算子物件比對元素本身，一般物件比對子文檔
+func compilePull(value interface{}) (*Filter, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	if isOperator(obj) {
+		return Compile(map[string]interface{}{"v": obj})
+	}
+	return Compile(obj)
+}
+
+// * 套用更新於文檔副本，回傳新文檔與是否有變動
+func (u *Update) Apply(doc map[string]interface{}) (map[string]interface{}, bool, error) {
+	result := Clone(doc).(map[string]interface{})
+
+	for _, op := range u.list {
+		if err := op.apply(result); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return result, !Equal(doc, result), nil
+}
+
+func (op updateOp) apply(doc map[string]interface{}) error {
+	current, isExist := getPath(doc, op.path)
+
+	switch op.name {
+	case "$set":
+		return setPath(doc, op.path, Clone(op.value))
+	case "$unset":
+		unsetPath(doc, op.path)
+		return nil
+	case "$inc", "$mul":
+		if !isExist {
+			if op.name == "$mul" {
+				return setPath(doc, op.path, float64(0))
+			}
+			return setPath(doc, op.path, toFloat(op.value))
+		}
+
+		if typeRank(current) != 1 {
+			return fmt.Errorf("cannot apply %s to non-numeric field %s", op.name, op.path)
+		}
+
+		if op.name == "$inc" {
+			return setPath(doc, op.path, toFloat(current)+toFloat(op.value))
+		}
+		return setPath(doc, op.path, toFloat(current)*toFloat(op.value))
+	case "$rename":
+		if !isExist {
+			return nil
+		}
+		unsetPath(doc, op.path)
+		return setPath(doc, op.value.(string), current)
+	case "$push", "$addToSet":
+		list, err := arrayAt(current, isExist, op)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range eachValue(op.value) {
+			if op.name == "$addToSet" && contains(list, e) {
+				continue
+			}
+			list = append(list, Clone(e))
+		}
+		return setPath(doc, op.path, list)
+	case "$pull":
+		if !isExist {
+			return nil
+		}
+
+		list, err := arrayAt(current, isExist, op)
+		if err != nil {
+			return err
+		}
+
+		kept := make([]interface{}, 0, len(list))
+		for _, e := range list {
+			if !op.pullMatch(e) {
+				kept = append(kept, e)
+			}
+		}
+		return setPath(doc, op.path, kept)
+	}
+
+	return nil
+}
+
+func (op updateOp) pullMatch(value interface{}) bool {
+	if op.filter == nil {
+		return Equal(value, op.value)
+	}
+
+	if obj, ok := op.value.(map[string]interface{}); ok && isOperator(obj) {
+		return op.filter.Match(map[string]interface{}{"v": value})
+	}
+
+	doc, ok := value.(map[string]interface{})
+	return ok && op.filter.Match(doc)
+}
+
+func arrayAt(current interface{}, isExist bool, op updateOp) ([]interface{}, error) {
+	if !isExist || current == nil {
+		return []interface{}{}, nil
+	}
+
+	list, ok := current.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot apply %s to non-array field %s", op.name, op.path)
+	}
+	return list, nil
+}
+
+// * 支援 {"$each": [...]} 一次加入多個值
+func eachValue(value interface{}) []interface{} {
+	if obj, ok := value.(map[string]interface{}); ok && len(obj) == 1 {
+		if list, ok := obj["$each"].([]interface{}); ok {
+			return list
+		}
+	}
+	return []interface{}{value}
+}
+
+func contains(list []interface{}, value interface{}) bool {
+	for _, e := range list {
+		if Equal(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// * 嚴格路徑取值，不展開陣列中的子文檔
+func getPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = doc
+
+	for _, segment := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			next, isExist := v[segment]
+			if !isExist {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			current = v[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func setPath(doc map[string]interface{}, path string, value interface{}) error {
+	segments := strings.Split(path, ".")
+	var current interface{} = doc
+
+	for i, segment := range segments {
+		isLast := i == len(segments)-1
+
+		switch v := current.(type) {
+		case map[string]interface{}:
+			if isLast {
+				v[segment] = value
+				return nil
+			}
+
+			next, isExist := v[segment]
+			if !isExist || next == nil {
+				next = make(map[string]interface{})
+				v[segment] = next
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return fmt.Errorf("cannot set field %s: invalid array index %s", path, segment)
+			}
+
+			if isLast {
+				v[index] = value
+				return nil
+			}
+
+			if v[index] == nil {
+				v[index] = make(map[string]interface{})
+			}
+			current = v[index]
+		default:
+			return fmt.Errorf("cannot set field %s: parent is not an object", path)
+		}
+	}
+
+	return nil
+}
+
+func unsetPath(doc map[string]interface{}, path string) {
+	segments := strings.Split(path, ".")
+	parentPath := strings.Join(segments[:len(segments)-1], ".")
+	last := segments[len(segments)-1]
+
+	var parent interface{} = doc
+	if parentPath != "" {
+		value, isExist := getPath(doc, parentPath)
+		if !isExist {
+			return
+		}
+		parent = value
+	}
+
+	switch v := parent.(type) {
+	case map[string]interface{}:
+		delete(v, last)
+	case []interface{}:
+		if index, err := strconv.Atoi(last); err == nil && index >= 0 && index < len(v) {
+			v[index] = nil
+		}
+	}
+}
+
+// * 深拷貝 JSON 值
+func Clone(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			obj[key] = Clone(e)
+		}
+		return obj
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = Clone(e)
+		}
+		return list
+	default:
+		return v
+	}
+}
@@ -0,0 +1,217 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	doc := `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},
+		"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`
+
+	tests := []struct {
+		name    string
+		update  string
+		want    string
+		changed bool
+	}{
+		{
+			name:    "$set",
+			update:  `{"$set":{"name":"Jane","p.y":2}}`,
+			want:    `{"_id":"d1","name":"Jane","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1,"y":2},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:   "$set with the same value",
+			update: `{"$set":{"name":"John"}}`,
+			want:   doc,
+		},
+		{
+			name:    "$set creates nested objects",
+			update:  `{"$set":{"a.b.c":1}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}],"a":{"b":{"c":1}}}`,
+			changed: true,
+		},
+		{
+			name:    "$set array index",
+			update:  `{"$set":{"items.1.qty":6}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":6}]}`,
+			changed: true,
+		},
+		{
+			name:    "$unset",
+			update:  `{"$unset":{"name":"","p.x":"","missing":""}}`,
+			want:    `{"_id":"d1","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:    "$inc",
+			update:  `{"$inc":{"n":-3,"p.x":0.5,"count":2}}`,
+			want:    `{"_id":"d1","name":"John","n":-1,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1.5},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}],"count":2}`,
+			changed: true,
+		},
+		{
+			name:    "$mul",
+			update:  `{"$mul":{"n":2.5,"missing":3}}`,
+			want:    `{"_id":"d1","name":"John","n":5,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}],"missing":0}`,
+			changed: true,
+		},
+		{
+			name:    "$rename",
+			update:  `{"$rename":{"name":"full_name","missing":"other"}}`,
+			want:    `{"_id":"d1","full_name":"John","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:    "$push",
+			update:  `{"$push":{"tags":"a","new":1}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b","a"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}],"new":[1]}`,
+			changed: true,
+		},
+		{
+			name:    "$push $each",
+			update:  `{"$push":{"tags":{"$each":["c","d"]}}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b","c","d"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:    "$push an object",
+			update:  `{"$push":{"items":{"sku":"x3","qty":0}}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5},{"sku":"x3","qty":0}]}`,
+			changed: true,
+		},
+		{
+			name:   "$addToSet existing value",
+			update: `{"$addToSet":{"tags":"a"}}`,
+			want:   doc,
+		},
+		{
+			name:    "$addToSet $each",
+			update:  `{"$addToSet":{"tags":{"$each":["b","c","c"]}}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b","c"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:    "$pull value",
+			update:  `{"$pull":{"nums":2}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1,3],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:    "$pull with an operator",
+			update:  `{"$pull":{"nums":{"$gte":2}}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+		{
+			name:    "$pull documents",
+			update:  `{"$pull":{"items":{"qty":{"$gt":3}}}}`,
+			want:    `{"_id":"d1","name":"John","n":2,"tags":["a","b"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1}]}`,
+			changed: true,
+		},
+		{
+			name:   "$pull from a missing field",
+			update: `{"$pull":{"missing":1}}`,
+			want:   doc,
+		},
+		{
+			name:    "several operators",
+			update:  `{"$set":{"name":"Jane"},"$inc":{"n":1},"$push":{"tags":"c"}}`,
+			want:    `{"_id":"d1","name":"Jane","n":3,"tags":["a","b","c"],"nums":[1,2,3,2],"p":{"x":1},"items":[{"sku":"x1","qty":1},{"sku":"x2","qty":5}]}`,
+			changed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update, err := CompileUpdate(decode(t, tt.update))
+			if err != nil {
+				t.Fatalf("CompileUpdate %s: %v", tt.update, err)
+			}
+
+			original := decode(t, doc)
+			result, changed, err := update.Apply(original)
+			if err != nil {
+				t.Fatalf("Apply %s: %v", tt.update, err)
+			}
+
+			if !Equal(result, decode(t, tt.want)) {
+				data, _ := json.Marshal(result)
+				t.Fatalf("Apply %s = %s, want %s", tt.update, data, tt.want)
+			}
+			if changed != tt.changed {
+				t.Fatalf("Apply %s changed = %v, want %v", tt.update, changed, tt.changed)
+			}
+
+			// * 更新套用在副本上，原文檔不變
+			if !Equal(original, decode(t, doc)) {
+				t.Fatalf("Apply %s modified the original document", tt.update)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	doc := `{"_id":"d1","name":"John","n":2,"tags":["a"]}`
+
+	tests := []struct {
+		update string
+		err    string
+	}{
+		{`{"$inc":{"name":1}}`, "cannot apply $inc to non-numeric field name"},
+		{`{"$mul":{"tags":2}}`, "cannot apply $mul to non-numeric field tags"},
+		{`{"$push":{"name":"x"}}`, "cannot apply $push to non-array field name"},
+		{`{"$addToSet":{"n":1}}`, "cannot apply $addToSet to non-array field n"},
+		{`{"$pull":{"name":"x"}}`, "cannot apply $pull to non-array field name"},
+		{`{"$set":{"name.first":"x"}}`, "parent is not an object"},
+		{`{"$set":{"tags.5":"x"}}`, "invalid array index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.update, func(t *testing.T) {
+			update, err := CompileUpdate(decode(t, tt.update))
+			if err != nil {
+				t.Fatalf("CompileUpdate %s: %v", tt.update, err)
+			}
+
+			original := decode(t, doc)
+			_, _, err = update.Apply(original)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Apply %s error = %v, want %q", tt.update, err, tt.err)
+			}
+			if !Equal(original, decode(t, doc)) {
+				t.Fatalf("failed Apply %s modified the original document", tt.update)
+			}
+		})
+	}
+}
+
+func TestCompileUpdateErrors(t *testing.T) {
+	tests := []struct {
+		update string
+		err    string
+	}{
+		{`{}`, "update is empty"},
+		{`{"name":"John"}`, "update requires operators, got field: name"},
+		{`{"$set":1}`, "$set requires an object"},
+		{`{"$foo":{"a":1}}`, "unknown update operator: $foo"},
+		{`{"$set":{"_id":"x"}}`, "field _id is immutable"},
+		{`{"$unset":{"_id.a":""}}`, "field _id is immutable"},
+		{`{"$inc":{"n":"1"}}`, "$inc on n requires a number"},
+		{`{"$mul":{"n":true}}`, "$mul on n requires a number"},
+		{`{"$rename":{"a":1}}`, "$rename on a requires a field name"},
+		{`{"$rename":{"a":""}}`, "$rename on a requires a field name"},
+		{`{"$rename":{"a":"_id"}}`, "field _id is immutable"},
+		{`{"$pull":{"a":{"$foo":1}}}`, "unknown operator: $foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.update, func(t *testing.T) {
+			_, err := CompileUpdate(decode(t, tt.update))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("CompileUpdate %s error = %v, want %q", tt.update, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"go-jsondb/internal/command"
+	"go-jsondb/internal/storage"
+)
+
+const (
+	categoryRead    = "read"
+	categoryWrite   = "write"
+	categoryAdmin   = "admin"
+	categoryConnect = "connection"
+)
+
+type commandSpec struct {
+	name     string
+	category string
+}
+
+// * 指令名稱與 ACL 分類，connection 類指令登入後皆可使用
+var commandSpecs = map[command.CommandType]commandSpec{
+	command.GET:         {"get", categoryRead},
+	command.EXISTS:      {"exists", categoryRead},
+	command.KEYS:        {"keys", categoryRead},
+	command.SCAN:        {"scan", categoryRead},
+	command.TYPE:        {"type", categoryRead},
+	command.FIND:        {"find", categoryRead},
+	command.FSCAN:       {"fscan", categoryRead},
+	command.SORT:        {"sort", categoryRead},
+	command.TTL:         {"ttl", categoryRead},
+	command.PTTL:        {"pttl", categoryRead},
+	command.EXPIRETIME:  {"expiretime", categoryRead},
+	command.SET:         {"set", categoryWrite},
+	command.INCR:        {"incr", categoryWrite},
+	command.DECR:        {"decr", categoryWrite},
+	command.INCRBY:      {"incrby", categoryWrite},
+	command.DECRBY:      {"decrby", categoryWrite},
+	command.INCRBYFLOAT: {"incrbyfloat", categoryWrite},
+	command.DEL:         {"del", categoryWrite},
+	command.ADD:         {"add", categoryWrite},
+	command.UPDATE:      {"update", categoryWrite},
+	command.REMOVE:      {"remove", categoryWrite},
+	command.EXPIRE:      {"expire", categoryWrite},
+	command.PEXPIRE:     {"pexpire", categoryWrite},
+	command.EXPIREAT:    {"expireat", categoryWrite},
+	command.PEXPIREAT:   {"pexpireat", categoryWrite},
+	command.PERSIST:     {"persist", categoryWrite},
+
+	command.INFO:       {"info", categoryAdmin},
+	command.REWRITEAOF: {"rewriteaof", categoryAdmin},
+	command.SAVE:       {"save", categoryAdmin},
+	command.BGSAVE:     {"bgsave", categoryAdmin},
+	command.WARM:       {"warm", categoryAdmin},
+	command.CONFIG:     {"config", categoryAdmin},
+	command.SHUTDOWN:   {"shutdown", categoryAdmin},
+
+	command.SELECT: {"select", categoryConnect},
+	command.HELP:   {"help", categoryConnect},
+	command.PING:   {"ping", categoryConnect},
+	command.HELLO:  {"hello", categoryConnect},
+	command.AUTH:   {"auth", categoryConnect},
+}
+
+type aclRule struct {
+	allow    bool
+	category string
+	command  string
+}
+
+type aclUser struct {
+	name      string
+	password  string
+	disabled  bool
+	rules     []aclRule
+	databases map[int]bool
+	keys      []string
+}
+
+type aclList struct {
+	users map[string]*aclUser
+
+	// * 使用者不存在時仍計算一次雜湊，避免由回應時間判斷帳號是否存在
+	dummy string
+
+	// * 同時計算密碼雜湊的數量上限，大量 AUTH 不會佔滿所有 CPU
+	hashing chan struct{}
+}
+
+const (
+	// * 連續驗證失敗後延遲回覆，每次失敗加倍，直到上限
+	authFailureDelay    = 100 * time.Millisecond
+	authFailureMaxDelay = 5 * time.Second
+)
+
+// * 讀取使用者檔案，未設定時不啟用驗證
+func loadACL(path string) (*aclList, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	users, err := storage.LoadUsersFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dummy, err := storage.HashPassword("")
+	if err != nil {
+		return nil, err
+	}
+
+	acl := &aclList{
+		users:   make(map[string]*aclUser, len(users)),
+		dummy:   dummy,
+		hashing: make(chan struct{}, max(1, runtime.NumCPU()/2)),
+	}
+	for _, e := range users {
+		user := &aclUser{
+			name:     e.Name,
+			password: e.Password,
+			disabled: e.Disabled,
+			keys:     e.Keys,
+		}
+
+		for _, str := range e.Commands {
+			rule, err := parseRule(str)
+			if err != nil {
+				return nil, fmt.Errorf("users file %s: user %s: %v", path, e.Name, err)
+			}
+			user.rules = append(user.rules, rule)
+		}
+
+		if e.Databases != nil {
+			user.databases = make(map[int]bool, len(e.Databases))
+			for _, db := range e.Databases {
+				user.databases[db] = true
+			}
+		}
+
+		acl.users[e.Name] = user
+	}
+
+	slog.Info("Authentication enabled", "users", len(acl.users), "path", path)
+	return acl, nil
+}
+
+// * +@read、-@admin、+get、-del 等規則，@all 代表所有指令
+func parseRule(str string) (aclRule, error) {
+	if len(str) < 2 || (str[0] != '+' && str[0] != '-') {
+		return aclRule{}, fmt.Errorf("invalid command rule '%s', expected +<command>, -<command>, +@<category> or -@<category>", str)
+	}
+
+	rule := aclRule{allow: str[0] == '+'}
+	name := strings.ToLower(str[1:])
+
+	if category, isCategory := strings.CutPrefix(name, "@"); isCategory {
+		switch category {
+		case "all", categoryRead, categoryWrite, categoryAdmin, categoryConnect:
+			rule.category = category
+			return rule, nil
+		}
+		return aclRule{}, fmt.Errorf("unknown command category '%s'", str[1:])
+	}
+
+	for _, spec := range commandSpecs {
+		if spec.name == name {
+			rule.command = name
+			return rule, nil
+		}
+	}
+	return aclRule{}, fmt.Errorf("unknown command '%s'", str[1:])
+}
+
+// * 規則依序套用，後面的規則覆蓋前面的結果，預設拒絕
+func (user *aclUser) allowCommand(cmdType command.CommandType) bool {
+	spec := commandSpecs[cmdType]
+	if spec.category == categoryConnect {
+		return true
+	}
+
+	allow := false
+	for _, rule := range user.rules {
+		if rule.command == spec.name || rule.category == "all" || rule.category == spec.category {
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+func (user *aclUser) allowDB(db int) bool {
+	return user.databases == nil || user.databases[db]
+}
+
+func (user *aclUser) allowKey(key string) bool {
+	if user.keys == nil {
+		return true
+	}
+
+	for _, pattern := range user.keys {
+		if matchPattern(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func (acl *aclList) authenticate(name, password string) (*aclUser, bool) {
+	acl.hashing <- struct{}{}
+	defer func() { <-acl.hashing }()
+
+	user, isExist := acl.users[name]
+	if !isExist {
+		storage.VerifyPassword(acl.dummy, password)
+		return nil, false
+	}
+
+	if !storage.VerifyPassword(user.password, password) || user.disabled {
+		return nil, false
+	}
+	return user, true
+}
+
+// * 未啟用驗證時視為已通過驗證
+func (c *Client) Authenticated() bool {
+	return c.server.acl == nil || c.user != nil
+}
+
+// * 檢查目前連線的使用者能否執行指令，未啟用驗證時一律允許
+func (c *Client) checkACL(cmd *command.Command) error {
+	acl := c.server.acl
+	if acl == nil {
+		return nil
+	}
+
+	switch cmd.Type {
+	case command.AUTH, command.PING, command.HELP:
+		return nil
+	case command.HELLO:
+		// * HELLO <protover> AUTH <user> <password> 於指令中驗證
+		if _, hasAuth := cmd.GetArg("user"); hasAuth {
+			return nil
+		}
+	}
+
+	user := c.user
+	if user == nil {
+		return fmt.Errorf("NOAUTH Authentication required")
+	}
+
+	spec := commandSpecs[cmd.Type]
+	if !user.allowCommand(cmd.Type) {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", user.name, spec.name)
+	}
+
+	// * SELECT 與 WARM 檢查指定的資料庫，其餘指令檢查目前的資料庫
+	db := c.db
+	if _, isExist := cmd.GetArg("db"); isExist {
+		db = cmd.GetInt("db")
+	}
+	if (spec.category != categoryConnect || cmd.Type == command.SELECT) && !user.allowDB(db) {
+		return fmt.Errorf("NOPERM User %s has no permissions to access DB %d", user.name, db)
+	}
+
+	keys := cmd.GetStrAry("keys")
+	if _, isExist := cmd.GetArg("key"); isExist {
+		keys = []string{cmd.GetStr("key")}
+	}
+	for _, key := range keys {
+		if !user.allowKey(key) {
+			return fmt.Errorf("NOPERM User %s has no permissions to access the '%s' key", user.name, key)
+		}
+	}
+
+	return nil
+}
+
+// * AUTH <user> <password>
+// * 同一連線連續失敗時延遲回覆，避免以單一連線快速嘗試密碼
+func (c *Client) AUTH(cmd *command.Command) Reply {
+	acl := c.server.acl
+	if acl == nil {
+		return Errorf("AUTH called without any users configured, set users-file to enable authentication")
+	}
+
+	name := cmd.GetStr("user")
+	user, ok := acl.authenticate(name, cmd.GetStr("password"))
+	if !ok {
+		c.authFailures++
+		slog.Warn("Authentication failed", "user", name, "failures", c.authFailures)
+
+		delay := authFailureMaxDelay
+		if c.authFailures <= 6 {
+			delay = min(authFailureDelay<<(c.authFailures-1), authFailureMaxDelay)
+		}
+		time.Sleep(delay)
+		return Errorf("WRONGPASS invalid username-password pair or user is disabled")
+	}
+
+	c.authFailures = 0
+	c.user = user
+	return Status("OK")
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"go-jsondb/internal/storage"
+	"go-jsondb/internal/testutil"
+)
+
+func openACLServer(t *testing.T) *Server {
+	t.Helper()
+
+	config := testConfig(t)
+	config.Option.UsersFile = testutil.WriteUsers(t, t.TempDir(), []storage.User{
+		{Name: "admin", Password: "admin-pass", Commands: []string{"+@all"}},
+		{Name: "reader", Password: "reader-pass", Commands: []string{"+@read", "-find"}, Databases: []int{0}, Keys: []string{"user:*"}},
+		{Name: "writer", Password: "writer-pass", Commands: []string{"+@write", "-del", "+get"}, Databases: []int{1}},
+		{Name: "off", Password: "off-pass", Disabled: true, Commands: []string{"+@all"}},
+	})
+	return openServer(t, config)
+}
+
+// * 依序執行指令，回應需以 want 開頭
+func expect(t *testing.T, client *Client, steps [][2]string) {
+	t.Helper()
+
+	for _, step := range steps {
+		if got := exec(t, client, step[0]).Text(); !strings.HasPrefix(got, step[1]) {
+			t.Errorf("%s = %q, want prefix %q", step[0], got, step[1])
+		}
+	}
+}
+
+func TestACLRules(t *testing.T) {
+	server := openACLServer(t)
+	defer server.Close()
+
+	admin := server.NewClient()
+	expect(t, admin, [][2]string{
+		{`GET user:1`, `Error: NOAUTH`},
+		{`PING`, `PONG`},
+		{`AUTH admin admin-pass`, `OK`},
+		{`SET user:1 1`, `OK`},
+		{`SET user:2 2`, `OK`},
+		{`SET other:1 3`, `OK`},
+		{`ADD user:docs {"_id":"d1"}`, `d1`},
+		{`INFO server`, `# Server`},
+	})
+
+	reader := server.NewClient()
+	expect(t, reader, [][2]string{
+		{`AUTH reader reader-pass`, `OK`},
+		{`GET user:1`, `1`},
+		{`FSCAN user:docs 0`, `1) 0`},
+		{`SET user:1 5`, `Error: NOPERM User reader has no permissions to run the 'set' command`},
+		{`FIND user:docs {}`, `Error: NOPERM User reader has no permissions to run the 'find' command`},
+		{`INFO`, `Error: NOPERM User reader has no permissions to run the 'info' command`},
+		{`GET other:1`, `Error: NOPERM User reader has no permissions to access the 'other:1' key`},
+		{`TTL other:1`, `Error: NOPERM User reader has no permissions to access the 'other:1' key`},
+		{`SELECT 1`, `Error: NOPERM User reader has no permissions to access DB 1`},
+		{`GET user:1`, `1`},
+	})
+
+	writer := server.NewClient()
+	expect(t, writer, [][2]string{
+		{`AUTH writer writer-pass`, `OK`},
+		{`GET x`, `Error: NOPERM User writer has no permissions to access DB 0`},
+		{`SELECT 1`, `OK`},
+		{`SET x 1`, `OK`},
+		{`INCR x`, `(integer) 2`},
+		{`GET x`, `2`},
+		{`DEL x`, `Error: NOPERM User writer has no permissions to run the 'del' command`},
+		{`TYPE x`, `Error: NOPERM User writer has no permissions to run the 'type' command`},
+		{`SAVE`, `Error: NOPERM User writer has no permissions to run the 'save' command`},
+		{`SELECT 0`, `Error: NOPERM User writer has no permissions to access DB 0`},
+	})
+}
+
+// * KEYS 與 SCAN 只回傳使用者可存取的 KEY
+func TestACLKeyFiltering(t *testing.T) {
+	server := openACLServer(t)
+	defer server.Close()
+
+	admin := server.NewClient()
+	expect(t, admin, [][2]string{{`AUTH admin admin-pass`, `OK`}})
+
+	want := make([]string, 0)
+	for i := range 30 {
+		for _, prefix := range []string{"user:", "other:"} {
+			key := prefix + fmt.Sprint(i)
+			if reply := exec(t, admin, "SET "+key+" 1"); reply.IsError() {
+				t.Fatalf("SET %s: %s", key, reply.Str)
+			}
+			if prefix == "user:" {
+				want = append(want, key)
+			}
+		}
+	}
+	sort.Strings(want)
+
+	reader := server.NewClient()
+	expect(t, reader, [][2]string{{`AUTH reader reader-pass`, `OK`}})
+
+	reply := exec(t, reader, `KEYS *`)
+	got := make([]string, 0, len(reply.List))
+	for _, e := range reply.List {
+		got = append(got, e.Str)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("KEYS * = %v, want %v", got, want)
+	}
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := exec(t, reader, "SCAN "+cursor+" COUNT 5")
+		if reply.IsError() || len(reply.List) != 2 {
+			t.Fatalf("SCAN %s = %q", cursor, reply.Text())
+		}
+		for _, e := range reply.List[1].List {
+			seen[e.Str] = true
+		}
+		if cursor = reply.List[0].Str; cursor == "0" {
+			break
+		}
+	}
+
+	got = got[:0]
+	for key := range seen {
+		got = append(got, key)
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("SCAN returned %v, want %v", got, want)
+	}
+
+	// * 未限制 KEY 的使用者可看到全部
+	if reply := exec(t, admin, `KEYS *`); len(reply.List) != 60 {
+		t.Fatalf("admin KEYS * returned %d keys, want 60", len(reply.List))
+	}
+}
+
+func TestACLWrongPassword(t *testing.T) {
+	server := openACLServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "wrong password", input: `AUTH reader admin-pass`},
+		{name: "unknown user", input: `AUTH nobody reader-pass`},
+		{name: "disabled user", input: `AUTH off off-pass`},
+		{name: "HELLO with a wrong password", input: `HELLO 3 AUTH reader wrong`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := server.NewClient()
+			expect(t, client, [][2]string{
+				{tt.input, `Error: WRONGPASS invalid username-password pair or user is disabled`},
+				{`GET user:1`, `Error: NOAUTH Authentication required`},
+			})
+			if client.Authenticated() {
+				t.Fatalf("%s: client is authenticated", tt.input)
+			}
+			if client.Proto() != 2 {
+				t.Fatalf("%s: protocol switched to %d", tt.input, client.Proto())
+			}
+		})
+	}
+}
+
+func TestACLHelloAuth(t *testing.T) {
+	server := openACLServer(t)
+	defer server.Close()
+
+	client := server.NewClient()
+	expect(t, client, [][2]string{{`HELLO 3`, `Error: NOAUTH`}})
+
+	reply := exec(t, client, `HELLO 3 AUTH reader reader-pass`)
+	if reply.Type != ReplyMap {
+		t.Fatalf("HELLO 3 AUTH = %q, want a map", reply.Text())
+	}
+	if !client.Authenticated() || client.Proto() != 3 {
+		t.Fatalf("after HELLO AUTH: authenticated %v, proto %d", client.Authenticated(), client.Proto())
+	}
+
+	// * HELLO 驗證的使用者同樣受規則限制
+	expect(t, client, [][2]string{
+		{`GET user:1`, `(nil)`},
+		{`GET other:1`, `Error: NOPERM`},
+		{`SET user:1 1`, `Error: NOPERM`},
+	})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-jsondb/internal/command"
+)
+
+const (
+	benchKeys    = 1000
+	benchWriters = 2
+)
+
+// * 直接呼叫 Server，不經過網路，量測鎖與分片的擴展性；以 -cpu 1,2,4,8 比較不同核心數
+func newBenchServer(b *testing.B) (*Server, []*command.Command, []*command.Command) {
+	b.Helper()
+
+	level := slog.SetLogLoggerLevel(slog.LevelWarn)
+	b.Cleanup(func() { slog.SetLogLoggerLevel(level) })
+
+	config := testConfig(b)
+	config.Option.AppendFsync = "no"
+
+	server, err := NewServer(config)
+	if err != nil {
+		b.Fatalf("NewServer: %v", err)
+	}
+	b.Cleanup(func() { server.Close() })
+
+	value := strings.Repeat("x", 64)
+	client := server.NewClient()
+	gets := make([]*command.Command, benchKeys)
+	sets := make([]*command.Command, benchKeys)
+	for i := range benchKeys {
+		key := fmt.Sprintf("key:%d", i)
+		sets[i] = parse(b, fmt.Sprintf("SET %s %s", key, value))
+		gets[i] = parse(b, "GET "+key)
+
+		if reply := client.Exec(sets[i]); reply.IsError() {
+			b.Fatalf("SET %s: %s", key, reply.Str)
+		}
+	}
+	return server, gets, sets
+}
+
+func parse(tb testing.TB, input string) *command.Command {
+	tb.Helper()
+
+	cmd, err := command.NewParser().Parse(input)
+	if err != nil {
+		tb.Fatalf("Parse %q: %v", input, err)
+	}
+	return cmd
+}
+
+func BenchmarkGet(b *testing.B) {
+	server, gets, _ := newBenchServer(b)
+
+	var seed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		client := server.NewClient()
+		i := int(seed.Add(7919))
+		for pb.Next() {
+			client.Exec(gets[i%benchKeys])
+			i++
+		}
+	})
+}
+
+func BenchmarkSet(b *testing.B) {
+	server, _, sets := newBenchServer(b)
+
+	var seed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		client := server.NewClient()
+		i := int(seed.Add(7919))
+		for pb.Next() {
+			client.Exec(sets[i%benchKeys])
+			i++
+		}
+	})
+}
+
+// * 與 jsondb-benchmark 相同的情境: 固定數量的寫入者持續 SET，量測並行 GET 的吞吐量
+func BenchmarkGetUnderSet(b *testing.B) {
+	server, gets, sets := newBenchServer(b)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var writes atomic.Int64
+	for w := range benchWriters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			client := server.NewClient()
+			for i := w * benchKeys / benchWriters; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				client.Exec(sets[i%benchKeys])
+				writes.Add(1)
+			}
+		}()
+	}
+
+	var seed atomic.Int64
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		client := server.NewClient()
+		i := int(seed.Add(7919))
+		for pb.Next() {
+			client.Exec(gets[i%benchKeys])
+			i++
+		}
+	})
+	b.StopTimer()
+	elapsed := time.Since(start)
+
+	close(stop)
+	wg.Wait()
+	b.ReportMetric(float64(writes.Load())/elapsed.Seconds(), "sets/s")
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"fmt"
 	"strings"
 
 	"go-jsondb/internal/command"
@@ -9,10 +8,38 @@ import (
 
 type Client struct {
 	db     int
+	proto  int
+	user   *aclUser
 	server *Server
+
+	// * 連續驗證失敗的次數，用於延遲下一次回覆
+	authFailures int
 }
 
-func (c *Client) Exec(cmd *command.Command) string {
+func (c *Client) Exec(cmd *command.Command) Reply {
+	if c.server.closing.Load() {
+		return Errorf("%v", errShuttingDown)
+	}
+
+	if err := c.checkACL(cmd); err != nil {
+		return Errorf("%v", err)
+	}
+
+	// * 超過 maxmemory 時先淘汰 KEY，無法淘汰則拒絕會增加用量的指令
+	if denyOOM(cmd.Type) {
+		if err := c.server.freeMemory(); err != nil {
+			return Errorf("%v", err)
+		}
+	}
+
+	res := c.dispatch(cmd)
+
+	// * 讀回已移出記憶體的 KEY 也會增加用量
+	c.server.freeMemory()
+	return res
+}
+
+func (c *Client) dispatch(cmd *command.Command) Reply {
 	switch cmd.Type {
 	// * KV 操作
 	case command.GET:
@@ -25,19 +52,33 @@ func (c *Client) Exec(cmd *command.Command) string {
 		return c.EXISTS(cmd)
 	case command.KEYS:
 		return c.KEYS(cmd)
+	case command.SCAN:
+		return c.SCAN(cmd)
+	case command.INCR, command.DECR, command.INCRBY, command.DECRBY:
+		return c.INCR(cmd)
+	case command.INCRBYFLOAT:
+		return c.INCRBYFLOAT(cmd)
 	case command.TYPE:
 		return c.TYPE(cmd)
 
 	// * DOC 操作
 	case command.FIND:
 		return c.FIND(cmd)
+	case command.FSCAN:
+		return c.FSCAN(cmd)
+	case command.SORT:
+		return c.SORT(cmd)
 	case command.ADD:
 		return c.ADD(cmd)
+	case command.UPDATE:
+		return c.UPDATE(cmd)
+	case command.REMOVE:
+		return c.REMOVE(cmd)
 
 	// * TTL 操作
-	case command.TTL:
+	case command.TTL, command.PTTL, command.EXPIRETIME:
 		return c.TTL(cmd)
-	case command.EXPIRE:
+	case command.EXPIRE, command.PEXPIRE, command.EXPIREAT, command.PEXPIREAT:
 		return c.EXPIRE(cmd)
 	case command.PERSIST:
 		return c.PERSIST(cmd)
@@ -49,64 +90,148 @@ func (c *Client) Exec(cmd *command.Command) string {
 		return c.HELP(cmd)
 	case command.PING:
 		return c.PING(cmd)
+	case command.HELLO:
+		return c.HELLO(cmd)
+	case command.INFO:
+		return c.INFO(cmd)
+	case command.REWRITEAOF:
+		return c.REWRITEAOF(cmd)
+	case command.SAVE:
+		return c.SAVE(cmd)
+	case command.BGSAVE:
+		return c.BGSAVE(cmd)
+	case command.WARM:
+		return c.WARM(cmd)
+	case command.CONFIG:
+		return c.CONFIG(cmd)
+	case command.SHUTDOWN:
+		return c.SHUTDOWN(cmd)
+	case command.AUTH:
+		return c.AUTH(cmd)
 
 	default:
-		return fmt.Sprintf("Error: Unknown command type: %v", cmd.Type)
+		return Errorf("unknown command type: %v", cmd.Type)
 	}
 }
 
-func (c *Client) SELECT(cmd *command.Command) string {
+func (c *Client) SELECT(cmd *command.Command) Reply {
 	db := cmd.GetInt("db")
 
-	if db < 0 || db > 15 {
-		return "Error: DB index is out of range (0-15)"
+	if err := c.server.validDB(db); err != nil {
+		return Errorf("%v", err)
 	}
 
-	c.server.mu.Lock()
-	err := c.server.checkDB(db)
-	c.server.mu.Unlock()
-	if err != nil {
-		return fmt.Sprintf("Error initializing database %d: %v", db, err)
+	if _, err := c.server.openDB(db); err != nil {
+		return Errorf("failed to initialize database %d: %v", db, err)
 	}
 
 	c.db = db
 
-	return "OK"
+	return Status("OK")
 }
 
-func (c *Client) HELP(cmd *command.Command) string {
+func (c *Client) HELP(cmd *command.Command) Reply {
 	str := `
 JsonDB Commands:
 
 KV operations:
   GET <key>                    - Get value by key
   SET <key> <value> [ttl]      - Set key-value pair with optional TTL
+  SET <key> <value> [EX s|PX ms|EXAT ts|PXAT ms|KEEPTTL] [NX|XX] [GET]
+                               - Set with expiration, condition or old value
   DEL <key1> [key2] ...        - Delete one or more keys  
   EXISTS <key>                 - Check if key exists
   KEYS <pattern>               - Find keys matching pattern
+  SCAN <cursor> [MATCH pattern] [COUNT count] [TYPE type]
+                               - Iterate keys incrementally
   TYPE <key>                   - Get value type of key
+  INCR <key> / DECR <key>      - Increment or decrement an int value by 1
+  INCRBY <key> <increment> / DECRBY <key> <decrement>
+                               - Increment or decrement an int value
+  INCRBYFLOAT <key> <increment>
+                               - Increment an int or float value
 
 DOC operations:
-  FIND <key> [filters]         - Query documents with filters
+  FIND <key> [filters] [page] [offset]
+                               - Query documents with filters
+  FSCAN <key> <cursor> [MATCH filters] [COUNT count]
+                               - Iterate documents incrementally
+  SORT <key> <filters> <sort_by> [page] [offset]
+                               - Query and sort documents
   ADD <key> <value>            - Add document to collection
+  UPDATE <key> <filters> <update>
+                               - Update documents with $set/$inc/...
+  REMOVE <key> <filters> [limit]
+                               - Remove documents matching filters
 
 TTL operations:
-  TTL <key> [filters]          - Get remaining TTL
-  EXPIRE <key> <seconds>       - Set key expiration
+  TTL <key> [filters]          - Get remaining TTL of key or documents
+  PTTL <key> [filters]         - Get remaining TTL in milliseconds
+  EXPIRETIME <key> [filters]   - Get expiration as Unix timestamp
+  EXPIRE <key> <seconds> [filters]
+                               - Set key or document expiration
+  PEXPIRE <key> <milliseconds> [filters]
+                               - Set expiration in milliseconds
+  EXPIREAT <key> <timestamp> [filters]
+                               - Set expiration as Unix timestamp
+  PEXPIREAT <key> <timestamp-ms> [filters]
+                               - Set expiration as Unix timestamp in milliseconds
+  PERSIST <key> [filters]      - Remove key or document expiration
 
 Database:
-  SELECT <db_number>           - Select database (0-15)
+  SELECT <db_number>           - Select database (0 to databases-1)
+
+Server:
+  INFO [section]               - Show server, memory, warming, expiry, persistence and keyspace stats
+  CONFIG GET <pattern>         - Show configuration values
+  CONFIG SET <name> <value>    - Change a runtime configuration value
+  CONFIG REWRITE               - Save configuration to the config file
+  REWRITEAOF                   - Compact AOF files in background
+  SAVE                         - Write a snapshot of all databases
+  BGSAVE                       - Write a snapshot in background
+  SHUTDOWN [NOSAVE|SAVE]       - Flush AOF files and stop the server
+  WARM <db> [pattern] [LIMIT <keys>] [BYTES <size>]
+                               - Preload hottest evicted keys in background
 
 Utility:
+  AUTH <user> <password>       - Authenticate when a users file is configured
   PING                         - Test connection
+  HELLO [2|3 [AUTH user pass]] - Switch RESP protocol version, optionally authenticating
   HELP                         - Show this help
   QUIT/EXIT                    - Close connection
 
 Note: Advanced features are currently in progress.
 `
-	return strings.TrimSpace(str)
+	return Bulk(strings.TrimSpace(str))
+}
+
+func (c *Client) PING(cmd *command.Command) Reply {
+	return Status("PONG")
 }
 
-func (c *Client) PING(cmd *command.Command) string {
-	return "PONG"
+func (c *Client) HELLO(cmd *command.Command) Reply {
+	version := c.proto
+	if _, hasVersion := cmd.GetArg("version"); hasVersion {
+		version = cmd.GetInt("version")
+		if version != 2 && version != 3 {
+			return Errorf("NOPROTO unsupported protocol version")
+		}
+	}
+
+	// * 驗證失敗時不切換協定版本
+	if _, hasAuth := cmd.GetArg("user"); hasAuth {
+		if reply := c.AUTH(cmd); reply.IsError() {
+			return reply
+		}
+	}
+	c.proto = version
+
+	return Map(
+		Bulk("server"), Bulk("jsondb"),
+		Bulk("version"), Bulk(Version),
+		Bulk("proto"), Integer(int64(c.proto)),
+		Bulk("mode"), Bulk("standalone"),
+		Bulk("role"), Bulk("master"),
+		Bulk("modules"), Array(),
+	)
 }
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go-jsondb/internal/command"
+)
+
+func (c *Client) REWRITEAOF(cmd *command.Command) Reply {
+	if err := c.server.startRewrite(); err != nil {
+		return Errorf("%v", err)
+	}
+	return Status("Background append only file rewriting started")
+}
+
+func (c *Client) SAVE(cmd *command.Command) Reply {
+	if err := c.server.startSave(false); err != nil {
+		return Errorf("%v", err)
+	}
+	return Status("OK")
+}
+
+func (c *Client) BGSAVE(cmd *command.Command) Reply {
+	if err := c.server.startSave(true); err != nil {
+		return Errorf("%v", err)
+	}
+	return Status("Background saving started")
+}
+
+func (c *Client) WARM(cmd *command.Command) Reply {
+	db := cmd.GetInt("db")
+	if err := c.server.validDB(db); err != nil {
+		return Errorf("%v", err)
+	}
+
+	if _, err := c.server.openDB(db); err != nil {
+		return Errorf("%v", err)
+	}
+
+	option := c.server.option()
+	keys := option.WarmKeys
+	if _, isExist := cmd.GetArg("limit"); isExist {
+		keys = cmd.GetInt("limit")
+	}
+
+	bytes := option.WarmBytes
+	if _, isExist := cmd.GetArg("bytes"); isExist {
+		bytes = cmd.GetInt64("bytes")
+	}
+
+	if err := c.server.startWarm(db, cmd.GetStr("pattern"), keys, bytes); err != nil {
+		return Errorf("%v", err)
+	}
+	return Status("Cache warming started")
+}
+
+// * 以 Redis INFO 格式輸出 # Section 與 key:value
+func (c *Client) INFO(cmd *command.Command) Reply {
+	section := cmd.GetStr("section")
+
+	var list []string
+	for _, e := range c.server.infoSections() {
+		if section != "" && section != "all" && section != e.name {
+			continue
+		}
+		list = append(list, e.build())
+	}
+
+	if len(list) == 0 {
+		return Bulk("")
+	}
+	return Bulk(strings.Join(list, "\r\n"))
+}
+
+type infoSection struct {
+	name  string
+	build func() string
+}
+
+func (s *Server) infoSections() []infoSection {
+	return []infoSection{
+		{"server", s.infoServer},
+		{"memory", s.infoMemory},
+		{"warming", s.infoWarming},
+		{"expiry", s.infoExpiry},
+		{"persistence", s.infoPersistence},
+		{"keyspace", s.infoKeyspace},
+	}
+}
+
+func formatInfo(title string, list [][2]string) string {
+	var builder strings.Builder
+	builder.WriteString("# " + title + "\r\n")
+	for _, e := range list {
+		builder.WriteString(e[0] + ":" + e[1] + "\r\n")
+	}
+	return builder.String()
+}
+
+func (s *Server) infoServer() string {
+	return formatInfo("Server", [][2]string{
+		{"jsondb_version", Version},
+		{"uptime_in_seconds", fmt.Sprintf("%d", int64(time.Since(s.startedAt).Seconds()))},
+	})
+}
+
+func (s *Server) infoWarming() string {
+	s.warm.mu.Lock()
+	defer s.warm.mu.Unlock()
+
+	return formatInfo("Warming", [][2]string{
+		{"warm_in_progress", boolInfo(s.warm.running)},
+		{"warm_db", fmt.Sprintf("%d", s.warm.db)},
+		{"warm_pattern", s.warm.pattern},
+		{"warm_progress", fmt.Sprintf("%d/%d", s.warm.loaded, s.warm.total)},
+		{"warm_loaded_bytes", fmt.Sprintf("%d", s.warm.bytes)},
+		{"warm_runs", fmt.Sprintf("%d", s.warm.count)},
+		{"warm_last_status", s.warm.lastStatus},
+		{"warm_last_error", s.warm.lastError},
+		{"warm_last_time_ms", fmt.Sprintf("%d", s.warm.lastTime.Milliseconds())},
+	})
+}
+
+func (s *Server) infoPersistence() string {
+	s.rewrite.mu.Lock()
+	state := [][2]string{
+		{"aof_rewrite_in_progress", boolInfo(s.rewrite.running)},
+		{"aof_rewrite_db", fmt.Sprintf("%d", s.rewrite.db)},
+		{"aof_rewrite_progress", fmt.Sprintf("%d/%d", s.rewrite.written, s.rewrite.total)},
+		{"aof_rewrites", fmt.Sprintf("%d", s.rewrite.count)},
+		{"aof_last_rewrite_status", s.rewrite.lastStatus},
+		{"aof_last_rewrite_error", s.rewrite.lastError},
+		{"aof_last_rewrite_time_ms", fmt.Sprintf("%d", s.rewrite.lastTime.Milliseconds())},
+	}
+	s.rewrite.mu.Unlock()
+
+	s.snapshot.mu.Lock()
+	state = append(state,
+		[2]string{"snapshot_in_progress", boolInfo(s.snapshot.running)},
+		[2]string{"snapshot_saves", fmt.Sprintf("%d", s.snapshot.saves)},
+		[2]string{"snapshot_last_save_time", fmt.Sprintf("%d", s.snapshot.lastSave)},
+		[2]string{"snapshot_last_status", s.snapshot.lastStatus},
+		[2]string{"snapshot_last_error", s.snapshot.lastError},
+		[2]string{"snapshot_last_size", fmt.Sprintf("%d", s.snapshot.lastSize)},
+		[2]string{"snapshot_last_time_ms", fmt.Sprintf("%d", s.snapshot.lastTime.Milliseconds())},
+	)
+	s.snapshot.mu.Unlock()
+
+	state = append(state, [2]string{"aof_fsync", s.option().AppendFsync})
+	for _, d := range s.databases() {
+		size, base := d.writer.Size()
+		state = append(state,
+			[2]string{fmt.Sprintf("aof_db%d_current_size", d.id), fmt.Sprintf("%d", size)},
+			[2]string{fmt.Sprintf("aof_db%d_base_size", d.id), fmt.Sprintf("%d", base)},
+			[2]string{fmt.Sprintf("aof_db%d_pending_fsync", d.id), fmt.Sprintf("%d", d.writer.Pending())},
+		)
+	}
+
+	return formatInfo("Persistence", state)
+}
+
+func (s *Server) infoMemory() string {
+	option := s.option()
+	return formatInfo("Memory", [][2]string{
+		{"used_memory", fmt.Sprintf("%d", s.memory.used.Load())},
+		{"maxmemory", fmt.Sprintf("%d", option.MaxMemory)},
+		{"maxmemory_policy", option.MaxMemoryPolicy},
+		{"maxmemory_samples", fmt.Sprintf("%d", option.MaxMemorySamples)},
+		{"evicted_keys", fmt.Sprintf("%d", s.memory.evicted.Load())},
+		{"keys_on_disk", fmt.Sprintf("%d", s.memory.onDisk.Load())},
+		{"disk_loads", fmt.Sprintf("%d", s.memory.loaded.Load())},
+		{"disk_load_errors", fmt.Sprintf("%d", s.memory.loadError.Load())},
+	})
+}
+
+func (s *Server) infoKeyspace() string {
+	var state [][2]string
+	for _, d := range s.databases() {
+		keys, expires := 0, 0
+
+		d.mu.RLock()
+		for i := range d.shards {
+			sh := &d.shards[i]
+			sh.mu.RLock()
+			for _, entry := range sh.data {
+				keys++
+				if entry.ExpireAt != nil {
+					expires++
+				}
+			}
+			sh.mu.RUnlock()
+		}
+		d.mu.RUnlock()
+
+		state = append(state, [2]string{
+			fmt.Sprintf("db%d", d.id),
+			fmt.Sprintf("keys=%d,expires=%d", keys, expires),
+		})
+	}
+
+	return formatInfo("Keyspace", state)
+}
+
+func boolInfo(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
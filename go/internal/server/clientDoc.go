@@ -1,23 +1,347 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"go-jsondb/internal/command"
+	"go-jsondb/internal/query"
+	"go-jsondb/internal/storage"
 )
 
-// TODO: 實現 FIND
-func (c *Client) FIND(cmd *command.Command) string {
+const defaultPageSize = 10
+
+func (c *Client) FIND(cmd *command.Command) Reply {
+	key := cmd.GetStr("key")
+
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
+
+	lock := c.server.rlockKey(c.db, key)
+	defer lock.release()
+
+	list, isExist, err := findDocs(lock.entry, filter)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	if !isExist {
+		return Nil()
+	}
+
+	return encodeDocs(paginate(list, cmd))
+}
+
+// * 依 _id 順序分批回傳文檔，MATCH 在取出 COUNT 個文檔後才套用，回傳 [下一個游標, 文檔 JSON 陣列]
+func (c *Client) FSCAN(cmd *command.Command) Reply {
+	key := cmd.GetStr("key")
+
+	after, err := parseDocCursor(cmd.GetStr("cursor"))
+	if err != nil {
+		return Errorf("%v", err)
+	}
+
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
+
+	lock := c.server.rlockKey(c.db, key)
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return Array(Bulk("0"), encodeDocs(make([]map[string]interface{}, 0)))
+	}
+
+	if !entry.IsCollection() {
+		return wrongType(entry)
+	}
+
+	next, docs := scanDocs(entry, after, cmd.GetInt("count"), time.Now().UnixMilli())
+
+	list := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		if filter.Match(doc.Data) {
+			list = append(list, doc.Data)
+		}
+	}
+
+	return Array(Bulk(next), encodeDocs(list))
+}
+
+func (c *Client) SORT(cmd *command.Command) Reply {
+	key := cmd.GetStr("key")
+
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
+
+	fields, ok := cmd.Args["sort"].([]query.SortField)
+	if !ok {
+		return Errorf("missing sort specification")
+	}
+
+	lock := c.server.rlockKey(c.db, key)
+	defer lock.release()
+
+	list, isExist, err := findDocs(lock.entry, filter)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	if !isExist {
+		return Nil()
+	}
+
+	query.Sort(list, fields)
+	return encodeDocs(paginate(list, cmd))
+}
+
+func findDocs(entry *Entry, filter *query.Filter) ([]map[string]interface{}, bool, error) {
+	if entry == nil {
+		return nil, false, nil
+	}
+
+	docs, err := getDocs(entry)
+	if err != nil {
+		return nil, true, err
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, doc := range docs {
+		if filter.Match(doc) {
+			list = append(list, doc)
+		}
+	}
+	return list, true, nil
+}
+
+func (c *Client) ADD(cmd *command.Command) Reply {
+	key := cmd.GetStr("key")
+	value := cmd.GetMap("value")
+
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
+
+	entry, isExist := lock.entry, lock.entry != nil
+	if isExist && !entry.IsCollection() {
+		return wrongType(entry)
+	}
+
+	doc, err := storage.NewDocument(value)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+
+	if !isExist {
+		entry = storage.NewCollection()
+	} else if entry.IndexOf(doc.ID) >= 0 {
+		return Errorf("duplicate _id: %s", doc.ID)
+	}
+
+	if err := lock.append("ADD", doc.Data, nil); err != nil {
+		return Errorf("%v", err)
+	}
+
+	entry.AddDoc(doc)
+	if isExist {
+		lock.changed()
+	} else {
+		lock.set(entry)
+	}
+
+	return lock.commit(Bulk(doc.ID))
+}
+
+func (c *Client) UPDATE(cmd *command.Command) Reply {
 	key := cmd.GetStr("key")
-	filters := cmd.GetStr("filters")
 
-	return fmt.Sprintf("FIND operation in progress for key: %s with filters: %s", key, filters)
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
+
+	update, err := query.CompileUpdate(cmd.GetMap("update"))
+	if err != nil {
+		return Errorf("invalid update: %v", err)
+	}
+
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return updateResult(0, 0)
+	}
+
+	if !entry.IsCollection() {
+		return wrongType(entry)
+	}
+
+	// * 先在副本上完成所有更新，任一失敗則整批不生效
+	matched := 0
+	changed := make(map[int]map[string]interface{})
+	var list []interface{}
+
+	for i, doc := range entry.Docs {
+		if !filter.Match(doc.Data) {
+			continue
+		}
+		matched++
+
+		data, isChanged, err := update.Apply(doc.Data)
+		if err != nil {
+			return Errorf("%v", err)
+		}
+
+		if isChanged {
+			changed[i] = data
+			list = append(list, data)
+		}
+	}
+
+	if len(changed) == 0 {
+		return updateResult(matched, 0)
+	}
+
+	// * AOF 記錄更新後的完整文檔，重播時依 _id 取代
+	if err := lock.append("UPDATE", list, nil); err != nil {
+		return Errorf("%v", err)
+	}
+
+	for i, data := range changed {
+		entry.Docs[i].Data = data
+	}
+	lock.changed()
+
+	return lock.commit(updateResult(matched, len(changed)))
 }
 
-// TODO: 實現 ADD
-func (c *Client) ADD(cmd *command.Command) string {
+func (c *Client) REMOVE(cmd *command.Command) Reply {
 	key := cmd.GetStr("key")
-	value := cmd.GetStr("value")
+	limit := cmd.GetInt("limit")
+
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
+
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return Integer(0)
+	}
+
+	if !entry.IsCollection() {
+		return wrongType(entry)
+	}
+
+	var ids []string
+	for _, doc := range entry.Docs {
+		if (limit == 0 || len(ids) < limit) && filter.Match(doc.Data) {
+			ids = append(ids, doc.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return Integer(0)
+	}
+
+	if err := lock.append("REMOVE", nil, nil, ids...); err != nil {
+		return Errorf("%v", err)
+	}
+
+	entry.RemoveDocs(ids)
+	lock.changed()
+
+	return lock.commit(Integer(int64(len(ids))))
+}
+
+func wrongType(entry *Entry) Reply {
+	return Errorf("WRONGTYPE key holds a value of type %s, not a collection", entry.Type)
+}
+
+func updateResult(matched, modified int) Reply {
+	return Bulk(fmt.Sprintf(`{"matched":%d,"modified":%d}`, matched, modified))
+}
+
+// * 取得 KEY 下的文檔，陣列中非物件的元素會被略過
+func getDocs(entry *Entry) ([]map[string]interface{}, error) {
+	switch entry.Type {
+	case storage.TypeCollection:
+		return entry.LiveList(time.Now().UnixMilli()), nil
+	case storage.TypeArray:
+		var list []interface{}
+		if err := json.Unmarshal([]byte(entry.Text()), &list); err != nil {
+			return nil, fmt.Errorf("failed to decode documents: %v", err)
+		}
+
+		docs := make([]map[string]interface{}, 0, len(list))
+		for _, e := range list {
+			if doc, ok := e.(map[string]interface{}); ok {
+				docs = append(docs, doc)
+			}
+		}
+		return docs, nil
+	case storage.TypeObject:
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(entry.Text()), &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode document: %v", err)
+		}
+		return []map[string]interface{}{doc}, nil
+	default:
+		return nil, fmt.Errorf("value of type %s does not hold documents", entry.Type)
+	}
+}
+
+func paginate(list []map[string]interface{}, cmd *command.Command) []map[string]interface{} {
+	if _, hasPage := cmd.GetArg("page"); !hasPage {
+		return list
+	}
+
+	size := defaultPageSize
+	if _, hasOffset := cmd.GetArg("offset"); hasOffset {
+		size = cmd.GetInt("offset")
+	}
+
+	// * 先以頁數判斷是否超出範圍，避免 page * size 溢位
+	page := cmd.GetInt("page")
+	if size <= 0 || page < 0 || page >= (len(list)+size-1)/size {
+		return []map[string]interface{}{}
+	}
+
+	start := page * size
+	end := min(len(list)-start, size) + start
+	return list[start:end]
+}
+
+func encodeDocs(list []map[string]interface{}) Reply {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return Errorf("failed to encode result: %v", err)
+	}
+	return Bulk(string(data))
+}
+
+// * 取得 KEY 的字串值，COLLECTION 以 JSON 陣列輸出
+func entryValue(entry *Entry) Reply {
+	if !entry.IsCollection() {
+		return Bulk(entry.Text())
+	}
 
-	return fmt.Sprintf("ADD operation in progress for key: %s, value: %s", key, value)
+	return encodeDocs(entry.LiveList(time.Now().UnixMilli()))
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"go-jsondb/internal/command"
+)
+
+func TestPaginateHugePage(t *testing.T) {
+	server := openServer(t, testConfig(t))
+	defer server.Close()
+
+	client := server.NewClient()
+	for _, input := range []string{`ADD c {"_id":"d1","n":1}`, `ADD c {"_id":"d2","n":2}`, `ADD c {"_id":"d3","n":3}`} {
+		if reply := exec(t, client, input); reply.IsError() {
+			t.Fatalf("%s: %s", input, reply.Str)
+		}
+	}
+
+	tests := []struct {
+		input string
+		page  int
+		want  string
+	}{
+		{input: `FIND c {} 0 2`, page: 1, want: `[{"_id":"d3","n":3}]`},
+		{input: `FIND c {} 0 2`, page: 2, want: `[]`},
+		{input: `FIND c {} 0 10`, page: math.MaxInt, want: `[]`},
+		{input: `FIND c {} 0 3`, page: math.MaxInt / 3, want: `[]`},
+		{input: `SORT c {} {"n":-1} 0 2`, page: 1, want: `[{"_id":"d1","n":1}]`},
+		{input: `SORT c {} {"n":-1} 0 10`, page: math.MaxInt, want: `[]`},
+		{input: `SORT c {} {"n":-1} 0 3`, page: math.MaxInt / 3, want: `[]`},
+	}
+
+	for _, tt := range tests {
+		cmd, err := command.NewParser().Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse %q: %v", tt.input, err)
+		}
+
+		// * 繞過解析的上限，確認分頁本身不會溢位
+		cmd.SetArg("page", tt.page)
+		if got := client.Exec(cmd).Text(); got != tt.want {
+			t.Errorf("%s page %d = %q, want %q", tt.input, tt.page, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{
+		`FIND c {} 9223372036854775807 10`,
+		`FIND c {} 0 9223372036854775807`,
+		`SORT c {} {"n":1} 9223372036854775807 10`,
+		`SORT c {} {"n":1} 2147483648`,
+	} {
+		if _, err := command.NewParser().Parse(input); err == nil {
+			t.Errorf("%s: expected invalid page error", input)
+		}
+	}
+}
+
+// * 任一文檔更新失敗時整批不生效，也不寫入 AOF
+func TestUpdateAllOrNothing(t *testing.T) {
+	config := testConfig(t)
+	server := openServer(t, config)
+
+	client := server.NewClient()
+	for _, input := range []string{`ADD c {"_id":"d1","n":1}`, `ADD c {"_id":"d2","n":"x"}`, `ADD c {"_id":"d3","n":3}`} {
+		if reply := exec(t, client, input); reply.IsError() {
+			t.Fatalf("%s: %s", input, reply.Str)
+		}
+	}
+
+	want := `[{"_id":"d1","n":1},{"_id":"d2","n":"x"},{"_id":"d3","n":3}]`
+	reply := exec(t, client, `UPDATE c {} {"$inc":{"n":1}}`)
+	if !reply.IsError() || !strings.Contains(reply.Str, "non-numeric field n") {
+		t.Fatalf("UPDATE = %q, want non-numeric error", reply.Text())
+	}
+	if got := exec(t, client, `FIND c {} 0 10`).Text(); got != want {
+		t.Fatalf("after failed UPDATE = %s, want %s", got, want)
+	}
+
+	if reply := exec(t, client, `UPDATE c {"n":{"$gte":1}} {"$inc":{"n":1}}`); reply.IsError() {
+		t.Fatalf("UPDATE: %s", reply.Str)
+	}
+	want = `[{"_id":"d1","n":2},{"_id":"d2","n":"x"},{"_id":"d3","n":4}]`
+	if got := exec(t, client, `FIND c {} 0 10`).Text(); got != want {
+		t.Fatalf("after UPDATE = %s, want %s", got, want)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	server = openServer(t, config)
+	defer server.Close()
+
+	if got := exec(t, server.NewClient(), `FIND c {} 0 10`).Text(); got != want {
+		t.Fatalf("after restart = %s, want %s", got, want)
+	}
+}
@@ -1,178 +1,238 @@
 package server
 
 import (
-	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"go-jsondb/internal/command"
 	"go-jsondb/internal/storage"
-	"go-jsondb/internal/util"
 )
 
-func (c *Client) GET(cmd *command.Command) string {
-	key := cmd.GetStr("key")
-
-	// * 可能會需要刪除過期資料
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+func (c *Client) GET(cmd *command.Command) Reply {
+	lock := c.server.rlockKey(c.db, cmd.GetStr("key"))
+	defer lock.release()
 
-	data := c.server.db[c.db]
-	if e, isExist := data[key]; isExist {
-		if e.ExpireAt != nil && time.Now().Unix() >= *e.ExpireAt {
-			c.server.delFromMem(c.db, key)
-			return "(nil)"
-		}
-		return e.Value
+	if lock.entry != nil {
+		return entryValue(lock.entry)
 	}
-	return "(nil)"
+	return Nil()
 }
 
-func (c *Client) SET(cmd *command.Command) string {
+// * NX/XX 條件不成立時不寫入，指定 GET 時回傳原本的值
+func (c *Client) SET(cmd *command.Command) Reply {
 	key := cmd.GetStr("key")
 	value := cmd.GetStr("value")
-
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
-	valueType := util.GetType(value)
+	_, isGet := cmd.GetArg("get")
+	_, keepTTL := cmd.GetArg("keepttl")
 
 	entry := &storage.Entry{
 		Value: value,
-		Type:  valueType,
+		Type:  storage.ValueType(value),
 	}
 
-	if ttl, hasTTL := cmd.GetArg("ttl"); hasTTL {
-		if ttlValue, ok := ttl.(uint64); ok {
-			expireTime := time.Now().Unix() + int64(ttlValue)
-			entry.ExpireAt = &expireTime
-		}
+	if expireAt, hasExpire := cmd.ExpireAt(time.Now().UnixMilli()); hasExpire {
+		entry.ExpireAt = &expireAt
 	}
 
-	c.server.db[c.db][key] = entry
-
-	if err := c.server.checkDB(c.db); err != nil {
-		return fmt.Sprintf("Error creating writer: %v", err)
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
 	}
+	defer lock.release()
 
-	writer := c.server.writer[c.db]
-
-	var sec *uint64
-	if entry.ExpireAt != nil {
-		remain := *entry.ExpireAt - time.Now().Unix()
-		if remain > 0 {
-			ttl := uint64(remain)
-			sec = &ttl
+	// * 與 Redis 相同，GET 無法回傳 COLLECTION 的舊值，不寫入
+	old := lock.entry
+	previous := Nil()
+	if isGet && old != nil {
+		if old.IsCollection() {
+			return errWrongKind()
 		}
+		previous = entryValue(old)
 	}
 
-	if err := writer.WriteWithTTL("SET", key, value, sec); err != nil {
-		return fmt.Sprintf("Error writing to AOF: %v", err)
+	condition := cmd.GetStr("condition")
+	if (condition == "NX" && old != nil) || (condition == "XX" && old == nil) {
+		return previous
 	}
 
-	cache := storage.Cache{
-		Key:       key,
-		Value:     value,
-		Type:      valueType,
-		CreatedAt: time.Now().Unix(),
-		UpdatedAt: time.Now().Unix(),
-		ExpireAt:  entry.ExpireAt,
+	if keepTTL && old != nil && old.ExpireAt != nil {
+		expireAt := *old.ExpireAt
+		entry.ExpireAt = &expireAt
 	}
 
-	if err := writer.Save(key, cache); err != nil {
-		return fmt.Sprintf("Error writing to file: %v", err)
+	if err := lock.append("SET", value, entry.ExpireAt); err != nil {
+		return Errorf("%v", err)
 	}
 
-	if _, hasTTL := cmd.GetArg("ttl"); hasTTL {
-		return "OK (TTL support in progress)"
+	lock.set(entry)
+	if isGet {
+		return lock.commit(previous)
 	}
-	return "OK"
+	return lock.commit(Status("OK"))
 }
 
-func (c *Client) DEL(cmd *command.Command) string {
-	list := cmd.GetStrAry("keys")
+// * 逐一鎖定 KEY 刪除，每個 KEY 寫入磁碟後才處理下一個
+func (c *Client) DEL(cmd *command.Command) Reply {
+	deleted := 0
 
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+	for _, key := range cmd.GetStrAry("keys") {
+		lock, err := c.server.lockKey(c.db, key)
+		if err != nil {
+			return Errorf("%v", err)
+		}
 
-	data := c.server.db[c.db]
+		if lock.entry != nil {
+			if err := lock.append("DEL", nil, nil); err != nil {
+				lock.release()
+				return Errorf("%v", err)
+			}
+			lock.remove()
+			deleted++
+		}
 
-	if err := c.server.checkDB(c.db); err != nil {
-		return fmt.Sprintf("Error creating writer: %v", err)
+		err = lock.persist()
+		lock.release()
+		if err != nil {
+			return Errorf("%v", err)
+		}
 	}
 
-	writer := c.server.writer[c.db]
-	deleted := 0
+	return Integer(int64(deleted))
+}
 
-	for _, e := range list {
-		if _, isExist := data[e]; isExist {
-			delete(data, e)
-			deleted++
+func (c *Client) EXISTS(cmd *command.Command) Reply {
+	lock := c.server.rlockKey(c.db, cmd.GetStr("key"))
+	defer lock.release()
 
-			if err := writer.Write("DEL", e, nil); err != nil {
-				return fmt.Sprintf("Error writing to AOF: %v", err)
-			}
+	if lock.entry != nil {
+		return Integer(1)
+	}
+	return Integer(0)
+}
+
+// * 逐一分片以讀鎖走訪，已過期的 KEY 略過，留給背景清理刪除
+func (c *Client) KEYS(cmd *command.Command) Reply {
+	pattern := cmd.GetStr("pattern")
+	list := make([]string, 0)
 
-			if err := writer.Delete(e); err != nil {
-				fmt.Printf("Warning: failed to delete file for key %s: %v\n", e, err)
+	d, isExist := c.server.getDB(c.db)
+	if !isExist {
+		return StrArray(list)
+	}
+
+	d.mu.RLock()
+	now := time.Now().UnixMilli()
+	for i := range d.shards {
+		sh := &d.shards[i]
+		sh.mu.RLock()
+		for key, entry := range sh.data {
+			if !entry.IsExpired(now) && matchPattern(key, pattern) && (c.user == nil || c.user.allowKey(key)) {
+				list = append(list, key)
 			}
 		}
+		sh.mu.RUnlock()
 	}
+	d.mu.RUnlock()
 
-	return fmt.Sprintf("(integer) %d", deleted)
+	sort.Strings(list)
+	return StrArray(list)
 }
 
-func (c *Client) EXISTS(cmd *command.Command) string {
-	var result = c.GET(cmd)
+// * INCR、DECR、INCRBY、DECRBY 共用，KEY 不存在時從 0 開始，只接受 int 類型
+// * 只在此解析數字，SET 保存的文字不會被改寫
+func (c *Client) INCR(cmd *command.Command) Reply {
+	lock, err := c.server.lockKey(c.db, cmd.GetStr("key"))
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
 
-	if result == "(nil)" {
-		return "(integer) 0"
+	text, valueType := "0", storage.TypeInt
+	if lock.entry != nil {
+		if lock.entry.IsCollection() {
+			return errWrongKind()
+		}
+		text, valueType = lock.entry.Text(), lock.entry.Type
 	}
-	return "(integer) 1"
-}
 
-func (c *Client) KEYS(cmd *command.Command) string {
-	pattern := cmd.GetStr("pattern")
+	result, err := storage.IncrBy(text, valueType, cmd.GetInt64("increment"))
+	if err != nil {
+		return Errorf("%v", err)
+	}
 
-	// * 可能會需要刪除過期資料
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+	if err := lock.setValue(strconv.FormatInt(result, 10)); err != nil {
+		return Errorf("%v", err)
+	}
+	return lock.commit(Integer(result))
+}
 
-	data := c.server.db[c.db]
-	var list []string
-	var delList []string
+// * 接受 int 與 float 類型，結果以最短的十進位文字保存
+func (c *Client) INCRBYFLOAT(cmd *command.Command) Reply {
+	lock, err := c.server.lockKey(c.db, cmd.GetStr("key"))
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
 
-	for key, entry := range data {
-		if entry.ExpireAt != nil && time.Now().Unix() >= *entry.ExpireAt {
-			delList = append(delList, key)
-		} else if c.matchPattern(key, pattern) {
-			list = append(list, key)
+	text, valueType := "0", storage.TypeFloat
+	if lock.entry != nil {
+		if lock.entry.IsCollection() {
+			return errWrongKind()
 		}
+		text, valueType = lock.entry.Text(), lock.entry.Type
 	}
 
-	for _, key := range delList {
-		c.server.delFromMem(c.db, key)
+	result, err := storage.IncrByFloat(text, valueType, cmd.GetFloat64("increment"))
+	if err != nil {
+		return Errorf("%v", err)
 	}
 
-	if len(list) == 0 {
-		return "(empty)"
+	formatted := storage.FormatFloat(result)
+	if err := lock.setValue(formatted); err != nil {
+		return Errorf("%v", err)
 	}
+	return lock.commit(Bulk(formatted))
+}
 
-	return fmt.Sprintf("%v", list)
+func errWrongKind() Reply {
+	return Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 }
 
-func (c *Client) TYPE(cmd *command.Command) string {
-	key := cmd.GetStr("key")
+// * 以游標分批走訪，每次只鎖定游標所在的分片，回傳 [下一個游標, KEY 列表]
+func (c *Client) SCAN(cmd *command.Command) Reply {
+	cursor, err := parseScanCursor(cmd.GetStr("cursor"))
+	if err != nil {
+		return Errorf("%v", err)
+	}
 
-	// * 可能會需要刪除過期資料
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+	pattern := cmd.GetStr("pattern")
+	keyType := cmd.GetStr("type")
+	list := make([]string, 0)
 
-	data := c.server.db[c.db]
-	if e, isExist := data[key]; isExist {
-		if e.ExpireAt != nil && time.Now().Unix() >= *e.ExpireAt {
-			c.server.delFromMem(c.db, key)
-			return "none"
-		}
-		return e.Type
+	d, isExist := c.server.getDB(c.db)
+	if !isExist {
+		return Array(Bulk("0"), StrArray(list))
+	}
+
+	now := time.Now().UnixMilli()
+	next, list := d.scan(cursor, cmd.GetInt("count"), func(key string, entry *Entry) bool {
+		return !entry.IsExpired(now) &&
+			(keyType == "" || strings.EqualFold(entry.Type, keyType)) &&
+			matchPattern(key, pattern) &&
+			(c.user == nil || c.user.allowKey(key))
+	})
+
+	return Array(Bulk(strconv.Itoa(next)), StrArray(list))
+}
+
+func (c *Client) TYPE(cmd *command.Command) Reply {
+	lock := c.server.rlockKey(c.db, cmd.GetStr("key"))
+	defer lock.release()
+
+	if lock.entry != nil {
+		return Status(lock.entry.Type)
 	}
-	return "none"
+	return Status("none")
 }
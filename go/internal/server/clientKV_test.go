@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+// * SET ... GET 無法回傳 COLLECTION 的舊值，回傳 WRONGTYPE 且不覆寫
+func TestSETGetOnCollection(t *testing.T) {
+	server := openServer(t, testConfig(t))
+	defer server.Close()
+
+	client := server.NewClient()
+	tests := []replayQuery{
+		{`SET a 1 GET`, `(nil)`},
+		{`SET a 2 GET`, `1`},
+		{`GET a`, `2`},
+		{`ADD c {"_id":"d1"}`, `d1`},
+		{`SET c x GET`, `Error: WRONGTYPE Operation against a key holding the wrong kind of value`},
+		{`SET c x NX GET`, `Error: WRONGTYPE Operation against a key holding the wrong kind of value`},
+		{`SET c x XX GET`, `Error: WRONGTYPE Operation against a key holding the wrong kind of value`},
+		{`INCR c`, `Error: WRONGTYPE Operation against a key holding the wrong kind of value`},
+		{`FIND c {}`, `[{"_id":"d1"}]`},
+		{`SET c x`, `OK`},
+		{`GET c`, `x`},
+	}
+
+	for _, tt := range tests {
+		if got := exec(t, client, tt.cmd).Text(); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
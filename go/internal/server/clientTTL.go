@@ -1,163 +1,286 @@
 package server
 
 import (
-	"fmt"
+	"encoding/json"
 	"time"
 
 	"go-jsondb/internal/command"
+	"go-jsondb/internal/query"
 	"go-jsondb/internal/storage"
 )
 
-// TODO: 實現 TTL with filters
-func (c *Client) TTL(cmd *command.Command) string {
+// * TTL 回傳剩餘秒數 (四捨五入)，PTTL 回傳剩餘毫秒，EXPIRETIME 回傳到期的 Unix 秒數
+func (c *Client) TTL(cmd *command.Command) Reply {
+	if _, hasFilters := cmd.GetArg("filters"); hasFilters {
+		return c.docTTL(cmd)
+	}
+
 	key := cmd.GetStr("key")
 
-	// * 可能會需要刪除過期資料
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+	lock := c.server.rlockKey(c.db, key)
+	defer lock.release()
 
-	data := c.server.db[c.db]
-	if entry, isExist := data[key]; isExist {
-		if entry.ExpireAt == nil {
-			return "(integer) -1"
-		}
+	entry := lock.entry
+	if entry == nil {
+		return Integer(-2)
+	}
 
-		now := time.Now().Unix()
-		if now >= *entry.ExpireAt {
-			c.server.delFromMem(c.db, key)
-			return "(integer) -2"
-		}
+	if entry.ExpireAt == nil {
+		return Integer(-1)
+	}
 
-		remaining := *entry.ExpireAt - now
-		return fmt.Sprintf("(integer) %d", remaining)
+	return Integer(ttlValue(cmd.Type, *entry.ExpireAt, time.Now().UnixMilli()))
+}
+
+func ttlValue(cmdType command.CommandType, expireAt, now int64) int64 {
+	if cmdType == command.EXPIRETIME {
+		return expireAt / 1000
 	}
 
-	return "(integer) -2"
+	remaining := max(expireAt-now, 0)
+	if cmdType == command.PTTL {
+		return remaining
+	}
+	return (remaining + 500) / 1000
 }
 
-// TODO: 實現 EXPIRE with filters
-func (c *Client) EXPIRE(cmd *command.Command) string {
+// * EXPIRE、PEXPIRE、EXPIREAT、PEXPIREAT 共用，到期時間已過時直接刪除 KEY
+func (c *Client) EXPIRE(cmd *command.Command) Reply {
+	if _, hasFilters := cmd.GetArg("filters"); hasFilters {
+		return c.docExpire(cmd)
+	}
+
 	key := cmd.GetStr("key")
-	ttl := cmd.GetUint64("ttl")
 
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return Integer(0)
+	}
+
+	now := time.Now().UnixMilli()
+	expire, _ := cmd.ExpireAt(now)
 
-	data := c.server.db[c.db]
-	entry, isExist := data[key]
-	if !isExist {
-		return "(integer) 0"
+	if expire <= now {
+		if err := lock.append("DEL", nil, nil); err != nil {
+			return Errorf("%v", err)
+		}
+
+		lock.remove()
+		c.server.expiry.keys.Add(1)
+		return lock.commit(Integer(1))
 	}
 
-	if entry.ExpireAt != nil && time.Now().Unix() >= *entry.ExpireAt {
-		c.server.delFromMem(c.db, key)
-		return "(integer) 0"
+	if err := lock.append("EXPIREAT", nil, &expire); err != nil {
+		return Errorf("%v", err)
 	}
 
-	expire := time.Now().Unix() + int64(ttl)
 	entry.ExpireAt = &expire
+	lock.changed()
+
+	return lock.commit(Integer(1))
+}
+
+func (c *Client) PERSIST(cmd *command.Command) Reply {
+	if _, hasFilters := cmd.GetArg("filters"); hasFilters {
+		return c.docPersist(cmd)
+	}
+
+	key := cmd.GetStr("key")
 
-	if err := c.server.checkDB(c.db); err != nil {
-		return fmt.Sprintf("Error creating writer: %v", err)
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
+	}
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return Integer(0)
 	}
 
-	writer := c.server.writer[c.db]
+	if entry.ExpireAt == nil {
+		return Integer(0)
+	}
 
-	if err := writer.Write("SET", key, map[string]interface{}{
-		"seconds":   ttl,
-		"expire_at": expire,
-	}); err != nil {
-		return fmt.Sprintf("Error writing to AOF: %v", err)
+	if err := lock.append("PERSIST", nil, nil); err != nil {
+		return Errorf("%v", err)
 	}
 
-	reader := c.server.reader[c.db]
-	existingCache, err := reader.Read(key)
+	entry.ExpireAt = nil
+	lock.changed()
+
+	return lock.commit(Integer(1))
+}
+
+// * 回傳符合條件文檔的剩餘時間或到期時間，單位同 TTL，-1 代表未設定過期
+func (c *Client) docTTL(cmd *command.Command) Reply {
+	key := cmd.GetStr("key")
+
+	filter, err := query.Compile(cmd.GetMap("filters"))
 	if err != nil {
-		existingCache = &storage.Cache{
-			Key:       key,
-			Value:     entry.Value,
-			Type:      entry.Type,
-			CreatedAt: time.Now().Unix(),
-			UpdatedAt: time.Now().Unix(),
-			ExpireAt:  entry.ExpireAt,
-		}
+		return Errorf("invalid filters: %v", err)
+	}
+
+	lock := c.server.rlockKey(c.db, key)
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return Integer(-2)
 	}
 
-	cache := storage.Cache{
-		Key:       key,
-		Value:     entry.Value,
-		Type:      entry.Type,
-		CreatedAt: existingCache.CreatedAt,
-		UpdatedAt: time.Now().Unix(),
-		ExpireAt:  entry.ExpireAt,
+	if !entry.IsCollection() {
+		return wrongType(entry)
 	}
 
-	if err := writer.Save(key, cache); err != nil {
-		return fmt.Sprintf("Error updating file: %v", err)
+	field := "ttl"
+	if cmd.Type == command.EXPIRETIME {
+		field = "expire_time"
 	}
 
-	return "(integer) 1"
+	now := time.Now().UnixMilli()
+	list := make([]map[string]interface{}, 0)
+
+	for _, doc := range entry.Docs {
+		if doc.IsExpired(now) || !filter.Match(doc.Data) {
+			continue
+		}
+
+		ttl := int64(-1)
+		if doc.ExpireAt != nil {
+			ttl = ttlValue(cmd.Type, *doc.ExpireAt, now)
+		}
+
+		list = append(list, map[string]interface{}{
+			"_id": doc.ID,
+			field: ttl,
+		})
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return Errorf("failed to encode result: %v", err)
+	}
+	return Bulk(string(data))
 }
 
-// TODO: 實現 PERSIST with filters
-func (c *Client) PERSIST(cmd *command.Command) string {
+// * 到期時間已過時直接移除符合條件的文檔
+func (c *Client) docExpire(cmd *command.Command) Reply {
 	key := cmd.GetStr("key")
 
-	c.server.mu.Lock()
-	defer c.server.mu.Unlock()
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
 
-	data := c.server.db[c.db]
-	entry, isExist := data[key]
-	if !isExist {
-		return "(integer) 0"
+	lock, err := c.server.lockKey(c.db, key)
+	if err != nil {
+		return Errorf("%v", err)
 	}
+	defer lock.release()
 
-	if entry.ExpireAt != nil && time.Now().Unix() >= *entry.ExpireAt {
-		c.server.delFromMem(c.db, key)
-		return "(integer) 0"
+	entry := lock.entry
+	if entry == nil {
+		return Integer(0)
 	}
 
-	if entry.ExpireAt == nil {
-		return "(integer) 0"
+	if !entry.IsCollection() {
+		return wrongType(entry)
 	}
 
-	entry.ExpireAt = nil
+	// * 過濾時直接保留符合條件的文檔，更新時不需再依 _id 搜尋
+	var ids []string
+	var docs []*storage.Document
+	for _, doc := range entry.Docs {
+		if filter.Match(doc.Data) {
+			ids = append(ids, doc.ID)
+			docs = append(docs, doc)
+		}
+	}
 
-	if err := c.server.checkDB(c.db); err != nil {
-		return fmt.Sprintf("Error creating writer: %v", err)
+	if len(ids) == 0 {
+		return Integer(0)
 	}
 
-	writer := c.server.writer[c.db]
+	now := time.Now().UnixMilli()
+	expire, _ := cmd.ExpireAt(now)
+
+	if expire <= now {
+		if err := lock.append("REMOVE", nil, nil, ids...); err != nil {
+			return Errorf("%v", err)
+		}
+
+		entry.RemoveDocs(ids)
+		lock.changed()
+		c.server.expiry.docs.Add(int64(len(ids)))
+		return lock.commit(Integer(int64(len(ids))))
+	}
 
-	if err := writer.Write("SET", key, entry.Value); err != nil {
-		return fmt.Sprintf("Error writing to AOF: %v", err)
+	if err := lock.append("EXPIREAT", nil, &expire, ids...); err != nil {
+		return Errorf("%v", err)
 	}
 
-	reader := c.server.reader[c.db]
-	existingCache, err := reader.Read(key)
+	for _, doc := range docs {
+		expireAt := expire
+		doc.ExpireAt = &expireAt
+	}
+
+	lock.changed()
+
+	return lock.commit(Integer(int64(len(ids))))
+}
+
+func (c *Client) docPersist(cmd *command.Command) Reply {
+	key := cmd.GetStr("key")
+
+	filter, err := query.Compile(cmd.GetMap("filters"))
+	if err != nil {
+		return Errorf("invalid filters: %v", err)
+	}
+
+	lock, err := c.server.lockKey(c.db, key)
 	if err != nil {
-		existingCache = &storage.Cache{
-			Key:       key,
-			Value:     entry.Value,
-			Type:      entry.Type,
-			CreatedAt: time.Now().Unix(),
-			UpdatedAt: time.Now().Unix(),
-			ExpireAt:  nil,
+		return Errorf("%v", err)
+	}
+	defer lock.release()
+
+	entry := lock.entry
+	if entry == nil {
+		return Integer(0)
+	}
+
+	if !entry.IsCollection() {
+		return wrongType(entry)
+	}
+
+	var ids []string
+	var docs []*storage.Document
+	for _, doc := range entry.Docs {
+		if doc.ExpireAt != nil && filter.Match(doc.Data) {
+			ids = append(ids, doc.ID)
+			docs = append(docs, doc)
 		}
 	}
 
-	cache := storage.Cache{
-		Key:       key,
-		Value:     entry.Value,
-		Type:      entry.Type,
-		CreatedAt: existingCache.CreatedAt,
-		UpdatedAt: time.Now().Unix(),
-		ExpireAt:  nil,
+	if len(ids) == 0 {
+		return Integer(0)
+	}
+
+	if err := lock.append("PERSIST", nil, nil, ids...); err != nil {
+		return Errorf("%v", err)
 	}
 
-	if err := writer.Save(key, cache); err != nil {
-		return fmt.Sprintf("Error updating file: %v", err)
+	for _, doc := range docs {
+		doc.ExpireAt = nil
 	}
 
-	return "(integer) 1"
+	lock.changed()
+
+	return lock.commit(Integer(int64(len(ids))))
 }
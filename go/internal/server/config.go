@@ -0,0 +1,361 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-jsondb/internal/command"
+	"go-jsondb/internal/storage"
+	"go-jsondb/internal/util"
+)
+
+// * 設定項目: 名稱同時用於命令列參數、CONFIG 指令與環境變數 (JSONDB_ 前綴、大寫底線)
+// * runtime 為 false 的項目只在啟動時生效，CONFIG SET 會拒絕
+type setting struct {
+	name    string
+	runtime bool
+	get     func(o *storage.Option) string
+	set     func(o *storage.Option, value string) error
+}
+
+var settings = []setting{
+	{"bind", false, func(o *storage.Option) string { return o.Bind }, func(o *storage.Option, v string) error {
+		o.Bind = v
+		return nil
+	}},
+	{"port", false, func(o *storage.Option) string { return strconv.Itoa(o.Port) }, func(o *storage.Option, v string) error {
+		return setInt(&o.Port, v, 0, 65535)
+	}},
+	{"tls-port", false, func(o *storage.Option) string { return strconv.Itoa(o.TLSPort) }, func(o *storage.Option, v string) error {
+		return setInt(&o.TLSPort, v, 0, 65535)
+	}},
+	{"tls-cert-file", false, func(o *storage.Option) string { return o.TLSCertFile }, func(o *storage.Option, v string) error {
+		o.TLSCertFile = v
+		return nil
+	}},
+	{"tls-key-file", false, func(o *storage.Option) string { return o.TLSKeyFile }, func(o *storage.Option, v string) error {
+		o.TLSKeyFile = v
+		return nil
+	}},
+	{"tls-ca-cert-file", false, func(o *storage.Option) string { return o.TLSCACertFile }, func(o *storage.Option, v string) error {
+		o.TLSCACertFile = v
+		return nil
+	}},
+	{"tls-auth-clients", false, func(o *storage.Option) string { return o.TLSAuthClients }, func(o *storage.Option, v string) error {
+		return setEnum(&o.TLSAuthClients, v, tlsAuthNo, tlsAuthOptional, tlsAuthYes)
+	}},
+	{"protocol", false, func(o *storage.Option) string { return o.Protocol }, func(o *storage.Option, v string) error {
+		return setEnum(&o.Protocol, v, "auto", "resp", "text")
+	}},
+	{"dir", false, func(o *storage.Option) string { return o.DBPath }, func(o *storage.Option, v string) error {
+		if v == "" {
+			return fmt.Errorf("dir must not be empty")
+		}
+		o.DBPath = v
+		return nil
+	}},
+	{"databases", false, func(o *storage.Option) string { return strconv.Itoa(o.Databases) }, func(o *storage.Option, v string) error {
+		return setInt(&o.Databases, v, 1, 1<<20)
+	}},
+	{"users-file", false, func(o *storage.Option) string { return o.UsersFile }, func(o *storage.Option, v string) error {
+		o.UsersFile = v
+		return nil
+	}},
+	{"aof-repair", false, func(o *storage.Option) string { return boolSetting(o.AOFRepair) }, func(o *storage.Option, v string) error {
+		return setBool(&o.AOFRepair, v)
+	}},
+	{"warm", false, func(o *storage.Option) string { return boolSetting(o.WarmOnStart) }, func(o *storage.Option, v string) error {
+		return setBool(&o.WarmOnStart, v)
+	}},
+	{"appendfsync", true, func(o *storage.Option) string { return o.AppendFsync }, func(o *storage.Option, v string) error {
+		return setEnum(&o.AppendFsync, v, storage.FsyncAlways, storage.FsyncEverySec, storage.FsyncNo)
+	}},
+	{"auto-aof-rewrite-percentage", true, func(o *storage.Option) string { return strconv.Itoa(o.AutoRewritePercentage) }, func(o *storage.Option, v string) error {
+		return setInt(&o.AutoRewritePercentage, v, 0, 1<<30)
+	}},
+	{"auto-aof-rewrite-min-size", true, func(o *storage.Option) string { return strconv.FormatInt(o.AutoRewriteMinSize, 10) }, func(o *storage.Option, v string) error {
+		return setBytes(&o.AutoRewriteMinSize, v)
+	}},
+	{"maxmemory", true, func(o *storage.Option) string { return strconv.FormatInt(o.MaxMemory, 10) }, func(o *storage.Option, v string) error {
+		return setBytes(&o.MaxMemory, v)
+	}},
+	{"maxmemory-policy", true, func(o *storage.Option) string { return o.MaxMemoryPolicy }, func(o *storage.Option, v string) error {
+		return setEnum(&o.MaxMemoryPolicy, v, storage.PolicyNoEviction, storage.PolicyAllKeysLRU,
+			storage.PolicyAllKeysLFU, storage.PolicyVolatileLRU, storage.PolicyVolatileTTL)
+	}},
+	{"maxmemory-samples", true, func(o *storage.Option) string { return strconv.Itoa(o.MaxMemorySamples) }, func(o *storage.Option, v string) error {
+		return setInt(&o.MaxMemorySamples, v, 1, 1<<16)
+	}},
+	{"expire-interval", true, func(o *storage.Option) string { return strconv.Itoa(o.ExpireInterval) }, func(o *storage.Option, v string) error {
+		return setInt(&o.ExpireInterval, v, 1, 86400)
+	}},
+	{"hz", true, func(o *storage.Option) string { return strconv.Itoa(o.Hz) }, func(o *storage.Option, v string) error {
+		return setInt(&o.Hz, v, 1, 500)
+	}},
+	{"log-level", true, func(o *storage.Option) string { return o.LogLevel }, func(o *storage.Option, v string) error {
+		if _, err := util.ParseLogLevel(v); err != nil {
+			return err
+		}
+		o.LogLevel = strings.ToLower(v)
+		return nil
+	}},
+	{"shutdown-timeout", true, func(o *storage.Option) string { return strconv.Itoa(o.ShutdownTimeout) }, func(o *storage.Option, v string) error {
+		return setInt(&o.ShutdownTimeout, v, 0, 3600)
+	}},
+	{"warm-keys", true, func(o *storage.Option) string { return strconv.Itoa(o.WarmKeys) }, func(o *storage.Option, v string) error {
+		return setInt(&o.WarmKeys, v, 0, 1<<30)
+	}},
+	{"warm-bytes", true, func(o *storage.Option) string { return strconv.FormatInt(o.WarmBytes, 10) }, func(o *storage.Option, v string) error {
+		return setBytes(&o.WarmBytes, v)
+	}},
+}
+
+func findSetting(name string) (*setting, bool) {
+	name = strings.ToLower(name)
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i], true
+		}
+	}
+	return nil, false
+}
+
+func setInt(target *int, value string, min, max int) error {
+	num, err := strconv.Atoi(value)
+	if err != nil || num < min || num > max {
+		return fmt.Errorf("value must be an integer between %d and %d", min, max)
+	}
+	*target = num
+	return nil
+}
+
+func setBytes(target *int64, value string) error {
+	size, err := util.ParseBytes(value)
+	if err != nil {
+		return err
+	}
+	*target = size
+	return nil
+}
+
+func setBool(target *bool, value string) error {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		*target = true
+	case "no", "false", "0":
+		*target = false
+	default:
+		return fmt.Errorf("value must be yes or no")
+	}
+	return nil
+}
+
+func setEnum(target *string, value string, list ...string) error {
+	value = strings.ToLower(value)
+	for _, e := range list {
+		if e == value {
+			*target = value
+			return nil
+		}
+	}
+	return fmt.Errorf("value must be one of %s", strings.Join(list, ", "))
+}
+
+func boolSetting(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// * 套用單一設定，供命令列參數與環境變數使用
+func ApplySetting(config *storage.Config, name, value string) error {
+	setting, isExist := findSetting(name)
+	if !isExist {
+		return fmt.Errorf("unknown setting: %s", name)
+	}
+
+	if err := setting.set(&config.Option, value); err != nil {
+		return fmt.Errorf("invalid %s: %v", setting.name, err)
+	}
+	return nil
+}
+
+// * 以各設定的解析規則重新檢查目前的值
+func ValidateConfig(config storage.Config) error {
+	option := config.Option
+	for _, e := range settings {
+		if err := e.set(&option, e.get(&config.Option)); err != nil {
+			return fmt.Errorf("invalid %s: %v", e.name, err)
+		}
+	}
+	return validateListen(option)
+}
+
+// * port 與 tls-port 設為 0 代表不開啟該連接埠，但至少需開啟一個
+func validateListen(option storage.Option) error {
+	if option.Port == 0 && option.TLSPort == 0 {
+		return fmt.Errorf("port and tls-port must not both be 0")
+	}
+
+	if option.TLSPort == 0 {
+		return nil
+	}
+
+	if option.TLSPort == option.Port {
+		return fmt.Errorf("tls-port must differ from port")
+	}
+	if option.TLSCertFile == "" || option.TLSKeyFile == "" {
+		return fmt.Errorf("tls-port requires tls-cert-file and tls-key-file")
+	}
+	if option.TLSAuthClients != tlsAuthNo && option.TLSCACertFile == "" {
+		return fmt.Errorf("tls-auth-clients %s requires tls-ca-cert-file", option.TLSAuthClients)
+	}
+	return nil
+}
+
+func IsSetting(name string) bool {
+	_, isExist := findSetting(name)
+	return isExist
+}
+
+// * 讀取 JSONDB_ 開頭的環境變數，例如 JSONDB_PORT、JSONDB_MAXMEMORY_POLICY
+func ApplyEnv(config *storage.Config) error {
+	for _, e := range settings {
+		env := "JSONDB_" + strings.ToUpper(strings.ReplaceAll(e.name, "-", "_"))
+		if value, isExist := os.LookupEnv(env); isExist {
+			if err := ApplySetting(config, e.name, value); err != nil {
+				return fmt.Errorf("%s: %v", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) option() storage.Option {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+
+	return s.config.Option
+}
+
+func (s *Server) dbConfig(db int) storage.Config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+
+	config := s.config
+	config.DB = db
+	return config
+}
+
+func (s *Server) validDB(db int) error {
+	if databases := s.option().Databases; db < 0 || db >= databases {
+		return fmt.Errorf("DB index is out of range (0-%d)", databases-1)
+	}
+	return nil
+}
+
+// * CONFIG GET <pattern> | CONFIG SET <name> <value> [<name> <value> ...] | CONFIG REWRITE
+func (c *Client) CONFIG(cmd *command.Command) Reply {
+	switch cmd.GetStr("action") {
+	case "GET":
+		return c.server.configGet(cmd.GetStr("pattern"))
+	case "SET":
+		if err := c.server.configSet(cmd.GetStrAry("pairs")); err != nil {
+			return Errorf("%v", err)
+		}
+		return Status("OK")
+	case "REWRITE":
+		if err := c.server.configRewrite(); err != nil {
+			return Errorf("%v", err)
+		}
+		return Status("OK")
+	}
+	return Errorf("unknown CONFIG subcommand")
+}
+
+func (s *Server) configGet(pattern string) Reply {
+	option := s.option()
+
+	var names []string
+	for _, e := range settings {
+		if matchPattern(e.name, strings.ToLower(pattern)) {
+			names = append(names, e.name)
+		}
+	}
+	sort.Strings(names)
+
+	var list []Reply
+	for _, name := range names {
+		setting, _ := findSetting(name)
+		list = append(list, Bulk(name), Bulk(setting.get(&option)))
+	}
+	return Map(list...)
+}
+
+// * 全部驗證通過才套用，避免只改到一半
+func (s *Server) configSet(pairs []string) error {
+	s.cfgMu.Lock()
+	option := s.config.Option
+	for i := 0; i+1 < len(pairs); i += 2 {
+		setting, isExist := findSetting(pairs[i])
+		if !isExist {
+			s.cfgMu.Unlock()
+			return fmt.Errorf("unknown option '%s'", pairs[i])
+		}
+		if !setting.runtime {
+			s.cfgMu.Unlock()
+			return fmt.Errorf("option '%s' can only be set at startup", setting.name)
+		}
+		if err := setting.set(&option, pairs[i+1]); err != nil {
+			s.cfgMu.Unlock()
+			return fmt.Errorf("invalid '%s': %v", setting.name, err)
+		}
+	}
+
+	previous := s.config.Option
+	s.config.Option = option
+	s.cfgMu.Unlock()
+
+	return s.applyRuntime(previous, option)
+}
+
+// * 將需要通知其他元件的設定套用到執行中的服務
+func (s *Server) applyRuntime(previous, option storage.Option) error {
+	if previous.LogLevel != option.LogLevel {
+		level, _ := util.ParseLogLevel(option.LogLevel)
+		slog.SetLogLoggerLevel(level)
+	}
+
+	if previous.AppendFsync != option.AppendFsync {
+		for _, d := range s.databases() {
+			if err := d.writer.SetFsync(option.AppendFsync); err != nil {
+				return fmt.Errorf("failed to apply appendfsync to DB %d: %v", d.id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) configRewrite() error {
+	s.cfgMu.RLock()
+	path := s.config.File
+	option := s.config.Option
+	s.cfgMu.RUnlock()
+
+	if path == "" {
+		return fmt.Errorf("the server is running without a config file")
+	}
+
+	if err := storage.SaveConfigFile(path, option); err != nil {
+		return err
+	}
+
+	slog.Info("Config file rewritten", "path", path)
+	return nil
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go-jsondb/internal/storage"
+)
+
+const (
+	// * 分片數量需為 2 的次方
+	shardCount = 32
+
+	// * 讀回已移出記憶體的 KEY 時，KEY 持續被修改的重試上限
+	maxLoadAttempts = 3
+)
+
+// * expires 與 deadlines 為到期索引，slots 為 SCAN 使用的掃描槽，皆與 data 使用同一把鎖
+type shard struct {
+	mu        sync.RWMutex
+	data      map[string]*Entry
+	expires   expiryHeap
+	deadlines map[string]int64
+	slots     [][]string
+}
+
+// * 單一資料庫，KEY 依雜湊分散到各分片
+// * 一般指令持有 mu 的讀鎖與 KEY 所在分片的鎖；快照與 AOF 重寫複製資料時才持有 mu 的寫鎖
+// * files 依相同分片序列化 JSON 檔案寫入，鎖的順序固定為 mu → files → shard
+type database struct {
+	id     int
+	mu     sync.RWMutex
+	shards [shardCount]shard
+	files  [shardCount]sync.Mutex
+	writer *storage.AOFWriter
+	reader *storage.AOFReader
+
+	// * 進行中的快照與 AOF 重寫尚未讀出的 JSON 檔案
+	pendingMu sync.Mutex
+	pending   []*pendingFiles
+
+	// * 上一輪主動清理中斷的分片位置，只由清理工作使用
+	cursor int
+}
+
+func newDatabase(id int, data map[string]*Entry, writer *storage.AOFWriter, reader *storage.AOFReader) *database {
+	d := &database{
+		id:     id,
+		writer: writer,
+		reader: reader,
+	}
+
+	for i := range d.shards {
+		d.shards[i].data = make(map[string]*Entry)
+		d.shards[i].deadlines = make(map[string]int64)
+		d.shards[i].slots = make([][]string, 1)
+	}
+	for key, entry := range data {
+		sh := d.shard(key)
+		sh.insert(key, entry)
+		sh.index(key, entry)
+	}
+	return d
+}
+
+// * FNV-1a
+func keyHash(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
+func shardIndex(key string) int {
+	return int(keyHash(key) & (shardCount - 1))
+}
+
+func (d *database) shard(key string) *shard {
+	return &d.shards[shardIndex(key)]
+}
+
+func (d *database) fileLock(key string) *sync.Mutex {
+	return &d.files[shardIndex(key)]
+}
+
+// * 走訪所有 KEY，呼叫端需持有 mu 的寫鎖或在尚未對外服務時使用
+func (d *database) each(fn func(key string, entry *Entry)) {
+	for i := range d.shards {
+		for key, entry := range d.shards[i].data {
+			fn(key, entry)
+		}
+	}
+}
+
+func (s *Server) getDB(db int) (*database, bool) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	d, isExist := s.dbs[db]
+	return d, isExist
+}
+
+// * 取得資料庫，尚未載入時從快照與 AOF 恢復
+func (s *Server) openDB(db int) (*database, error) {
+	if d, isExist := s.getDB(db); isExist {
+		return d, nil
+	}
+
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	if d, isExist := s.dbs[db]; isExist {
+		return d, nil
+	}
+
+	dbConfig := s.dbConfig(db)
+
+	stats, err := storage.LoadStats(dbConfig)
+	if err != nil {
+		slog.Warn("Failed to load access stats", "db", db, "error", err)
+	}
+
+	// * 有記憶體上限時，重啟前已移出記憶體的 KEY 維持在磁碟上
+	var cold *storage.Stats
+	if s.option().MaxMemory > 0 {
+		cold = stats
+	}
+
+	reader := storage.NewAOFReader(dbConfig)
+	data, err := reader.Load(cold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data from AOF for DB %d: %v", db, err)
+	}
+
+	writer, err := storage.NewAOFWriter(dbConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AOF writer for DB %d: %v", db, err)
+	}
+	writer.SetSeq(reader.Seq())
+
+	d := newDatabase(db, data, writer, reader)
+	s.account(d, stats)
+
+	if err := s.reconcile(d, data); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	s.dbs[db] = d
+	return d, nil
+}
+
+// * 已載入的資料庫，依編號排序
+func (s *Server) databases() []*database {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
+	list := make([]*database, 0, len(s.dbs))
+	for _, d := range s.dbs {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// * 持有單一 KEY 所在分片的鎖
+// * 寫入模式記錄尚未等待 fsync 的 AOF 計數與是否需要更新 JSON 檔案，於釋放分片鎖後處理
+type keyLock struct {
+	server   *Server
+	db       *database
+	shard    *shard
+	key      string
+	entry    *Entry
+	write    bool
+	locked   bool
+	released bool
+	count    int64
+	dirty    bool
+}
+
+// * 以讀鎖取得 KEY，已過期的 KEY 視為不存在，留給背景清理或下一次寫入刪除
+func (s *Server) rlockKey(db int, key string) *keyLock {
+	lock := &keyLock{server: s, key: key}
+
+	d, isExist := s.getDB(db)
+	if !isExist {
+		lock.released = true
+		return lock
+	}
+
+	lock.db = d
+	lock.shard = d.shard(key)
+	d.mu.RLock()
+
+	for attempt := 0; ; attempt++ {
+		lock.shard.mu.RLock()
+		lock.locked = true
+
+		now := time.Now()
+		entry, isExist := lock.shard.data[key]
+		if !isExist || entry.IsExpired(now.UnixMilli()) {
+			return lock
+		}
+
+		if !entry.Evicted {
+			entry.Touch(now.UnixMilli())
+			lock.entry = entry
+			return lock
+		}
+
+		version := entry.Version
+		lock.unlock()
+
+		if attempt >= maxLoadAttempts {
+			slog.Error("Failed to load evicted key", "db", d.id, "key", key, "error", "key is being modified")
+			return lock
+		}
+
+		if err := s.loadEvicted(d, key, entry, version); err != nil {
+			slog.Error("Failed to read key", "db", d.id, "key", key, "error", err)
+			return lock
+		}
+	}
+}
+
+// * 以寫鎖取得 KEY，資料庫尚未載入時先載入
+// * 已過期的 KEY 在此刪除，COLLECTION 中已過期的文檔也在此移除
+func (s *Server) lockKey(db int, key string) (*keyLock, error) {
+	d, err := s.openDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &keyLock{server: s, db: d, shard: d.shard(key), key: key, write: true}
+	d.mu.RLock()
+
+	for attempt := 0; ; attempt++ {
+		lock.shard.mu.Lock()
+		lock.locked = true
+
+		now := time.Now()
+		entry, isExist := lock.shard.data[key]
+		if !isExist {
+			return lock, nil
+		}
+
+		if entry.IsExpired(now.UnixMilli()) {
+			lock.track(s.expireKey(d, lock.shard, key))
+			lock.dirty = true
+			return lock, nil
+		}
+
+		if !entry.Evicted {
+			if entry.IsCollection() {
+				if removed, count := s.purgeDocs(d, key, entry, now.UnixMilli()); removed > 0 {
+					lock.track(count)
+					lock.dirty = true
+				}
+			}
+
+			entry.Touch(now.UnixMilli())
+			lock.entry = entry
+			return lock, nil
+		}
+
+		if attempt >= maxLoadAttempts {
+			lock.release()
+			return nil, fmt.Errorf("failed to load evicted key %s: key is being modified", key)
+		}
+
+		version := entry.Version
+		lock.unlock()
+
+		if err := s.loadEvicted(d, key, entry, version); err != nil {
+			lock.release()
+			return nil, err
+		}
+	}
+}
+
+func (lock *keyLock) unlock() {
+	if !lock.locked {
+		return
+	}
+
+	lock.locked = false
+	if lock.write {
+		lock.shard.mu.Unlock()
+	} else {
+		lock.shard.mu.RUnlock()
+	}
+}
+
+func (lock *keyLock) track(count int64) {
+	if count > lock.count {
+		lock.count = count
+	}
+}
+
+// * 寫入 AOF 紀錄但不等待 fsync
+func (lock *keyLock) append(command string, value interface{}, expireAt *int64, args ...string) error {
+	count, err := lock.db.writer.Append(command, lock.key, value, expireAt, args...)
+	if err != nil {
+		return fmt.Errorf("failed to write AOF: %v", err)
+	}
+
+	lock.track(count)
+	return nil
+}
+
+// * 放入或替換 KEY
+func (lock *keyLock) set(entry *Entry) {
+	if old, isExist := lock.shard.data[lock.key]; isExist && old != entry {
+		lock.server.forget(old)
+		entry.Version = old.Version
+	}
+
+	lock.shard.insert(lock.key, entry)
+	entry.Touch(time.Now().UnixMilli())
+	lock.entry = entry
+	lock.changed()
+}
+
+// * 以 SET 紀錄寫入新的值並保留過期時間，重播時不需重新計算
+func (lock *keyLock) setValue(text string) error {
+	entry := lock.entry
+	if entry == nil {
+		entry = &Entry{}
+	}
+
+	if err := lock.append("SET", text, entry.ExpireAt); err != nil {
+		return err
+	}
+
+	entry.Value = text
+	entry.Type = storage.ValueType(text)
+	if lock.entry == nil {
+		lock.set(entry)
+	} else {
+		lock.changed()
+	}
+	return nil
+}
+
+// * KEY 內容原地修改後呼叫
+func (lock *keyLock) changed() {
+	lock.server.changed(lock.shard, lock.key, lock.entry)
+	lock.dirty = true
+}
+
+func (lock *keyLock) remove() {
+	lock.server.removeEntry(lock.shard, lock.key)
+	lock.entry = nil
+	lock.dirty = true
+}
+
+// * 釋放分片鎖後等待 fsync 並更新 JSON 檔案，全部完成才回覆
+func (lock *keyLock) commit(reply Reply) Reply {
+	if err := lock.persist(); err != nil {
+		return Errorf("%v", err)
+	}
+	return reply
+}
+
+func (lock *keyLock) persist() error {
+	lock.unlock()
+
+	if lock.db == nil {
+		return nil
+	}
+
+	count := lock.count
+	lock.count = 0
+	if err := lock.db.writer.Wait(count); err != nil {
+		return fmt.Errorf("failed to write AOF: %v", err)
+	}
+
+	if lock.dirty {
+		lock.dirty = false
+		if err := lock.server.syncFile(lock.db, lock.key); err != nil {
+			return fmt.Errorf("failed to write file: %v", err)
+		}
+	}
+	return nil
+}
+
+// * 搭配 defer 使用，未 commit 的修改 (例如刪除過期 KEY) 也會在此寫入磁碟
+func (lock *keyLock) release() {
+	if lock.released {
+		return
+	}
+
+	if err := lock.persist(); err != nil {
+		slog.Warn("Failed to persist key", "key", lock.key, "error", err)
+	}
+
+	lock.released = true
+	lock.db.mu.RUnlock()
+}
+
+// * 將 KEY 目前的狀態寫入 JSON 檔案，KEY 不存在時刪除檔案，保留原始建立時間
+// * 同一 KEY 的寫檔依序進行且每次都寫入當下最新的狀態，因此鎖外寫檔不會讓檔案內容倒退
+func (s *Server) syncFile(d *database, key string) error {
+	fileLock := d.fileLock(key)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	sh := d.shard(key)
+	sh.mu.RLock()
+	entry, isExist := sh.data[key]
+	if !isExist {
+		sh.mu.RUnlock()
+		d.preserve(key)
+		return d.writer.Delete(key)
+	}
+
+	if entry.Stored || entry.Evicted {
+		sh.mu.RUnlock()
+		return nil
+	}
+
+	version := entry.Version
+	now := time.Now().Unix()
+	cache := storage.Cache{
+		Key:           key,
+		Value:         entry.CacheValue(),
+		Type:          entry.Type,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		DocExpireAtMs: entry.DocExpireAt(),
+		Typed:         true,
+	}
+	if entry.ExpireAt != nil {
+		expireAt := *entry.ExpireAt
+		cache.ExpireAtMs = &expireAt
+	}
+	sh.mu.RUnlock()
+
+	d.preserve(key)
+	if existing, err := d.reader.Read(key); err == nil && existing != nil {
+		cache.CreatedAt = existing.CreatedAt
+	}
+
+	if err := d.writer.Save(key, cache); err != nil {
+		return err
+	}
+
+	sh.mu.Lock()
+	if sh.data[key] == entry && entry.Version == version {
+		entry.Stored = true
+	}
+	sh.mu.Unlock()
+
+	return nil
+}
+
+// * 刪除已過期的 KEY 並寫入 AOF，呼叫端需持有分片寫鎖，回傳 AOF 計數
+func (s *Server) expireKey(d *database, sh *shard, key string) int64 {
+	s.removeEntry(sh, key)
+	s.expiry.keys.Add(1)
+
+	count, err := d.writer.Append("DEL", key, nil, nil)
+	if err != nil {
+		slog.Warn("Failed to write AOF", "db", d.id, "key", key, "error", err)
+	}
+	return count
+}
+
+// * 移除 COLLECTION 中已過期的文檔並寫入 AOF，呼叫端需持有分片寫鎖
+func (s *Server) purgeDocs(d *database, key string, entry *Entry, now int64) (int, int64) {
+	list := entry.ExpiredDocs(now)
+	if len(list) == 0 {
+		return 0, 0
+	}
+
+	entry.RemoveDocs(list)
+	s.changed(d.shard(key), key, entry)
+	s.expiry.docs.Add(int64(len(list)))
+
+	count, err := d.writer.Append("REMOVE", key, nil, nil, list...)
+	if err != nil {
+		slog.Warn("Failed to write AOF", "db", d.id, "key", key, "error", err)
+	}
+	return len(list), count
+}
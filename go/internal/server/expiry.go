@@ -0,0 +1,275 @@
+package server
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// * 每次持有分片鎖時最多處理的到期 KEY 數量
+	expireBatch = 32
+
+	// * 每輪清理的時間預算下限；積壓時加倍，上限為每輪間隔的 25%
+	minExpireBudget = time.Millisecond
+)
+
+type expiryItem struct {
+	at  int64
+	key string
+}
+
+// * 依到期時間排序的最小堆積
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].at < h[j].at }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// * expired_keys 與 expired_documents 包含寫入時的惰性刪除
+type expiryState struct {
+	keys     atomic.Int64
+	docs     atomic.Int64
+	stale    atomic.Int64
+	cycles   atomic.Int64
+	backlogs atomic.Int64
+	busy     atomic.Int64
+	last     atomic.Int64
+	budget   atomic.Int64
+
+	// * 只由清理工作使用，累積到下一次輸出統計
+	reportKeys int
+	reportDocs int
+}
+
+// * 記錄 KEY 目前最早的到期時間，呼叫端需持有分片寫鎖
+// * 到期時間變更時不移除舊項目，取出時與 deadlines 比對不符即丟棄
+func (sh *shard) index(key string, entry *Entry) {
+	at := entry.Deadline()
+	if at == 0 {
+		delete(sh.deadlines, key)
+		return
+	}
+
+	if current, isExist := sh.deadlines[key]; isExist && current == at {
+		return
+	}
+
+	sh.deadlines[key] = at
+	heap.Push(&sh.expires, expiryItem{at, key})
+
+	// * 反覆變更過期時間會累積失效項目，超過有效項目兩倍時重建
+	if len(sh.expires) > 2*len(sh.deadlines)+64 {
+		sh.rebuild()
+	}
+}
+
+func (sh *shard) unindex(key string) {
+	delete(sh.deadlines, key)
+}
+
+func (sh *shard) rebuild() {
+	list := make(expiryHeap, 0, len(sh.deadlines))
+	for key, at := range sh.deadlines {
+		list = append(list, expiryItem{at, key})
+	}
+	heap.Init(&list)
+	sh.expires = list
+}
+
+// * 取出下一個已到期的 KEY，回傳略過的失效項目數
+func (sh *shard) nextDue(now int64) (string, int, bool) {
+	stale := 0
+	for len(sh.expires) > 0 {
+		item := sh.expires[0]
+		if item.at > now {
+			return "", stale, false
+		}
+
+		heap.Pop(&sh.expires)
+		if at, isExist := sh.deadlines[item.key]; !isExist || at != item.at {
+			stale++
+			continue
+		}
+
+		delete(sh.deadlines, item.key)
+		return item.key, stale, true
+	}
+	return "", stale, false
+}
+
+func (s *Server) expireBudget() time.Duration {
+	budget := time.Duration(s.expiry.budget.Load())
+	if budget < minExpireBudget {
+		budget = minExpireBudget
+	}
+	return budget
+}
+
+// * 依到期索引清理，只處理已到期的 KEY，不走訪整個資料庫
+// * 時間預算用完仍有到期 KEY 時下一輪預算加倍，沒有積壓時逐步減半
+func (s *Server) activeExpire(interval time.Duration) {
+	started := time.Now()
+	budget := s.expireBudget()
+	deadline := started.Add(budget)
+
+	list := s.databases()
+	cycle := s.expiry.cycles.Add(1)
+
+	isDone := true
+	for i := range list {
+		d := list[(int(cycle)+i)%len(list)]
+		if !s.expireDB(d, deadline) {
+			isDone = false
+			break
+		}
+	}
+
+	elapsed := time.Since(started)
+	s.expiry.busy.Add(int64(elapsed))
+	s.expiry.last.Store(int64(elapsed))
+
+	maxBudget := interval / 4
+	if maxBudget < minExpireBudget {
+		maxBudget = minExpireBudget
+	}
+
+	if !isDone {
+		s.expiry.backlogs.Add(1)
+		budget *= 2
+	} else {
+		budget /= 2
+	}
+	budget = min(max(budget, minExpireBudget), maxBudget)
+	s.expiry.budget.Store(int64(budget))
+}
+
+// * 逐一分片取出到期 KEY，超過時間預算時記下分片位置並回傳 false
+// * fsync 與 JSON 檔案更新在分片鎖外進行
+func (s *Server) expireDB(d *database, deadline time.Time) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := time.Now().UnixMilli()
+	keys, docs := 0, 0
+	var maxCount int64
+	var dirty []string
+
+	isDone := true
+	for n := 0; n < shardCount && isDone; n++ {
+		i := (d.cursor + n) % shardCount
+		sh := &d.shards[i]
+
+		for {
+			sh.mu.Lock()
+			more := false
+			for j := 0; j < expireBatch; j++ {
+				key, stale, ok := sh.nextDue(now)
+				s.expiry.stale.Add(int64(stale))
+				if !ok {
+					break
+				}
+				more = j == expireBatch-1
+
+				entry, isExist := sh.data[key]
+				if !isExist {
+					continue
+				}
+
+				var count int64
+				switch {
+				case entry.IsExpired(now):
+					count = s.expireKey(d, sh, key)
+					keys++
+				case entry.IsCollection() && !entry.Evicted:
+					var removed int
+					removed, count = s.purgeDocs(d, key, entry, now)
+					if removed == 0 {
+						sh.index(key, entry)
+						continue
+					}
+					docs += removed
+				default:
+					// * 已移出記憶體的 COLLECTION 無法清理文檔，改追蹤 KEY 本身的過期時間
+					sh.index(key, entry)
+					continue
+				}
+
+				dirty = append(dirty, key)
+				if count > maxCount {
+					maxCount = count
+				}
+			}
+			sh.mu.Unlock()
+
+			if time.Now().After(deadline) {
+				d.cursor = i
+				isDone = false
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+
+	if err := d.writer.Wait(maxCount); err != nil {
+		slog.Warn("Failed to write AOF", "db", d.id, "error", err)
+	}
+
+	for _, key := range dirty {
+		if err := s.syncFile(d, key); err != nil {
+			slog.Warn("Failed to write file", "db", d.id, "key", key, "error", err)
+		}
+	}
+
+	s.expiry.reportKeys += keys
+	s.expiry.reportDocs += docs
+	return isDone
+}
+
+// * 定期輸出上次輸出後清理的數量
+func (s *Server) reportExpire() {
+	if s.expiry.reportKeys > 0 || s.expiry.reportDocs > 0 {
+		slog.Info("Cleaned expired data", "keys", s.expiry.reportKeys, "documents", s.expiry.reportDocs)
+	}
+	s.expiry.reportKeys = 0
+	s.expiry.reportDocs = 0
+}
+
+func (s *Server) infoExpiry() string {
+	tracked, items := 0, 0
+	for _, d := range s.databases() {
+		d.mu.RLock()
+		for i := range d.shards {
+			sh := &d.shards[i]
+			sh.mu.RLock()
+			tracked += len(sh.deadlines)
+			items += len(sh.expires)
+			sh.mu.RUnlock()
+		}
+		d.mu.RUnlock()
+	}
+
+	return formatInfo("Expiry", [][2]string{
+		{"expired_keys", fmt.Sprintf("%d", s.expiry.keys.Load())},
+		{"expired_documents", fmt.Sprintf("%d", s.expiry.docs.Load())},
+		{"expire_tracked_keys", fmt.Sprintf("%d", tracked)},
+		{"expire_index_entries", fmt.Sprintf("%d", items)},
+		{"expire_stale_entries_skipped", fmt.Sprintf("%d", s.expiry.stale.Load())},
+		{"expire_cycles", fmt.Sprintf("%d", s.expiry.cycles.Load())},
+		{"expire_cycles_over_budget", fmt.Sprintf("%d", s.expiry.backlogs.Load())},
+		{"expire_cycle_budget_us", fmt.Sprintf("%d", s.expireBudget().Microseconds())},
+		{"expire_cycle_last_us", fmt.Sprintf("%d", time.Duration(s.expiry.last.Load()).Microseconds())},
+		{"expire_cycle_cpu_milliseconds", fmt.Sprintf("%d", time.Duration(s.expiry.busy.Load()).Milliseconds())},
+	})
+}
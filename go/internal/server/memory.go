@@ -0,0 +1,393 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-jsondb/internal/command"
+	"go-jsondb/internal/storage"
+)
+
+// * 淘汰時 KEY 剛好被修改而略過的次數上限，超過則視為無法釋放記憶體
+const maxEvictSkips = 16
+
+type memoryState struct {
+	used      atomic.Int64
+	evicted   atomic.Int64
+	loaded    atomic.Int64
+	onDisk    atomic.Int64
+	loadError atomic.Int64
+
+	// * 同一時間只有一個連線執行淘汰，避免並行淘汰過多 KEY
+	evictMu sync.Mutex
+}
+
+var errOOM = fmt.Errorf("OOM command not allowed when used memory > 'maxmemory'")
+
+// * 可能增加記憶體用量的指令，超過 maxmemory 且無法淘汰時拒絕執行
+func denyOOM(cmdType command.CommandType) bool {
+	switch cmdType {
+	case command.SET, command.ADD, command.UPDATE,
+		command.INCR, command.DECR, command.INCRBY, command.DECRBY, command.INCRBYFLOAT:
+		return true
+	}
+	return false
+}
+
+// * 呼叫端需持有分片寫鎖
+func (s *Server) removeEntry(sh *shard, key string) {
+	if entry, isExist := sh.data[key]; isExist {
+		s.forget(entry)
+		sh.delete(key)
+		sh.unindex(key)
+	}
+}
+
+func (s *Server) forget(entry *Entry) {
+	s.memory.used.Add(-entry.Size)
+	if entry.Evicted {
+		s.memory.onDisk.Add(-1)
+	}
+}
+
+// * KEY 內容修改後遞增版本、重新估算大小並更新到期索引，JSON 檔案需重新寫入
+func (s *Server) changed(sh *shard, key string, entry *Entry) {
+	entry.Version++
+	entry.Stored = false
+	s.resize(key, entry)
+	sh.index(key, entry)
+}
+
+// * KEY 內容原地修改後重新估算大小
+func (s *Server) resize(key string, entry *Entry) {
+	size := storage.EstimateSize(key, entry)
+	s.memory.used.Add(size - entry.Size)
+	entry.Size = size
+}
+
+// * 載入資料庫後計算初始用量，並還原上次保存的存取統計
+func (s *Server) account(d *database, stats *storage.Stats) {
+	now := time.Now().UnixMilli()
+	d.each(func(key string, entry *Entry) {
+		entry.Access = now
+		if stats != nil {
+			if stat, isExist := stats.Keys[key]; isExist {
+				entry.Access = stat.Access
+				entry.Hits = stat.Hits
+			}
+		}
+		if entry.Evicted {
+			s.memory.onDisk.Add(1)
+		}
+		s.resize(key, entry)
+	})
+}
+
+// * 在鎖外讀取 JSON 檔案再以寫鎖還原，期間 KEY 被修改或替換時放棄此次結果，由呼叫端重試
+func (s *Server) loadEvicted(d *database, key string, entry *Entry, version uint64) error {
+	cache, err := d.reader.Read(key)
+
+	sh := d.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.data[key] != entry || !entry.Evicted || entry.Version != version {
+		return nil
+	}
+
+	if err == nil {
+		err = entry.Restore(cache)
+	}
+	if err != nil {
+		s.memory.loadError.Add(1)
+		return fmt.Errorf("failed to load evicted key %s: %v", key, err)
+	}
+
+	s.memory.loaded.Add(1)
+	s.memory.onDisk.Add(-1)
+	s.resize(key, entry)
+	sh.index(key, entry)
+	return nil
+}
+
+// * 淘汰 KEY 直到低於 maxmemory，noeviction 或沒有可淘汰的 KEY 時回傳 OOM
+func (s *Server) freeMemory() error {
+	option := s.option()
+	maxMemory := option.MaxMemory
+	if maxMemory <= 0 || s.memory.used.Load() <= maxMemory {
+		return nil
+	}
+
+	if option.MaxMemoryPolicy == storage.PolicyNoEviction {
+		return errOOM
+	}
+
+	s.memory.evictMu.Lock()
+	defer s.memory.evictMu.Unlock()
+
+	skips := 0
+	for s.memory.used.Load() > maxMemory {
+		d, key, ok := s.evictionCandidate(option)
+		if !ok {
+			return errOOM
+		}
+
+		isEvicted, err := s.evictKey(d, key)
+		if err != nil {
+			slog.Warn("Failed to evict key", "key", key, "error", err)
+			return errOOM
+		}
+
+		if !isEvicted {
+			if skips++; skips >= maxEvictSkips {
+				return errOOM
+			}
+		}
+	}
+
+	return nil
+}
+
+// * 從各資料庫與分片隨機取樣，依淘汰策略挑出最適合的 KEY
+func (s *Server) evictionCandidate(option storage.Option) (*database, string, bool) {
+	policy := option.MaxMemoryPolicy
+	samples := option.MaxMemorySamples
+	if samples <= 0 {
+		samples = 5
+	}
+
+	volatile := policy == storage.PolicyVolatileLRU || policy == storage.PolicyVolatileTTL
+	now := time.Now().UnixMilli()
+
+	var (
+		bestDB    *database
+		bestKey   string
+		bestScore int64
+		found     bool
+	)
+
+	list := s.databases()
+	rand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+
+	count := 0
+	for _, d := range list {
+		d.mu.RLock()
+		start := rand.Intn(shardCount)
+
+		for i := 0; i < shardCount && count < samples; i++ {
+			sh := &d.shards[(start+i)%shardCount]
+			sh.mu.RLock()
+
+			// * map 走訪起點隨機，取前幾個符合條件的 KEY 即為隨機取樣
+			for key, entry := range sh.data {
+				if entry.Evicted || (volatile && entry.ExpireAt == nil) {
+					continue
+				}
+
+				var score int64
+				switch policy {
+				case storage.PolicyAllKeysLFU:
+					score = int64(entry.Frequency(now))
+				case storage.PolicyVolatileTTL:
+					score = *entry.ExpireAt
+				default:
+					score = entry.LastAccess()
+				}
+
+				if !found || score < bestScore {
+					bestDB, bestKey, bestScore, found = d, key, score, true
+				}
+
+				count++
+				if count >= samples {
+					break
+				}
+			}
+
+			sh.mu.RUnlock()
+		}
+
+		d.mu.RUnlock()
+		if count >= samples {
+			break
+		}
+	}
+
+	return bestDB, bestKey, found
+}
+
+// * 值保留在 JSON 檔案中，記憶體只留下 KEY、類型與過期時間
+// * 檔案不是最新時先補寫 (在分片鎖外)，寫入後 KEY 又被修改則略過這次淘汰
+func (s *Server) evictKey(d *database, key string) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if err := s.syncFile(d, key); err != nil {
+		return false, err
+	}
+
+	sh := d.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	entry, isExist := sh.data[key]
+	if !isExist || entry.Evicted || !entry.Stored {
+		return false, nil
+	}
+
+	entry.Evict()
+	s.resize(key, entry)
+	s.memory.evicted.Add(1)
+	s.memory.onDisk.Add(1)
+	return true, nil
+}
+
+// * 背景持久化使用的資料庫副本: 記憶體中的 KEY 直接複製，已移出記憶體的 KEY 只記錄名稱
+// * 釋放資料庫鎖後才逐一由 JSON 檔案讀出，不需同時保留所有值，也不會在讀檔期間阻塞其他連線
+type dbClone struct {
+	d       *database
+	entries map[string]*Entry
+	evicted []string
+	pending *pendingFiles
+	closed  bool
+}
+
+// * 尚未讀出的已移出記憶體 KEY，JSON 檔案在讀出前被覆寫或刪除時先保留原本的內容
+type pendingFiles struct {
+	mu    sync.Mutex
+	keys  map[string]bool
+	saved map[string]pendingFile
+}
+
+type pendingFile struct {
+	cache *storage.Cache
+	err   error
+}
+
+// * 呼叫端需持有 d.mu 的寫鎖，確保副本與 AOF 位置一致，使用完畢需呼叫 close
+func (s *Server) cloneDB(d *database) *dbClone {
+	now := time.Now().UnixMilli()
+	clone := &dbClone{
+		d:       d,
+		entries: make(map[string]*Entry),
+		pending: &pendingFiles{
+			keys:  make(map[string]bool),
+			saved: make(map[string]pendingFile),
+		},
+	}
+
+	d.each(func(key string, entry *Entry) {
+		if entry.IsExpired(now) {
+			return
+		}
+
+		if entry.Evicted {
+			clone.evicted = append(clone.evicted, key)
+			clone.pending.keys[key] = true
+			return
+		}
+		clone.entries[key] = entry.Clone()
+	})
+
+	if len(clone.evicted) > 0 {
+		d.pendingMu.Lock()
+		d.pending = append(d.pending, clone.pending)
+		d.pendingMu.Unlock()
+	}
+	return clone
+}
+
+func (c *dbClone) len() int {
+	return len(c.entries) + len(c.evicted)
+}
+
+// * 依序交給 fn，已移出記憶體的 KEY 每次只讀出一個
+func (c *dbClone) each(fn func(key string, entry *Entry) error) error {
+	for key, entry := range c.entries {
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range c.evicted {
+		entry, err := c.load(key)
+		if err != nil {
+			return fmt.Errorf("failed to load evicted key %s: %v", key, err)
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// * 持有檔案鎖時 JSON 檔案不會被覆寫，尚未被 syncFile 保留時檔案內容即為複製當下的值
+func (c *dbClone) load(key string) (*Entry, error) {
+	c.d.mu.RLock()
+	defer c.d.mu.RUnlock()
+
+	fileLock := c.d.fileLock(key)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	c.pending.mu.Lock()
+	file, isSaved := c.pending.saved[key]
+	delete(c.pending.keys, key)
+	delete(c.pending.saved, key)
+	c.pending.mu.Unlock()
+
+	if !isSaved {
+		file.cache, file.err = c.d.reader.Read(key)
+	}
+	if file.err != nil {
+		return nil, file.err
+	}
+	if file.cache == nil {
+		return nil, fmt.Errorf("file not found")
+	}
+	return storage.EntryFromCache(file.cache)
+}
+
+func (c *dbClone) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	d := c.d
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	for i, e := range d.pending {
+		if e == c.pending {
+			d.pending = append(d.pending[:i], d.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// * JSON 檔案覆寫或刪除前，交給仍需要複製當下內容的背景持久化，呼叫端需持有 KEY 的檔案鎖
+func (d *database) preserve(key string) {
+	d.pendingMu.Lock()
+	list := append([]*pendingFiles(nil), d.pending...)
+	d.pendingMu.Unlock()
+
+	var file pendingFile
+	isRead := false
+	for _, pending := range list {
+		pending.mu.Lock()
+		if pending.keys[key] {
+			if !isRead {
+				file.cache, file.err = d.reader.Read(key)
+				isRead = true
+			}
+			pending.saved[key] = file
+			delete(pending.keys, key)
+		}
+		pending.mu.Unlock()
+	}
+}
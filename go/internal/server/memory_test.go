@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go-jsondb/internal/storage"
+)
+
+// * 取得 KEY 目前是否已移出記憶體
+func isEvicted(t *testing.T, server *Server, db int, key string) bool {
+	t.Helper()
+
+	d, isExist := server.getDB(db)
+	if !isExist {
+		t.Fatalf("DB %d is not loaded", db)
+	}
+
+	sh := d.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	entry, isExist := sh.data[key]
+	if !isExist {
+		t.Fatalf("key %s does not exist", key)
+	}
+	return entry.Evicted
+}
+
+// * 超過 maxmemory 時依淘汰策略移出最冷的 KEY，讀取時由 JSON 檔案讀回原本的值
+func TestEvictionPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		// * 讓 cold 成為唯一的淘汰對象
+		prepare []string
+	}{
+		{
+			policy:  storage.PolicyAllKeysLRU,
+			prepare: []string{`GET k1`, `GET k2`, `GET k3`, `GET doc`},
+		},
+		{
+			policy:  storage.PolicyAllKeysLFU,
+			prepare: []string{`GET k1`, `GET k1`, `GET k2`, `GET k2`, `GET k3`, `GET k3`, `FIND doc {}`, `FIND doc {}`},
+		},
+		{
+			policy:  storage.PolicyVolatileTTL,
+			prepare: []string{`EXPIRE cold 1000`, `EXPIRE k1 2000`, `EXPIRE doc 3000`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			config := testConfig(t)
+			config.Option.MaxMemoryPolicy = tt.policy
+			config.Option.MaxMemorySamples = 100
+			server := openServer(t, config)
+			defer server.Close()
+
+			client := server.NewClient()
+			for _, input := range []string{`SET cold '{"v":"cold"}'`, `ADD doc {"_id":"d1","n":1}`} {
+				if reply := exec(t, client, input); reply.IsError() {
+					t.Fatalf("%s: %s", input, reply.Str)
+				}
+			}
+
+			// * LRU 以毫秒記錄存取時間，cold 之後的 KEY 需晚一點寫入
+			time.Sleep(5 * time.Millisecond)
+			for i := 1; i <= 3; i++ {
+				if reply := exec(t, client, fmt.Sprintf("SET k%d value-%d", i, i)); reply.IsError() {
+					t.Fatalf("SET k%d: %s", i, reply.Str)
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			for _, input := range tt.prepare {
+				if reply := exec(t, client, input); reply.IsError() {
+					t.Fatalf("%s: %s", input, reply.Str)
+				}
+			}
+
+			// * 上限略低於目前用量，下一次寫入只需淘汰一個 KEY
+			used := server.memory.used.Load()
+			if reply := exec(t, client, fmt.Sprintf("CONFIG SET maxmemory %d", used-1)); reply.IsError() {
+				t.Fatalf("CONFIG SET maxmemory: %s", reply.Str)
+			}
+			if reply := exec(t, client, `SET trigger 1`); reply.IsError() {
+				t.Fatalf("SET trigger: %s", reply.Str)
+			}
+
+			if !isEvicted(t, server, 0, "cold") {
+				t.Fatalf("cold was not evicted")
+			}
+			for _, key := range []string{"k1", "k2", "k3", "doc", "trigger"} {
+				if isEvicted(t, server, 0, key) {
+					t.Errorf("%s was evicted instead of cold", key)
+				}
+			}
+			if got := server.memory.evicted.Load(); got != 1 {
+				t.Fatalf("evicted %d keys, want 1", got)
+			}
+
+			// * 放寬上限後讀取，值由 JSON 檔案讀回
+			if reply := exec(t, client, `CONFIG SET maxmemory 0`); reply.IsError() {
+				t.Fatalf("CONFIG SET maxmemory: %s", reply.Str)
+			}
+			if got := exec(t, client, `GET cold`).Text(); got != `{"v":"cold"}` {
+				t.Fatalf("GET cold = %q, want %q", got, `{"v":"cold"}`)
+			}
+			if isEvicted(t, server, 0, "cold") || server.memory.loaded.Load() != 1 {
+				t.Fatalf("cold was not loaded back into memory")
+			}
+		})
+	}
+}
+
+// * 所有 KEY 都無法淘汰時拒絕寫入
+func TestEvictionOOM(t *testing.T) {
+	config := testConfig(t)
+	config.Option.MaxMemory = 1
+	config.Option.MaxMemoryPolicy = storage.PolicyVolatileTTL
+	server := openServer(t, config)
+	defer server.Close()
+
+	client := server.NewClient()
+	if reply := exec(t, client, `SET a 1`); reply.IsError() {
+		t.Fatalf("SET a: %s", reply.Str)
+	}
+
+	reply := exec(t, client, `SET b 1`)
+	if !reply.IsError() || reply.Str != errOOM.Error() {
+		t.Fatalf("SET b = %q, want OOM", reply.Text())
+	}
+	if got := exec(t, client, `GET a`).Text(); got != "1" {
+		t.Fatalf("GET a = %q, want 1", got)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"testing"
+
+	"go-jsondb/internal/command"
+	"go-jsondb/internal/storage"
+)
+
+type replayQuery struct {
+	cmd  string
+	want string
+}
+
+func testConfig(t testing.TB) storage.Config {
+	t.Helper()
+
+	config := storage.NewConfig()
+	config.Option.DBPath = t.TempDir()
+	config.Option.WarmOnStart = false
+	return config
+}
+
+func openServer(t *testing.T, config storage.Config) *Server {
+	t.Helper()
+
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return server
+}
+
+func exec(t *testing.T, client *Client, input string) Reply {
+	t.Helper()
+
+	cmd, err := command.NewParser().Parse(input)
+	if err != nil {
+		t.Fatalf("Parse %q: %v", input, err)
+	}
+	return client.Exec(cmd)
+}
+
+// * 指令經由 AOF 重播後，重啟前後的查詢結果需一致
+func TestReplayAfterRestart(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		queries  []replayQuery
+	}{
+		{
+			name:     "SET keeps the exact text",
+			commands: []string{`SET a 1.10`, `SET b '"hi"'`, `SET c { "x" : 1 }`, `SET d 123456789012345678901234567890`},
+			queries: []replayQuery{
+				{`GET a`, `1.10`}, {`TYPE a`, `float`},
+				{`GET b`, `"hi"`}, {`TYPE b`, `string`},
+				{`GET c`, `{ "x" : 1 }`}, {`TYPE c`, `object`},
+				{`GET d`, `123456789012345678901234567890`}, {`TYPE d`, `int`},
+			},
+		},
+		{
+			name:     "SET with options",
+			commands: []string{`SET a 1 EXAT 4102444800`, `SET a 2 KEEPTTL`, `SET b 1 PXAT 1`, `SET c 1 NX`, `SET c 2 NX`, `SET d 1 XX`},
+			queries: []replayQuery{
+				{`GET a`, `2`}, {`EXPIRETIME a`, `(integer) 4102444800`},
+				{`EXISTS b`, `(integer) 0`}, {`GET c`, `1`}, {`EXISTS d`, `(integer) 0`},
+			},
+		},
+		{
+			name:     "ADD, UPDATE and REMOVE",
+			commands: []string{`ADD c {"_id":"d1","n":1}`, `ADD c {"_id":"d2","n":2}`, `ADD c {"_id":"d3","n":3}`, `UPDATE c {"_id":"d2"} {"$set":{"n":20}}`, `REMOVE c {"_id":"d3"}`},
+			queries: []replayQuery{
+				{`FIND c {"n":20}`, `[{"_id":"d2","n":20}]`},
+				{`FIND c {"_id":"d3"}`, `[]`},
+				{`FIND c {"n":1}`, `[{"_id":"d1","n":1}]`},
+			},
+		},
+		{
+			name:     "EXPIRE and PERSIST a key",
+			commands: []string{`SET a 1`, `SET b 1`, `SET c 1`, `EXPIREAT a 4102444800`, `EXPIREAT b 4102444800`, `PERSIST b`, `PEXPIREAT c 1`},
+			queries: []replayQuery{
+				{`EXPIRETIME a`, `(integer) 4102444800`},
+				{`EXPIRETIME b`, `(integer) -1`},
+				{`EXISTS c`, `(integer) 0`},
+			},
+		},
+		{
+			name:     "EXPIRE and PERSIST with a filter",
+			commands: []string{`ADD c {"_id":"d1","n":1}`, `ADD c {"_id":"d2","n":2}`, `ADD c {"_id":"d3","n":3}`, `EXPIREAT c 4102444800 {"n":{"$gte":2}}`, `PERSIST c {"n":3}`, `PEXPIREAT c 1 {"n":1}`},
+			queries: []replayQuery{
+				{`EXPIRETIME c`, `(integer) -1`},
+				{`EXPIRETIME c {"n":2}`, `[{"_id":"d2","expire_time":4102444800}]`},
+				{`EXPIRETIME c {"n":3}`, `[{"_id":"d3","expire_time":-1}]`},
+				{`FIND c {"n":1}`, `[]`},
+			},
+		},
+		{
+			name:     "DEL",
+			commands: []string{`SET a 1`, `ADD b {"_id":"d1"}`, `SET c 1`, `DEL a b`},
+			queries: []replayQuery{
+				{`EXISTS a`, `(integer) 0`}, {`EXISTS b`, `(integer) 0`}, {`EXISTS c`, `(integer) 1`},
+			},
+		},
+		{
+			name:     "INCR family",
+			commands: []string{`INCR a`, `INCRBY a 10`, `DECR a`, `DECRBY a 3`, `SET b 1.5 EXAT 4102444800`, `INCRBYFLOAT b 0.25`},
+			queries: []replayQuery{
+				{`GET a`, `7`}, {`TYPE a`, `int`},
+				{`GET b`, `1.75`}, {`TYPE b`, `float`}, {`EXPIRETIME b`, `(integer) 4102444800`},
+			},
+		},
+		{
+			name:     "snapshot followed by AOF records",
+			commands: []string{`SET a 1`, `ADD c {"_id":"d1","n":1}`, `SAVE`, `SET a 2`, `ADD c {"_id":"d2","n":2}`, `DEL a`, `SET a 3`},
+			queries: []replayQuery{
+				{`GET a`, `3`},
+				{`FIND c {}`, `[{"_id":"d1","n":1},{"_id":"d2","n":2}]`},
+			},
+		},
+		{
+			name:     "AOF rewritten after snapshot",
+			commands: []string{`SET a 1`, `ADD c {"_id":"d1","n":1}`, `SAVE`, `SET a 2`, `BGREWRITEAOF`, `ADD c {"_id":"d2","n":2}`},
+			queries: []replayQuery{
+				{`GET a`, `2`},
+				{`FIND c {}`, `[{"_id":"d1","n":1},{"_id":"d2","n":2}]`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+
+			server := openServer(t, config)
+			client := server.NewClient()
+			for _, input := range tt.commands {
+				if reply := exec(t, client, input); reply.IsError() {
+					t.Fatalf("%s: %s", input, reply.Str)
+				}
+			}
+			check(t, server, tt.queries)
+			if err := server.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			server = openServer(t, config)
+			defer server.Close()
+			check(t, server, tt.queries)
+		})
+	}
+}
+
+func check(t *testing.T, server *Server, queries []replayQuery) {
+	t.Helper()
+
+	client := server.NewClient()
+	for _, q := range queries {
+		if got := exec(t, client, q.cmd).Text(); got != q.want {
+			t.Errorf("%s = %q, want %q", q.cmd, got, q.want)
+		}
+	}
+}
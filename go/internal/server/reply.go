@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ReplyType int
+
+const (
+	ReplyStatus ReplyType = iota
+	ReplyError
+	ReplyInteger
+	ReplyBulk
+	ReplyNil
+	ReplyArray
+	ReplyMap
+)
+
+// * 指令回應，依連線協定輸出為文字或 RESP
+type Reply struct {
+	Type ReplyType
+	Str  string
+	Int  int64
+	List []Reply
+}
+
+func Status(str string) Reply {
+	return Reply{Type: ReplyStatus, Str: str}
+}
+
+func Errorf(format string, args ...interface{}) Reply {
+	return Reply{Type: ReplyError, Str: fmt.Sprintf(format, args...)}
+}
+
+func Integer(num int64) Reply {
+	return Reply{Type: ReplyInteger, Int: num}
+}
+
+func Bulk(str string) Reply {
+	return Reply{Type: ReplyBulk, Str: str}
+}
+
+func Nil() Reply {
+	return Reply{Type: ReplyNil}
+}
+
+func Array(list ...Reply) Reply {
+	if list == nil {
+		list = []Reply{}
+	}
+	return Reply{Type: ReplyArray, List: list}
+}
+
+func StrArray(list []string) Reply {
+	replies := make([]Reply, 0, len(list))
+	for _, e := range list {
+		replies = append(replies, Bulk(e))
+	}
+	return Array(replies...)
+}
+
+// * key / value 交錯排列，RESP3 輸出為 map，RESP2 輸出為陣列
+func Map(list ...Reply) Reply {
+	return Reply{Type: ReplyMap, List: list}
+}
+
+func (r Reply) IsError() bool {
+	return r.Type == ReplyError
+}
+
+// * 互動式文字協定的輸出格式
+func (r Reply) Text() string {
+	switch r.Type {
+	case ReplyStatus, ReplyBulk:
+		return r.Str
+	case ReplyError:
+		return "Error: " + r.Str
+	case ReplyInteger:
+		return fmt.Sprintf("(integer) %d", r.Int)
+	case ReplyNil:
+		return "(nil)"
+	default:
+		if len(r.List) == 0 {
+			return "(empty)"
+		}
+
+		lines := make([]string, 0, len(r.List))
+		for i, e := range r.List {
+			lines = append(lines, fmt.Sprintf("%d) %s", i+1, e.Text()))
+		}
+		return strings.Join(lines, "\n")
+	}
+}
+
+func (r Reply) WriteRESP(writer *bufio.Writer, proto int) {
+	switch r.Type {
+	case ReplyStatus:
+		writer.WriteString("+" + oneLine(r.Str) + "\r\n")
+	case ReplyError:
+		writer.WriteString("-" + errorCode(oneLine(r.Str)) + "\r\n")
+	case ReplyInteger:
+		writer.WriteString(":" + strconv.FormatInt(r.Int, 10) + "\r\n")
+	case ReplyBulk:
+		writer.WriteString("$" + strconv.Itoa(len(r.Str)) + "\r\n" + r.Str + "\r\n")
+	case ReplyNil:
+		if proto >= 3 {
+			writer.WriteString("_\r\n")
+		} else {
+			writer.WriteString("$-1\r\n")
+		}
+	case ReplyArray, ReplyMap:
+		if r.Type == ReplyMap && proto >= 3 {
+			writer.WriteString("%" + strconv.Itoa(len(r.List)/2) + "\r\n")
+		} else {
+			writer.WriteString("*" + strconv.Itoa(len(r.List)) + "\r\n")
+		}
+
+		for _, e := range r.List {
+			e.WriteRESP(writer, proto)
+		}
+	}
+}
+
+func oneLine(str string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(str)
+}
+
+// * RESP 客戶端依錯誤代碼判斷錯誤種類，只有明確列出的代碼維持原樣
+var errorCodes = map[string]bool{
+	"WRONGTYPE": true,
+	"NOAUTH":    true,
+	"NOPERM":    true,
+	"WRONGPASS": true,
+	"OOM":       true,
+	"NOPROTO":   true,
+	"EXECABORT": true,
+}
+
+// * 已帶錯誤代碼 (如 WRONGTYPE) 的訊息維持原樣，其餘補上 ERR
+func errorCode(str string) string {
+	code, _, _ := strings.Cut(str, " ")
+	if errorCodes[code] {
+		return str
+	}
+	return "ERR " + str
+}
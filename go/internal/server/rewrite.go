@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-jsondb/internal/storage"
+)
+
+const rewriteLogEvery = 10000
+
+type rewriteState struct {
+	mu         sync.Mutex
+	running    bool
+	db         int
+	written    int
+	total      int
+	count      int
+	lastStatus string
+	lastError  string
+	lastTime   time.Duration
+	startedAt  time.Time
+}
+
+// * 背景重寫指定資料庫的 AOF，未指定時重寫所有已載入的資料庫
+func (s *Server) startRewrite(list ...int) error {
+	s.rewrite.mu.Lock()
+	if s.rewrite.running {
+		s.rewrite.mu.Unlock()
+		return fmt.Errorf("background AOF rewrite already in progress")
+	}
+	s.rewrite.running = true
+	s.rewrite.startedAt = time.Now()
+	s.rewrite.mu.Unlock()
+
+	if len(list) == 0 {
+		for _, d := range s.databases() {
+			list = append(list, d.id)
+		}
+	}
+
+	isStarted := s.goTask(func() {
+		var err error
+		for _, db := range list {
+			if err = s.rewriteDB(db); err != nil {
+				slog.Error("AOF rewrite failed", "db", db, "error", err)
+				break
+			}
+		}
+
+		s.rewrite.mu.Lock()
+		defer s.rewrite.mu.Unlock()
+
+		s.rewrite.running = false
+		s.rewrite.count++
+		s.rewrite.lastTime = time.Since(s.rewrite.startedAt)
+		if err != nil {
+			s.rewrite.lastStatus = "err"
+			s.rewrite.lastError = err.Error()
+		} else {
+			s.rewrite.lastStatus = "ok"
+			s.rewrite.lastError = ""
+		}
+	})
+
+	if !isStarted {
+		s.rewrite.mu.Lock()
+		s.rewrite.running = false
+		s.rewrite.mu.Unlock()
+		return errShuttingDown
+	}
+	return nil
+}
+
+func (s *Server) rewriteDB(db int) error {
+	d, isExist := s.getDB(db)
+	if !isExist {
+		return nil
+	}
+
+	// * 在同一個臨界區內複製資料並開始暫存新寫入
+	d.mu.Lock()
+	clone := s.cloneDB(d)
+	rewrite, err := d.writer.StartRewrite()
+	d.mu.Unlock()
+	defer clone.close()
+	if err != nil {
+		return err
+	}
+
+	total := clone.len()
+	s.setRewriteProgress(db, 0, total)
+	slog.Info("AOF rewrite started", "db", db, "keys", total)
+
+	written := 0
+	err = clone.each(func(key string, entry *Entry) error {
+		for _, record := range storage.Records(key, entry) {
+			if err := rewrite.Append(record); err != nil {
+				return err
+			}
+		}
+
+		written++
+		if written%rewriteLogEvery == 0 {
+			s.setRewriteProgress(db, written, total)
+			slog.Info("AOF rewrite progress", "db", db, "keys", written, "total", total)
+		}
+		return nil
+	})
+	if err != nil {
+		rewrite.Abort()
+		return err
+	}
+
+	s.setRewriteProgress(db, written, total)
+	return rewrite.Commit()
+}
+
+func (s *Server) setRewriteProgress(db, written, total int) {
+	s.rewrite.mu.Lock()
+	defer s.rewrite.mu.Unlock()
+
+	s.rewrite.db = db
+	s.rewrite.written = written
+	s.rewrite.total = total
+}
+
+// * 依 AOF 成長比例自動觸發重寫
+func (s *Server) checkRewrite() {
+	s.rewrite.mu.Lock()
+	running := s.rewrite.running
+	s.rewrite.mu.Unlock()
+	if running {
+		return
+	}
+
+	option := s.option()
+
+	var list []int
+	for _, d := range s.databases() {
+		if d.writer.NeedRewrite(option.AutoRewritePercentage, option.AutoRewriteMinSize) {
+			list = append(list, d.id)
+		}
+	}
+
+	if len(list) == 0 {
+		return
+	}
+
+	slog.Info("Starting automatic AOF rewrite", "db", list)
+	if err := s.startRewrite(list...); err != nil {
+		slog.Error("Failed to start automatic AOF rewrite", "error", err)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"go-jsondb/internal/storage"
+)
+
+const (
+	// * 游標的低位為分片內的位置，高位為分片序號
+	scanBits = 27
+	scanMask = 1<<scanBits - 1
+
+	// * 每個掃描槽平均的 KEY 數量，槽數隨分片的 KEY 數量以 2 的次方增減
+	scanLoad = 4
+
+	// * 每次 SCAN 最多走訪 COUNT 的幾倍個槽，避免空槽過多時單次呼叫過久
+	scanEmptyFactor = 10
+)
+
+// * 分片已使用 KEY 雜湊的低位，掃描槽使用其餘的位元
+func scanHash(key string) uint32 {
+	return keyHash(key) >> 5 & scanMask
+}
+
+// * 呼叫端需持有分片寫鎖
+func (sh *shard) insert(key string, entry *Entry) {
+	if _, isExist := sh.data[key]; !isExist {
+		if len(sh.data) >= len(sh.slots)*scanLoad {
+			sh.resizeSlots(max(1, len(sh.slots)*2))
+		}
+
+		slot := scanHash(key) & uint32(len(sh.slots)-1)
+		sh.slots[slot] = append(sh.slots[slot], key)
+	}
+	sh.data[key] = entry
+}
+
+func (sh *shard) delete(key string) {
+	if _, isExist := sh.data[key]; !isExist {
+		return
+	}
+	delete(sh.data, key)
+
+	slot := scanHash(key) & uint32(len(sh.slots)-1)
+	list := sh.slots[slot]
+	for i, e := range list {
+		if e == key {
+			list[i] = list[len(list)-1]
+			list[len(list)-1] = ""
+			sh.slots[slot] = list[:len(list)-1]
+			break
+		}
+	}
+
+	if len(sh.slots) > 1 && len(sh.data) < len(sh.slots)*scanLoad/4 {
+		sh.resizeSlots(len(sh.slots) / 2)
+	}
+}
+
+func (sh *shard) resizeSlots(size int) {
+	slots := make([][]string, size)
+	for _, list := range sh.slots {
+		for _, key := range list {
+			slot := scanHash(key) & uint32(size-1)
+			slots[slot] = append(slots[slot], key)
+		}
+	}
+	sh.slots = slots
+}
+
+// * 以反轉位元的順序遞增游標，槽數加倍或減半後，已走訪的槽仍對應到已走訪的位置
+// * 整個走訪期間都存在的 KEY 至少回傳一次，槽數減半時可能重複回傳
+func nextScanCursor(v, mask uint32) uint32 {
+	v |= scanMask &^ mask
+	v = bits.Reverse32(v) >> (32 - scanBits)
+	v++
+	v = bits.Reverse32(v << (32 - scanBits))
+	return v & scanMask
+}
+
+func parseScanCursor(str string) (int, error) {
+	cursor, err := strconv.ParseUint(str, 10, 64)
+	if err != nil || cursor >= shardCount<<scanBits {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return int(cursor), nil
+}
+
+// * 從游標所在的槽開始，逐槽收集符合條件的 KEY，直到數量達到 count 或走訪的槽數達上限
+// * 槽數依 KEY 數量調整，走訪的槽數與回傳的 KEY 數都與 count 相近，超出的部分不會多於一個槽
+// * 同一分片連續的槽在同一次讀鎖內處理，回傳下一個游標，0 代表走訪結束
+func (d *database) scan(cursor, count int, match func(key string, entry *Entry) bool) (int, []string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	list := make([]string, 0, min(count, 1024))
+	index, v := cursor>>scanBits, uint32(cursor&scanMask)
+	visits, budget := 0, count*scanEmptyFactor
+
+	for index < shardCount && len(list) < count && visits < budget {
+		sh := &d.shards[index]
+		sh.mu.RLock()
+		for {
+			mask := uint32(len(sh.slots) - 1)
+			for _, key := range sh.slots[v&mask] {
+				if match(key, sh.data[key]) {
+					list = append(list, key)
+				}
+			}
+
+			v = nextScanCursor(v, mask)
+			visits++
+			if v == 0 || len(list) >= count || visits >= budget {
+				break
+			}
+		}
+		sh.mu.RUnlock()
+
+		if v == 0 {
+			index++
+		}
+	}
+
+	if index >= shardCount {
+		return 0, list
+	}
+	return index<<scanBits | int(v), list
+}
+
+// * FSCAN 游標為上次回傳最後一個文檔 _id 的 hex，"0" 代表開始或結束
+// * 文檔依 _id 排序走訪，移除其他文檔或新增文檔都不影響已存在文檔的順序
+func parseDocCursor(str string) (string, error) {
+	if str == "0" {
+		return "", nil
+	}
+
+	id, err := hex.DecodeString(str)
+	if err != nil || len(id) == 0 {
+		return "", fmt.Errorf("invalid cursor")
+	}
+	return string(id), nil
+}
+
+// * 取出 _id 大於 after 的前 count 個未過期文檔，以 _id 索引二分搜尋游標位置
+// * 回傳下一個游標，後面沒有文檔時為 "0"
+func scanDocs(entry *Entry, after string, count int, now int64) (string, []*storage.Document) {
+	sorted := entry.SortedDocs()
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i].ID > after })
+
+	list := make([]*storage.Document, 0, min(count, len(sorted)-i))
+	for ; i < len(sorted); i++ {
+		doc := sorted[i]
+		if doc.IsExpired(now) {
+			continue
+		}
+
+		if len(list) >= count {
+			return hex.EncodeToString([]byte(list[len(list)-1].ID)), list
+		}
+		list = append(list, doc)
+	}
+
+	return "0", list
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-jsondb/internal/storage"
+)
+
+// * 走訪期間新增與移除其他文檔，已存在的文檔依 _id 順序各回傳一次，過期文檔不回傳
+func TestFSCANWithChanges(t *testing.T) {
+	server := openServer(t, testConfig(t))
+	defer server.Close()
+
+	client := server.NewClient()
+	for _, id := range []string{"m", "c", "x", "a", "q", "f", "t", "k"} {
+		if reply := exec(t, client, fmt.Sprintf(`ADD c {"_id":"%s"}`, id)); reply.IsError() {
+			t.Fatalf("ADD %s: %s", id, reply.Str)
+		}
+	}
+
+	expireAt := time.Now().Add(100 * time.Millisecond).UnixMilli()
+	if reply := exec(t, client, fmt.Sprintf(`PEXPIREAT c %d {"_id":"f"}`, expireAt)); reply.IsError() {
+		t.Fatalf("PEXPIREAT: %s", reply.Str)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	changes := [][]string{
+		{`ADD c {"_id":"b"}`, `REMOVE c {"_id":"q"}`},
+		{`ADD c {"_id":"z"}`, `ADD c {"_id":"d"}`},
+	}
+
+	var got []string
+	cursor := "0"
+	for i := 0; ; i++ {
+		reply := exec(t, client, "FSCAN c "+cursor+" COUNT 2")
+		if reply.IsError() || len(reply.List) != 2 {
+			t.Fatalf("FSCAN %s = %q", cursor, reply.Text())
+		}
+
+		var docs []map[string]interface{}
+		if err := json.Unmarshal([]byte(reply.List[1].Str), &docs); err != nil {
+			t.Fatalf("FSCAN %s returned invalid JSON: %v", cursor, err)
+		}
+		for _, doc := range docs {
+			got = append(got, doc["_id"].(string))
+		}
+
+		cursor = reply.List[0].Str
+		if cursor == "0" {
+			break
+		}
+		if i < len(changes) {
+			for _, input := range changes[i] {
+				if reply := exec(t, client, input); reply.IsError() {
+					t.Fatalf("%s: %s", input, reply.Str)
+				}
+			}
+		}
+	}
+
+	// * 游標之前新增的 b、d 不回傳，游標之後新增的 z 回傳，走到之前已移除的 q 與已過期的 f 不回傳
+	want := []string{"a", "c", "k", "m", "t", "x", "z"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("FSCAN returned %v, want %v", got, want)
+	}
+}
+
+func TestScanDocsIndex(t *testing.T) {
+	entry := storage.NewCollection()
+	for _, id := range []string{"d3", "d1", "d5", "d2", "d4"} {
+		entry.AddDoc(&storage.Document{ID: id})
+	}
+	entry.RemoveDocs([]string{"d2"})
+
+	tests := []struct {
+		after string
+		count int
+		next  string
+		want  string
+	}{
+		{after: "", count: 2, next: "d3", want: "[d1 d3]"},
+		{after: "d3", count: 2, next: "0", want: "[d4 d5]"},
+		{after: "d2", count: 10, next: "0", want: "[d3 d4 d5]"},
+		{after: "d5", count: 2, next: "0", want: "[]"},
+	}
+
+	for _, tt := range tests {
+		next, docs := scanDocs(entry, tt.after, tt.count, 0)
+		ids := make([]string, 0, len(docs))
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+
+		if tt.next != "0" {
+			tt.next = fmt.Sprintf("%x", tt.next)
+		}
+		if next != tt.next || fmt.Sprint(ids) != tt.want {
+			t.Errorf("scanDocs after %q = %s %v, want %s %s", tt.after, next, ids, tt.next, tt.want)
+		}
+	}
+
+	// * 複製的 KEY 不帶索引，仍依 _id 排序
+	if _, docs := scanDocs(entry.Clone(), "", 10, 0); len(docs) != 4 || docs[0].ID != "d1" || docs[3].ID != "d5" {
+		t.Fatalf("scanDocs on a clone returned %d documents", len(docs))
+	}
+}
+
+// * 每次 SCAN 之間讓掃描槽加倍或減半，整個走訪期間都存在的 KEY 至少回傳一次
+func TestSCANAcrossResize(t *testing.T) {
+	server := openServer(t, testConfig(t))
+	defer server.Close()
+
+	client := server.NewClient()
+	stable := make(map[string]bool)
+	for i := range 200 {
+		key := "stable:" + strconv.Itoa(i)
+		if reply := exec(t, client, "SET "+key+" 1"); reply.IsError() {
+			t.Fatalf("SET %s: %s", key, reply.Str)
+		}
+		stable[key] = true
+	}
+
+	d, _ := server.getDB(0)
+	churn := func(grow bool) {
+		for i := range 4000 {
+			key := "churn:" + strconv.Itoa(i)
+			sh := d.shard(key)
+			sh.mu.Lock()
+			if grow {
+				sh.insert(key, &Entry{Type: "string", Value: "1"})
+			} else {
+				sh.delete(key)
+			}
+			sh.mu.Unlock()
+		}
+	}
+
+	sizes := make(map[int]bool)
+	seen := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		reply := exec(t, client, "SCAN "+cursor+" COUNT 7")
+		if reply.IsError() || len(reply.List) != 2 {
+			t.Fatalf("SCAN %s = %q", cursor, reply.Text())
+		}
+		for _, key := range reply.List[1].List {
+			seen[key.Str] = true
+		}
+
+		cursor = reply.List[0].Str
+		if cursor == "0" {
+			break
+		}
+
+		switch i % 4 {
+		case 0:
+			churn(true)
+		case 2:
+			churn(false)
+		}
+
+		sh := &d.shards[0]
+		sh.mu.RLock()
+		sizes[len(sh.slots)] = true
+		sh.mu.RUnlock()
+	}
+
+	if len(sizes) < 2 {
+		t.Fatalf("slots were never resized: %v", sizes)
+	}
+	for key := range stable {
+		if !seen[key] {
+			t.Errorf("SCAN missed %s", key)
+		}
+	}
+}
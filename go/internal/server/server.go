@@ -2,53 +2,73 @@ package server
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-jsondb/internal/storage"
 )
 
+const Version = "0.1.0"
+
 type Entry = storage.Entry
 
 type Server struct {
-	mu     sync.RWMutex
-	db     map[int]map[string]*storage.Entry
-	config storage.Config
-	writer map[int]*storage.AOFWriter
-	reader map[int]*storage.AOFReader
+	dbMu    sync.RWMutex
+	dbs     map[int]*database
+	config  storage.Config
+	cfgMu   sync.RWMutex
+	rewrite rewriteState
+	acl     *aclList
+
+	snapshot snapshotState
+	memory   memoryState
+	warm     warmState
+	expiry   expiryState
+
+	// * 關閉流程: stop 通知背景工作結束，tasks 等待其完成
+	stop      chan struct{}
+	tasks     sync.WaitGroup
+	taskMu    sync.Mutex
+	closing   atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+	shutdown  shutdownState
+
+	startedAt time.Time
 }
 
-func NewServer() (*Server, error) {
-	config := storage.NewConfig()
+func NewServer(config storage.Config) (*Server, error) {
+	acl, err := loadACL(config.Option.UsersFile)
+	if err != nil {
+		return nil, err
+	}
 
-	dbList := make(map[int]map[string]*storage.Entry)
-	writerList := make(map[int]*storage.AOFWriter)
-	readerList := make(map[int]*storage.AOFReader)
+	server := &Server{
+		dbs:    make(map[int]*database),
+		config: config,
+		acl:    acl,
 
-	dbConfig := config
-	dbConfig.DB = 0
+		stop:     make(chan struct{}),
+		shutdown: shutdownState{requested: make(chan struct{})},
 
-	reader := storage.NewAOFReader(dbConfig)
-	data, err := reader.Load()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load data from AOF(DB 0): %v", err)
+		startedAt: time.Now(),
 	}
 
-	dbList[0] = data
-	readerList[0] = reader
+	if _, err := server.openDB(0); err != nil {
+		server.Close()
+		return nil, err
+	}
 
-	writer, err := storage.NewAOFWriter(dbConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AOF writer(DB 0): %v", err)
+	// * 載入資料超過 maxmemory 時先依存取統計淘汰到限制內，再於背景預熱剩餘空間
+	if err := server.freeMemory(); err != nil {
+		slog.Warn("Failed to free memory after loading", "error", err)
 	}
-	writerList[0] = writer
 
-	server := &Server{
-		db:     dbList,
-		config: config,
-		writer: writerList,
-		reader: readerList,
+	if config.Option.WarmOnStart {
+		server.startWarm(0, "*", config.Option.WarmKeys, config.Option.WarmBytes)
 	}
 
 	server.clean()
@@ -56,18 +76,56 @@ func NewServer() (*Server, error) {
 	return server, nil
 }
 
+// * 停止背景工作、等待進行中的指令完成，再 fsync 並關閉所有 AOF，可重複呼叫
 func (s *Server) Close() error {
-	for _, writer := range s.writer {
-		if err := writer.Close(); err != nil {
-			return err
+	s.closeOnce.Do(func() {
+		s.taskMu.Lock()
+		s.closing.Store(true)
+		s.taskMu.Unlock()
+
+		close(s.stop)
+		s.tasks.Wait()
+
+		if !s.shutdown.skipStats() {
+			s.saveStats()
 		}
+
+		// * 取得各資料庫的寫鎖代表已沒有執行中的指令
+		for _, d := range s.databases() {
+			d.mu.Lock()
+			if err := d.writer.Close(); err != nil {
+				slog.Error("Failed to close AOF writer", "db", d.id, "error", err)
+				if s.closeErr == nil {
+					s.closeErr = fmt.Errorf("failed to close AOF writer(DB %d): %v", d.id, err)
+				}
+			}
+			d.mu.Unlock()
+		}
+	})
+	return s.closeErr
+}
+
+// * 在背景執行工作並納入關閉時的等待，伺服器關閉中則不執行並回傳 false
+func (s *Server) goTask(fn func()) bool {
+	s.taskMu.Lock()
+	defer s.taskMu.Unlock()
+
+	if s.closing.Load() {
+		return false
 	}
-	return nil
+
+	s.tasks.Add(1)
+	go func() {
+		defer s.tasks.Done()
+		fn()
+	}()
+	return true
 }
 
 func (s *Server) NewClient() *Client {
 	return &Client{
 		db:     0,
+		proto:  2,
 		server: s,
 	}
 }
@@ -76,90 +134,60 @@ func (c *Client) GetDB() int {
 	return c.db
 }
 
+func (c *Client) Proto() int {
+	return c.proto
+}
+
+// * 每秒 hz 次依到期索引清理過期資料，每秒檢查是否需要重寫 AOF
+// * 每 expire-interval 秒輸出清理數量並保存存取統計
 func (s *Server) clean() {
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute) // 每1分鐘清理一次
+	s.goTask(func() {
+		interval := time.Second / time.Duration(s.option().Hz)
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
-		for range ticker.C {
-			s.cleanExpire()
-		}
-	}()
-}
-
-func (s *Server) cleanExpire() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+		lastCheck := time.Now()
+		lastReport := time.Now()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
 
-	now := time.Now().Unix()
-	total := 0
+			// * 清理頻率可由 CONFIG SET hz 調整
+			if next := time.Second / time.Duration(s.option().Hz); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
 
-	for db, e := range s.db {
-		count := 0
-		var list []string
+			s.activeExpire(interval)
 
-		for key, entry := range e {
-			if entry.ExpireAt != nil && now >= *entry.ExpireAt {
-				list = append(list, key)
+			if time.Since(lastCheck) >= time.Second {
+				lastCheck = time.Now()
+				s.checkRewrite()
 			}
-		}
-
-		for _, key := range list {
-			s.delFromMem(db, key)
-			count++
-		}
 
-		if count > 0 {
-			fmt.Printf("[TTL Clean] DB %d: cleaned %d expired keys\n", db, count)
-			total += count
+			if time.Since(lastReport) >= time.Duration(s.option().ExpireInterval)*time.Second {
+				lastReport = time.Now()
+				s.reportExpire()
+				s.saveStats()
+			}
 		}
-	}
-
-	if total > 0 {
-		fmt.Printf("[TTL Clean] Total cleaned %d expired keys across all databases\n", total)
-	}
+	})
 }
 
-func (s *Server) delFromMem(dbNum int, key string) {
-	delete(s.db[dbNum], key)
-
-	if writer, isExist := s.writer[dbNum]; isExist {
-		writer.Write("DEL", key, nil)
+// * 依 AOF 狀態修復寫入中斷的 JSON 檔案
+func (s *Server) reconcile(d *database, data map[string]*Entry) error {
+	if err := d.writer.Reconcile(data, func(key string, entry *Entry) error {
+		return s.syncFile(d, key)
+	}); err != nil {
+		return fmt.Errorf("failed to reconcile files for DB %d: %v", d.id, err)
 	}
-
-	if writer, isExist := s.writer[dbNum]; isExist {
-		writer.Delete(key)
-	}
-}
-
-func (s *Server) checkDB(db int) error {
-	if _, isExist := s.db[db]; isExist {
-		return nil
-	}
-
-	dbConfig := s.config
-	dbConfig.DB = db
-
-	// * 從 AOF 恢復數據
-	reader := storage.NewAOFReader(dbConfig)
-	data, err := reader.Load()
-	if err != nil {
-		return fmt.Errorf("failed to load data from AOF for DB %d: %v", db, err)
-	}
-
-	s.db[db] = data
-	s.reader[db] = reader
-
-	writer, err := storage.NewAOFWriter(dbConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create AOF writer for DB %d: %v", db, err)
-	}
-	s.writer[db] = writer
-
 	return nil
 }
 
-func (c *Client) matchPattern(key, pattern string) bool {
+func matchPattern(key, pattern string) bool {
 	if pattern == "*" {
 		return true
 	}
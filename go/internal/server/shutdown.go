@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-jsondb/internal/command"
+)
+
+var errShuttingDown = fmt.Errorf("server is shutting down")
+
+type shutdownState struct {
+	mu        sync.Mutex
+	once      sync.Once
+	requested chan struct{}
+	noSave    bool
+}
+
+func (state *shutdownState) skipStats() bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return state.noSave
+}
+
+// * 由 SHUTDOWN 指令觸發，主程式收到後停止接受連線並呼叫 Close
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdown.requested
+}
+
+// * 關閉時等待進行中指令的時間，可由 CONFIG SET shutdown-timeout 調整
+func (s *Server) ShutdownTimeout() time.Duration {
+	return time.Duration(s.option().ShutdownTimeout) * time.Second
+}
+
+func (s *Server) requestShutdown(noSave bool) {
+	s.shutdown.mu.Lock()
+	s.shutdown.noSave = noSave
+	s.shutdown.mu.Unlock()
+
+	s.shutdown.once.Do(func() {
+		close(s.shutdown.requested)
+	})
+}
+
+// * SHUTDOWN [NOSAVE|SAVE]
+// * 預設只 fsync AOF 並保存存取統計；SAVE 先寫入快照，失敗時拒絕關閉；NOSAVE 略過快照與存取統計
+func (c *Client) SHUTDOWN(cmd *command.Command) Reply {
+	mode := cmd.GetStr("mode")
+
+	if mode == "SAVE" {
+		if err := c.server.startSave(false); err != nil {
+			return Errorf("failed to save snapshot before shutdown: %v", err)
+		}
+	}
+
+	slog.Info("Shutdown requested by client", "mode", mode)
+	c.server.requestShutdown(mode == "NOSAVE")
+	return Status("OK")
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go-jsondb/internal/storage"
+)
+
+type snapshotState struct {
+	mu         sync.Mutex
+	running    bool
+	saves      int
+	lastStatus string
+	lastError  string
+	lastSave   int64
+	lastSize   int64
+	lastTime   time.Duration
+	startedAt  time.Time
+}
+
+// * 將所有已載入的資料庫寫入快照，background 為 true 時於背景執行
+func (s *Server) startSave(background bool) error {
+	s.snapshot.mu.Lock()
+	if s.snapshot.running {
+		s.snapshot.mu.Unlock()
+		return fmt.Errorf("background save already in progress")
+	}
+	s.snapshot.running = true
+	s.snapshot.startedAt = time.Now()
+	s.snapshot.mu.Unlock()
+
+	var list []int
+	for _, d := range s.databases() {
+		list = append(list, d.id)
+	}
+
+	run := func() error {
+		var err error
+		var size int64
+		for _, db := range list {
+			var written int64
+			if written, err = s.saveDB(db); err != nil {
+				slog.Error("Snapshot failed", "db", db, "error", err)
+				break
+			}
+			size += written
+		}
+
+		s.snapshot.mu.Lock()
+		defer s.snapshot.mu.Unlock()
+
+		s.snapshot.running = false
+		s.snapshot.saves++
+		s.snapshot.lastTime = time.Since(s.snapshot.startedAt)
+		if err != nil {
+			s.snapshot.lastStatus = "err"
+			s.snapshot.lastError = err.Error()
+			return err
+		}
+
+		s.snapshot.lastStatus = "ok"
+		s.snapshot.lastError = ""
+		s.snapshot.lastSave = time.Now().Unix()
+		s.snapshot.lastSize = size
+		return nil
+	}
+
+	if background {
+		if !s.goTask(func() { run() }) {
+			s.snapshot.mu.Lock()
+			s.snapshot.running = false
+			s.snapshot.mu.Unlock()
+			return errShuttingDown
+		}
+		return nil
+	}
+	return run()
+}
+
+// * 只在複製資料時持有資料庫的寫鎖，讀取已移出記憶體的 KEY、編碼與寫檔都在鎖外進行
+func (s *Server) saveDB(db int) (int64, error) {
+	d, isExist := s.getDB(db)
+	if !isExist {
+		return 0, nil
+	}
+
+	d.mu.Lock()
+	clone := s.cloneDB(d)
+	position := d.writer.Position()
+	d.mu.Unlock()
+	defer clone.close()
+
+	dbConfig := s.dbConfig(db)
+
+	started := time.Now()
+	size, err := storage.WriteSnapshot(dbConfig, position, clone.len(), clone.each)
+	if err != nil {
+		return 0, err
+	}
+
+	slog.Info("Snapshot saved", "db", db, "keys", clone.len(), "seq", position.Seq, "aof_offset", position.Offset, "size", size, "duration", time.Since(started))
+	return size, nil
+}
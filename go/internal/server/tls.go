@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"go-jsondb/internal/storage"
+)
+
+const (
+	tlsAuthNo       = "no"
+	tlsAuthOptional = "optional"
+	tlsAuthYes      = "yes"
+)
+
+// * 依設定建立 TLS 連接埠使用的設定，tls-port 為 0 時回傳 nil
+// * tls-auth-clients 為 yes 時要求客戶端憑證 (mTLS)，optional 時只驗證有提供的憑證
+func TLSConfig(option storage.Option) (*tls.Config, error) {
+	if option.TLSPort == 0 {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(option.TLSCertFile, option.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if option.TLSCACertFile != "" {
+		pool, err := LoadCertPool(option.TLSCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+	}
+
+	switch option.TLSAuthClients {
+	case tlsAuthYes:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case tlsAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go-jsondb/internal/storage"
+)
+
+const warmLogEvery = 1000
+
+// * 連續放不進剩餘空間的 KEY 數量上限，超過則視為已預熱到上限
+const maxWarmSkips = 16
+
+type warmState struct {
+	mu         sync.Mutex
+	running    bool
+	db         int
+	pattern    string
+	loaded     int
+	bytes      int64
+	total      int
+	count      int
+	lastStatus string
+	lastError  string
+	lastTime   time.Duration
+	startedAt  time.Time
+}
+
+type warmCandidate struct {
+	key    string
+	hits   uint32
+	access int64
+}
+
+// * 依存取統計由熱到冷讀回已移出記憶體的 KEY，keys 與 bytes 為 0 時不限制，且不會超過 maxmemory
+func (s *Server) startWarm(db int, pattern string, keys int, bytes int64) error {
+	s.warm.mu.Lock()
+	if s.warm.running {
+		s.warm.mu.Unlock()
+		return fmt.Errorf("cache warming already in progress")
+	}
+	s.warm.running = true
+	s.warm.db = db
+	s.warm.pattern = pattern
+	s.warm.loaded = 0
+	s.warm.bytes = 0
+	s.warm.total = 0
+	s.warm.startedAt = time.Now()
+	s.warm.mu.Unlock()
+
+	isStarted := s.goTask(func() {
+		err := s.warmDB(db, pattern, keys, bytes)
+		if err != nil {
+			slog.Error("Cache warming failed", "db", db, "error", err)
+		}
+
+		s.warm.mu.Lock()
+		defer s.warm.mu.Unlock()
+
+		s.warm.running = false
+		s.warm.count++
+		s.warm.lastTime = time.Since(s.warm.startedAt)
+		if err != nil {
+			s.warm.lastStatus = "err"
+			s.warm.lastError = err.Error()
+		} else {
+			s.warm.lastStatus = "ok"
+			s.warm.lastError = ""
+		}
+	})
+
+	if !isStarted {
+		s.warm.mu.Lock()
+		s.warm.running = false
+		s.warm.mu.Unlock()
+		return errShuttingDown
+	}
+	return nil
+}
+
+func (s *Server) warmDB(db int, pattern string, keys int, bytes int64) error {
+	now := time.Now().UnixMilli()
+
+	d, isExist := s.getDB(db)
+	if !isExist {
+		return fmt.Errorf("DB %d is not loaded", db)
+	}
+
+	var list []warmCandidate
+	d.mu.RLock()
+	for i := range d.shards {
+		sh := &d.shards[i]
+		sh.mu.RLock()
+		for key, entry := range sh.data {
+			if entry.Evicted && matchPattern(key, pattern) {
+				list = append(list, warmCandidate{key, entry.Frequency(now), entry.LastAccess()})
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].hits != list[j].hits {
+			return list[i].hits > list[j].hits
+		}
+		return list[i].access > list[j].access
+	})
+
+	if keys > 0 && len(list) > keys {
+		list = list[:keys]
+	}
+
+	s.warm.mu.Lock()
+	s.warm.total = len(list)
+	s.warm.mu.Unlock()
+
+	slog.Info("Cache warming started", "db", db, "pattern", pattern, "candidates", len(list))
+
+	maxMemory := s.option().MaxMemory
+	loaded, size, skips := 0, int64(0), 0
+
+	// * 每個 KEY 單獨取鎖，預熱期間不阻塞其他連線
+	for _, candidate := range list {
+		// * 關閉時中斷預熱，未讀回的 KEY 留在磁碟上
+		if s.closing.Load() {
+			slog.Info("Cache warming interrupted by shutdown", "db", db, "keys", loaded, "total", len(list))
+			return nil
+		}
+
+		// * 較大的 KEY 放不下時改試下一個，較小的 KEY 仍可能放得進剩餘空間
+		done := s.warmKey(d, candidate.key, maxMemory, bytes-size, bytes > 0)
+		if done < 0 {
+			skips++
+			if skips >= maxWarmSkips {
+				break
+			}
+			continue
+		}
+		skips = 0
+
+		loaded++
+		size += done
+
+		s.warm.mu.Lock()
+		s.warm.loaded = loaded
+		s.warm.bytes = size
+		s.warm.mu.Unlock()
+
+		if loaded%warmLogEvery == 0 {
+			slog.Info("Cache warming progress", "db", db, "keys", loaded, "total", len(list), "bytes", size)
+		}
+	}
+
+	slog.Info("Cache warming completed", "db", db, "keys", loaded, "bytes", size)
+	return nil
+}
+
+// * 回傳讀回的大小，放不進預算或 maxmemory 時回傳 -1
+// * 在鎖外讀取 JSON 檔案，期間 KEY 被修改或已被讀回時略過
+func (s *Server) warmKey(d *database, key string, maxMemory, remain int64, limited bool) int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	sh := d.shard(key)
+	sh.mu.RLock()
+	entry, isExist := sh.data[key]
+	if !isExist || !entry.Evicted || entry.IsExpired(time.Now().UnixMilli()) {
+		sh.mu.RUnlock()
+		return 0
+	}
+	version := entry.Version
+	sh.mu.RUnlock()
+
+	// * 單一檔案讀取失敗不中斷預熱，留待實際讀取時回報
+	cache, err := d.reader.Read(key)
+	if err != nil || cache == nil {
+		s.memory.loadError.Add(1)
+		return 0
+	}
+
+	restored, err := storage.EntryFromCache(cache)
+	if err != nil {
+		s.memory.loadError.Add(1)
+		return 0
+	}
+
+	size := storage.EstimateSize(key, restored)
+	if limited && size > remain {
+		return -1
+	}
+	if maxMemory > 0 && s.memory.used.Load()+size > maxMemory {
+		return -1
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.data[key] != entry || !entry.Evicted || entry.Version != version {
+		return 0
+	}
+
+	entry.Reload(restored)
+	s.memory.onDisk.Add(-1)
+	s.resize(key, entry)
+	sh.index(key, entry)
+
+	return size
+}
+
+// * 定期與關閉時保存存取統計
+func (s *Server) saveStats() {
+	list := make(map[int]storage.Stats)
+	for _, d := range s.databases() {
+		stats := storage.Stats{
+			SavedAt: time.Now().Unix(),
+			Keys:    make(map[string]storage.AccessStat),
+		}
+
+		// * 先取得序號再收集，之後的修改都會留下序號較大的紀錄
+		d.mu.RLock()
+		stats.Seq = d.writer.Seq()
+		for i := range d.shards {
+			sh := &d.shards[i]
+			sh.mu.RLock()
+			for key, entry := range sh.data {
+				stat := entry.Stat()
+				stat.Evicted = entry.Evicted
+				stats.Keys[key] = stat
+			}
+			sh.mu.RUnlock()
+		}
+		d.mu.RUnlock()
+
+		list[d.id] = stats
+	}
+
+	for db, stats := range list {
+		if err := storage.SaveStats(s.dbConfig(db), stats); err != nil {
+			slog.Error("Failed to save access stats", "db", db, "error", err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"go-jsondb/internal/storage"
+)
+
+func rewriteCache(t *testing.T, config storage.Config, key string, value interface{}) {
+	t.Helper()
+
+	path := storage.GetPath(config, key).Filepath
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	var cache storage.Cache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatalf("failed to decode %s: %v", path, err)
+	}
+	cache.Value = value
+
+	if data, err = json.Marshal(cache); err != nil {
+		t.Fatalf("failed to encode %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// * 重啟時統計中已移出記憶體的 KEY 直接由 JSON 檔案讀取，不使用快照中的值
+// * 統計之後又有寫入的 KEY 則以快照與 AOF 為準
+func TestWarmStartColdKeys(t *testing.T) {
+	config := testConfig(t)
+	config.Option.MaxMemory = 1 << 30
+	config.Option.MaxMemoryPolicy = storage.PolicyAllKeysLRU
+	server := openServer(t, config)
+
+	client := server.NewClient()
+	for _, input := range []string{`SET cold old`, `SET later old`, `SET hot old`} {
+		if reply := exec(t, client, input); reply.IsError() {
+			t.Fatalf("%s: %s", input, reply.Str)
+		}
+	}
+
+	d, _ := server.getDB(0)
+	for _, key := range []string{"cold", "later"} {
+		if isEvicted, err := server.evictKey(d, key); !isEvicted || err != nil {
+			t.Fatalf("evictKey %s = %v, %v", key, isEvicted, err)
+		}
+	}
+
+	// * 快照保存 old，之後 JSON 檔案的值不同時可分辨讀取來源
+	if reply := exec(t, client, `SAVE`); reply.IsError() {
+		t.Fatalf("SAVE: %s", reply.Str)
+	}
+
+	// * 模擬定期保存統計後、下一次保存前中斷
+	server.saveStats()
+	statsPath := storage.GetStatsPath(server.dbConfig(0)).Filepath
+	saved, err := os.ReadFile(statsPath)
+	if err != nil {
+		t.Fatalf("failed to read stats: %v", err)
+	}
+
+	var stats storage.Stats
+	if err := json.Unmarshal(saved, &stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if !stats.Keys["cold"].Evicted || !stats.Keys["later"].Evicted || stats.Keys["hot"].Evicted {
+		t.Fatalf("stats evicted flags = %+v", stats.Keys)
+	}
+
+	if reply := exec(t, client, `SET later new`); reply.IsError() {
+		t.Fatalf("SET later: %s", reply.Str)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := os.WriteFile(statsPath, saved, 0644); err != nil {
+		t.Fatalf("failed to restore stats: %v", err)
+	}
+
+	dbConfig := config
+	dbConfig.DB = 0
+	rewriteCache(t, dbConfig, "cold", "from-json")
+	rewriteCache(t, dbConfig, "later", "stale-json")
+
+	server = openServer(t, config)
+	defer server.Close()
+	client = server.NewClient()
+
+	if got := exec(t, client, `GET hot`).Text(); got != "old" {
+		t.Fatalf("GET hot = %q, want old", got)
+	}
+	if !isEvicted(t, server, 0, "cold") {
+		t.Fatalf("cold was loaded into memory on restart")
+	}
+	if isEvicted(t, server, 0, "later") {
+		t.Fatalf("later was written after the stats and should be loaded from the AOF")
+	}
+
+	if got := exec(t, client, `GET cold`).Text(); got != "from-json" {
+		t.Fatalf("GET cold = %q, want the JSON file value from-json", got)
+	}
+	if got := exec(t, client, `GET later`).Text(); got != "new" {
+		t.Fatalf("GET later = %q, want the AOF value new", got)
+	}
+}
@@ -1,25 +1,36 @@
 package storage
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
-	"go-jsondb/internal/util"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"time"
 )
 
 type AOFReader struct {
 	config Config
 	logger *slog.Logger
+	seq    int64
 }
 
 type Entry struct {
-	Value    string `json:"value"`
-	Type     string `json:"type"`
-	ExpireAt *int64 `json:"expire_at,omitempty"`
+	Value    interface{} `json:"value"`
+	Type     string      `json:"type"`
+	ExpireAt *int64      `json:"expire_at,omitempty"` // * Unix 毫秒
+	Docs     []*Document `json:"docs,omitempty"`
+
+	// * 記憶體層狀態: 估算大小、最後存取毫秒、LFU 計數、是否已移出記憶體、JSON 檔案是否已由本程序寫入
+	// * Access 與 Hits 在讀鎖下更新，只能透過 atomic 存取；Version 每次修改遞增，用於判斷 JSON 檔案是否為最新
+	Size    int64  `json:"-"`
+	Access  int64  `json:"-"`
+	Hits    uint32 `json:"-"`
+	Evicted bool   `json:"-"`
+	Stored  bool   `json:"-"`
+	Version uint64 `json:"-"`
+
+	// * 依 _id 排序的文檔，FSCAN 以二分搜尋定位游標，透過 AddDoc、RemoveDocs 等方法與 Docs 同步
+	sorted []*Document
 }
 
 func NewAOFReader(config Config) *AOFReader {
@@ -31,75 +42,332 @@ func NewAOFReader(config Config) *AOFReader {
 	}
 }
 
-func (r *AOFReader) Load() (map[string]*Entry, error) {
+// * stats 不為 nil 時，重啟前已移出記憶體且之後沒有修改的 KEY 不讀取值，直接標記為已移出記憶體
+func (r *AOFReader) Load(stats *Stats) (map[string]*Entry, error) {
 	data := make(map[string]*Entry)
 
-	dir := filepath.Join(r.config.Option.DBPath, "aof")
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(GetAOFDir(r.config), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create AOF directory: %v", err)
 	}
 
-	path := filepath.Join(dir, fmt.Sprintf("db_%d.aof", r.config.DB))
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		r.logger.Info("AOF file not found, starting with empty database", "path", path)
+	path := GetAOFPath(r.config)
+	_, err := os.Stat(path)
+	hasAOF := !os.IsNotExist(err)
+
+	// * 快照記錄的 AOF 位置仍有效時只讀取其後的尾端，否則讀取整個 AOF 並依序號略過
+	start := int64(0)
+	header, err := ReadSnapshotHeader(r.config)
+	if err == nil && header != nil && hasAOF && r.isTail(path, header) {
+		start = header.Offset
+	}
+
+	cold := r.coldKeys(path, hasAOF, header, start, stats)
+
+	// * 有快照時先載入快照，AOF 只重播序號在快照之後的紀錄
+	after := int64(-1)
+	snapshot, err := LoadSnapshot(r.config, cold)
+	if err != nil {
+		r.logger.Warn("Ignoring unreadable snapshot, replaying full AOF", "path", GetSnapshotPath(r.config), "error", err)
+		start = 0
+	} else if snapshot != nil {
+		data = snapshot.Data
+		after = snapshot.Seq
+		r.seq = snapshot.Seq
+		r.logger.Info("Loaded snapshot", "path", GetSnapshotPath(r.config), "keys", len(data), "seq", snapshot.Seq)
+	} else {
+		start = 0
+	}
+
+	if !hasAOF {
+		r.logger.Info("AOF file not found, starting with snapshot or empty database", "path", path)
 		return data, nil
 	}
 
-	file, err := os.Open(path)
+	r.logger.Info("Loading data from AOF file", "path", path, "offset", start, "cold_keys", len(cold))
+
+	// * 沒有序號的紀錄屬於舊版 AOF 或重寫的 BASE 區段，已包含在快照中時略過
+	skipBase := after >= 0
+	replayed := 0
+	scan, err := ScanAOFFrom(path, start, func(cmd AOF) {
+		if cmd.Seq > r.seq {
+			r.seq = cmd.Seq
+		}
+
+		if cmd.Command == "BASE" {
+			if after >= 0 && cmd.Seq <= after {
+				skipBase = true
+				return
+			}
+
+			// * 快照早於 AOF 重寫，改以 AOF 的完整狀態為準
+			if after >= 0 {
+				r.logger.Info("Snapshot is older than rewritten AOF, replaying full AOF", "snapshot_seq", after, "base_seq", cmd.Seq)
+			}
+			clear(data)
+			after = -1
+			skipBase = false
+			return
+		}
+
+		if (cmd.Seq == 0 && skipBase) || (cmd.Seq != 0 && cmd.Seq <= after) {
+			return
+		}
+
+		replayed++
+		r.apply(data, cmd)
+
+		// * 紀錄早於統計，JSON 檔案已包含此修改，只保留重播後的類型與過期時間
+		if entry, isExist := data[cmd.Key]; isExist && cold[cmd.Key] {
+			entry.Evict()
+			entry.Stored = true
+		}
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open AOF file: %v", err)
+		return nil, err
+	}
+
+	if scan.Corrupt {
+		switch {
+		case scan.TornTail:
+			r.logger.Warn("AOF ends with an incomplete record, truncating torn tail",
+				"path", path, "line", scan.Line, "offset", scan.ValidSize, "discarded_bytes", scan.Size-scan.ValidSize, "error", scan.Error)
+		case r.config.Option.AOFRepair:
+			r.logger.Warn("AOF is corrupted, repairing by truncating at the first bad record",
+				"path", path, "line", scan.Line, "offset", scan.ValidSize, "discarded_bytes", scan.Size-scan.ValidSize, "error", scan.Error)
+		default:
+			return nil, fmt.Errorf("AOF file %s is corrupted at line %d (offset %d): %v; restart with -aof-repair or run jsondb-check-aof -fix",
+				path, scan.Line, scan.ValidSize, scan.Error)
+		}
+
+		if err := TruncateAOF(path, scan.ValidSize); err != nil {
+			return nil, err
+		}
 	}
-	defer file.Close()
 
-	r.logger.Info("Loading data from AOF file", "path", path)
+	r.logger.Info("Loaded keys from AOF file", "count", len(data), "records", scan.Records, "replayed", replayed)
+	return data, nil
+}
 
-	scanner := bufio.NewScanner(file)
-	count := 0
+// * 統計中已移出記憶體的 KEY 其 JSON 檔案即為保存統計時的狀態，AOF 在統計之後沒有修改紀錄時不需讀取值
+// * 沒有序號的紀錄以所屬 BASE 的序號判斷，統計比所有紀錄都新時 (例如 AOF 被移除) 不使用統計
+func (r *AOFReader) coldKeys(path string, hasAOF bool, header *Snapshot, start int64, stats *Stats) map[string]bool {
+	if stats == nil {
+		return nil
+	}
 
-	for scanner.Scan() {
-		count++
-		line := scanner.Text()
+	cold := make(map[string]bool)
+	for key, stat := range stats.Keys {
+		if stat.Evicted {
+			cold[key] = true
+		}
+	}
+	if len(cold) == 0 {
+		return nil
+	}
 
-		if line == "" {
-			continue
+	latest := int64(0)
+	if header != nil {
+		latest = header.Seq
+		// * 快照晚於統計時，統計之後的紀錄可能在快照位置之前
+		if header.Seq > stats.Seq {
+			start = 0
 		}
+	}
 
-		var cmd AOF
-		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
-			r.logger.Error("Failed to parse AOF line", "line", count, "error", err)
-			continue
+	if hasAOF {
+		base := int64(0)
+		_, err := ScanAOFFrom(path, start, func(cmd AOF) {
+			seq := cmd.Seq
+			if cmd.Command == "BASE" {
+				base = seq
+			} else if seq == 0 {
+				seq = base
+			}
+
+			latest = max(latest, seq)
+			if seq > stats.Seq {
+				delete(cold, cmd.Key)
+			}
+		})
+		if err != nil {
+			return nil
 		}
+	}
 
-		// * 依據 AOF 恢復資料
-		switch cmd.Command {
-		case "SET":
-			if value, ok := cmd.Value.(string); ok {
-				entry := &Entry{
-					Value: value,
-					Type:  util.GetType(value),
+	if latest < stats.Seq {
+		r.logger.Warn("Access stats are newer than the data, loading all values", "stats_seq", stats.Seq, "data_seq", latest)
+		return nil
+	}
+	return cold
+}
+
+// * AOF 在快照之後未被重寫 (BASE 序號相同)，且快照記錄的位置剛好是下一筆紀錄的開頭或檔案結尾
+func (r *AOFReader) isTail(path string, snapshot *Snapshot) bool {
+	if snapshot.Offset < 0 {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || snapshot.Offset > info.Size() || ReadAOFBase(path) != snapshot.Base {
+		return false
+	}
+	if snapshot.Offset == info.Size() {
+		return true
+	}
+
+	cmd, err := ReadAOFRecord(path, snapshot.Offset)
+	return err == nil && cmd.Seq == snapshot.Seq+1
+}
+
+// * 載入時讀到的最大序號
+func (r *AOFReader) Seq() int64 {
+	return r.seq
+}
+
+// * 依據 AOF 恢復資料
+func (r *AOFReader) apply(data map[string]*Entry, cmd AOF) {
+	expireAt := cmd.Deadline()
+
+	switch cmd.Command {
+	case "SET":
+		value, valueType := cmd.Value, cmd.Type
+		if valueType == "" {
+			switch legacy := cmd.Value.(type) {
+			case string:
+				valueType = ValueType(legacy)
+			case map[string]interface{}:
+				// * 舊版 EXPIRE 以 SET {"seconds", "expire_at"} 記錄過期時間 (秒)
+				seconds, ok := legacy["expire_at"].(float64)
+				if !ok {
+					return
 				}
 
-				if cmd.ExpireAt != nil {
-					if time.Now().Unix() < *cmd.ExpireAt {
-						entry.ExpireAt = cmd.ExpireAt
-					} else {
-						continue
-					}
+				if entry, isExist := data[cmd.Key]; isExist {
+					r.expireKey(data, cmd.Key, entry, int64(seconds)*1000)
 				}
+				return
+			default:
+				return
+			}
+		}
+
+		entry := &Entry{
+			Value: value,
+			Type:  valueType,
+		}
+		if valueType == TypeCollection {
+			entry = NewCollection()
+		}
 
-				data[cmd.Key] = entry
+		if expireAt != nil {
+			if time.Now().UnixMilli() < *expireAt {
+				entry.ExpireAt = expireAt
+			} else {
+				delete(data, cmd.Key)
+				return
 			}
-		case "DEL":
-			delete(data, cmd.Key)
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading AOF file: %v", err)
+		data[cmd.Key] = entry
+	case "ADD":
+		value, ok := cmd.Value.(map[string]interface{})
+		if !ok {
+			r.logger.Error("Invalid ADD document", "key", cmd.Key)
+			return
+		}
+
+		doc, err := NewDocument(value)
+		if err != nil {
+			r.logger.Error("Invalid ADD document", "key", cmd.Key, "error", err)
+			return
+		}
+
+		entry, isExist := data[cmd.Key]
+		if !isExist || !entry.IsCollection() {
+			entry = NewCollection()
+			data[cmd.Key] = entry
+		}
+		entry.AddDoc(doc)
+	case "UPDATE":
+		entry, isExist := data[cmd.Key]
+		list, ok := cmd.Value.([]interface{})
+		if !isExist || !entry.IsCollection() || !ok {
+			r.logger.Error("Invalid UPDATE record", "key", cmd.Key)
+			return
+		}
+
+		for _, e := range list {
+			value, ok := e.(map[string]interface{})
+			if !ok {
+				return
+			}
+
+			id, _ := value["_id"].(string)
+			if index := entry.IndexOf(id); index >= 0 {
+				entry.Docs[index].Data = value
+			}
+		}
+	case "REMOVE":
+		entry, isExist := data[cmd.Key]
+		if !isExist || !entry.IsCollection() {
+			return
+		}
+		entry.RemoveDocs(cmd.Args)
+	case "EXPIREAT":
+		entry, isExist := data[cmd.Key]
+		if !isExist || expireAt == nil {
+			return
+		}
+
+		// * 無指定文檔時為整個 KEY 的過期時間
+		if len(cmd.Args) == 0 {
+			r.expireKey(data, cmd.Key, entry, *expireAt)
+			return
+		}
+
+		if !entry.IsCollection() {
+			return
+		}
+
+		// * 已過期的文檔直接移除
+		if time.Now().UnixMilli() >= *expireAt {
+			entry.RemoveDocs(cmd.Args)
+			return
+		}
+
+		for _, doc := range entry.FindDocs(cmd.Args) {
+			docExpireAt := *expireAt
+			doc.ExpireAt = &docExpireAt
+		}
+	case "PERSIST":
+		entry, isExist := data[cmd.Key]
+		if !isExist {
+			return
+		}
+
+		if len(cmd.Args) == 0 {
+			entry.ExpireAt = nil
+			return
+		}
+
+		if !entry.IsCollection() {
+			return
+		}
+
+		for _, doc := range entry.FindDocs(cmd.Args) {
+			doc.ExpireAt = nil
+		}
+	case "DEL":
+		delete(data, cmd.Key)
 	}
+}
 
-	r.logger.Info("Loaded keys from AOF file", "count", len(data))
-	return data, nil
+func (r *AOFReader) expireKey(data map[string]*Entry, key string, entry *Entry, expireAt int64) {
+	if time.Now().UnixMilli() >= expireAt {
+		delete(data, key)
+		return
+	}
+	entry.ExpireAt = &expireAt
 }
 
 func (r *AOFReader) Read(key string) (*Cache, error) {
@@ -0,0 +1,528 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+type testRecord struct {
+	command  string
+	key      string
+	value    interface{}
+	expireAt *int64
+	args     []string
+}
+
+func testConfig(t *testing.T) Config {
+	t.Helper()
+
+	config := NewConfig()
+	config.Option.DBPath = t.TempDir()
+	return config
+}
+
+func writeRecords(t *testing.T, config Config, list []testRecord) AOFPosition {
+	t.Helper()
+
+	writer, err := NewAOFWriter(config)
+	if err != nil {
+		t.Fatalf("NewAOFWriter: %v", err)
+	}
+	defer writer.Close()
+
+	writer.SetSeq(lastSeq(t, config))
+	for _, e := range list {
+		if _, err := writer.Append(e.command, e.key, e.value, e.expireAt, e.args...); err != nil {
+			t.Fatalf("Append %s %s: %v", e.command, e.key, err)
+		}
+	}
+	return writer.Position()
+}
+
+// * 接續寫入時序號需延續既有的紀錄
+func lastSeq(t *testing.T, config Config) int64 {
+	t.Helper()
+
+	seq := int64(0)
+	if _, err := os.Stat(GetAOFPath(config)); os.IsNotExist(err) {
+		return seq
+	}
+	if _, err := ScanAOF(GetAOFPath(config), func(cmd AOF) { seq = max(seq, cmd.Seq) }); err != nil {
+		t.Fatalf("ScanAOF: %v", err)
+	}
+	return seq
+}
+
+func load(t *testing.T, config Config) map[string]*Entry {
+	t.Helper()
+
+	data, err := NewAOFReader(config).Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return data
+}
+
+func at(offset time.Duration) *int64 {
+	expireAt := time.Now().Add(offset).UnixMilli()
+	return &expireAt
+}
+
+func doc(id string, n int) map[string]interface{} {
+	return map[string]interface{}{"_id": id, "n": n}
+}
+
+func docIDs(entry *Entry) []string {
+	list := make([]string, 0, len(entry.Docs))
+	for _, doc := range entry.Docs {
+		list = append(list, doc.ID)
+	}
+	return list
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadReplaysEachCommand(t *testing.T) {
+	future := at(time.Hour)
+
+	tests := []struct {
+		name    string
+		records []testRecord
+		check   func(t *testing.T, data map[string]*Entry)
+	}{
+		{
+			name:    "SET keeps the exact text and its type",
+			records: []testRecord{{command: "SET", key: "k", value: "1.10"}},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.Text() != "1.10" || e.Type != TypeFloat {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name:    "SET big integer",
+			records: []testRecord{{command: "SET", key: "k", value: "123456789012345678901234567890"}},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.Text() != "123456789012345678901234567890" || e.Type != TypeInt {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name:    "SET quoted string",
+			records: []testRecord{{command: "SET", key: "k", value: `"hi"`}},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.Text() != `"hi"` || e.Type != TypeString {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name:    "SET object with whitespace",
+			records: []testRecord{{command: "SET", key: "k", value: `{ "a" : "<b>" }`}},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.Text() != `{ "a" : "<b>" }` || e.Type != TypeObject {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name:    "SET with expire",
+			records: []testRecord{{command: "SET", key: "k", value: "v", expireAt: future}},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.ExpireAt == nil || *e.ExpireAt != *future {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name:    "SET already expired",
+			records: []testRecord{{command: "SET", key: "k", value: "v", expireAt: at(-time.Hour)}},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e, isExist := data["k"]; isExist {
+					t.Fatalf("expired key loaded: %+v", e)
+				}
+			},
+		},
+		{
+			name: "SET replaces a collection",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "SET", key: "k", value: "5"},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.IsCollection() || e.Text() != "5" || e.Type != TypeInt {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name: "ADD",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "ADD", key: "k", value: doc("d2", 2)},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || !equalIDs(docIDs(e), []string{"d1", "d2"}) {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name: "UPDATE",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "ADD", key: "k", value: doc("d2", 2)},
+				{command: "UPDATE", key: "k", value: []interface{}{doc("d2", 20)}},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				e := data["k"]
+				if e == nil || len(e.Docs) != 2 {
+					t.Fatalf("got %+v", e)
+				}
+				if n := e.Docs[0].Data["n"]; n != float64(1) && n != 1 {
+					t.Fatalf("d1 changed: %v", n)
+				}
+				if n := e.Docs[1].Data["n"]; n != float64(20) && n != 20 {
+					t.Fatalf("d2 not updated: %v", n)
+				}
+			},
+		},
+		{
+			name: "REMOVE",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "ADD", key: "k", value: doc("d2", 2)},
+				{command: "REMOVE", key: "k", args: []string{"d1"}},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || !equalIDs(docIDs(e), []string{"d2"}) {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name: "EXPIREAT key",
+			records: []testRecord{
+				{command: "SET", key: "k", value: "v"},
+				{command: "EXPIREAT", key: "k", expireAt: future},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.ExpireAt == nil || *e.ExpireAt != *future {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name: "EXPIREAT key in the past",
+			records: []testRecord{
+				{command: "SET", key: "k", value: "v"},
+				{command: "EXPIREAT", key: "k", expireAt: at(-time.Second)},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e, isExist := data["k"]; isExist {
+					t.Fatalf("expired key loaded: %+v", e)
+				}
+			},
+		},
+		{
+			name: "EXPIREAT documents",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "ADD", key: "k", value: doc("d2", 2)},
+				{command: "EXPIREAT", key: "k", expireAt: future, args: []string{"d2"}},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				e := data["k"]
+				if e == nil || e.ExpireAt != nil || len(e.Docs) != 2 {
+					t.Fatalf("got %+v", e)
+				}
+				if e.Docs[0].ExpireAt != nil || e.Docs[1].ExpireAt == nil || *e.Docs[1].ExpireAt != *future {
+					t.Fatalf("document expire not applied: %v %v", e.Docs[0].ExpireAt, e.Docs[1].ExpireAt)
+				}
+			},
+		},
+		{
+			name: "EXPIREAT documents in the past",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "ADD", key: "k", value: doc("d2", 2)},
+				{command: "EXPIREAT", key: "k", expireAt: at(-time.Second), args: []string{"d1"}},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || !equalIDs(docIDs(e), []string{"d2"}) {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name: "PERSIST key",
+			records: []testRecord{
+				{command: "SET", key: "k", value: "v", expireAt: future},
+				{command: "PERSIST", key: "k"},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.ExpireAt != nil {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+		{
+			name: "PERSIST documents",
+			records: []testRecord{
+				{command: "ADD", key: "k", value: doc("d1", 1)},
+				{command: "ADD", key: "k", value: doc("d2", 2)},
+				{command: "EXPIREAT", key: "k", expireAt: future, args: []string{"d1", "d2"}},
+				{command: "PERSIST", key: "k", args: []string{"d1"}},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				e := data["k"]
+				if e == nil || len(e.Docs) != 2 {
+					t.Fatalf("got %+v", e)
+				}
+				if e.Docs[0].ExpireAt != nil || e.Docs[1].ExpireAt == nil {
+					t.Fatalf("document persist not applied: %v %v", e.Docs[0].ExpireAt, e.Docs[1].ExpireAt)
+				}
+			},
+		},
+		{
+			name: "DEL",
+			records: []testRecord{
+				{command: "SET", key: "k", value: "v"},
+				{command: "SET", key: "other", value: "v"},
+				{command: "DEL", key: "k"},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if _, isExist := data["k"]; isExist {
+					t.Fatalf("deleted key loaded")
+				}
+				if _, isExist := data["other"]; !isExist {
+					t.Fatalf("other key missing")
+				}
+			},
+		},
+		{
+			// * INCR 系列以 SET 紀錄保存結果
+			name: "INCR result",
+			records: []testRecord{
+				{command: "SET", key: "k", value: "5", expireAt: future},
+				{command: "SET", key: "k", value: "6", expireAt: future},
+			},
+			check: func(t *testing.T, data map[string]*Entry) {
+				if e := data["k"]; e == nil || e.Text() != "6" || e.Type != TypeInt || e.ExpireAt == nil {
+					t.Fatalf("got %+v", e)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			writeRecords(t, config, tt.records)
+			tt.check(t, load(t, config))
+		})
+	}
+}
+
+func TestLoadSnapshotWithAOFTail(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite bool
+	}{
+		{name: "tail after snapshot offset"},
+		{name: "AOF rewritten after snapshot", rewrite: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			position := writeRecords(t, config, []testRecord{
+				{command: "SET", key: "a", value: "1"},
+				{command: "ADD", key: "c", value: doc("d1", 1)},
+			})
+
+			data := load(t, config)
+			if _, err := WriteSnapshot(config, position, len(data), func(write func(key string, entry *Entry) error) error {
+				for key, entry := range data {
+					if err := write(key, entry); err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				t.Fatalf("WriteSnapshot: %v", err)
+			}
+
+			if tt.rewrite {
+				rewriteAOF(t, config, data)
+			}
+
+			writeRecords(t, config, []testRecord{
+				{command: "SET", key: "a", value: "2"},
+				{command: "ADD", key: "c", value: doc("d2", 2)},
+				{command: "SET", key: "b", value: "3"},
+			})
+
+			data = load(t, config)
+			if e := data["a"]; e == nil || e.Text() != "2" {
+				t.Fatalf("a = %+v", e)
+			}
+			if e := data["b"]; e == nil || e.Text() != "3" {
+				t.Fatalf("b = %+v", e)
+			}
+			// * 快照之前的 ADD 重播兩次時會出現重複的文檔
+			if e := data["c"]; e == nil || !equalIDs(docIDs(e), []string{"d1", "d2"}) {
+				t.Fatalf("c = %+v", e)
+			}
+		})
+	}
+}
+
+func rewriteAOF(t *testing.T, config Config, data map[string]*Entry) {
+	t.Helper()
+
+	writer, err := NewAOFWriter(config)
+	if err != nil {
+		t.Fatalf("NewAOFWriter: %v", err)
+	}
+	defer writer.Close()
+	writer.SetSeq(lastSeq(t, config))
+
+	rewrite, err := writer.StartRewrite()
+	if err != nil {
+		t.Fatalf("StartRewrite: %v", err)
+	}
+	for key, entry := range data {
+		for _, record := range Records(key, entry) {
+			if err := rewrite.Append(record); err != nil {
+				t.Fatalf("rewrite Append: %v", err)
+			}
+		}
+	}
+	if err := rewrite.Commit(); err != nil {
+		t.Fatalf("rewrite Commit: %v", err)
+	}
+}
+
+func TestLoadDamagedTail(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(data []byte, lines [][]byte) []byte
+		repair  bool
+		wantErr bool
+		keys    []string
+	}{
+		{
+			name: "truncated record",
+			damage: func(data []byte, lines [][]byte) []byte {
+				return data[:len(data)-len(lines[2])/2]
+			},
+			keys: []string{"a", "b"},
+		},
+		{
+			name: "CRC mismatch in the last record",
+			damage: func(data []byte, lines [][]byte) []byte {
+				return corrupt(data, len(data)-3)
+			},
+			keys: []string{"a", "b"},
+		},
+		{
+			name: "CRC mismatch before valid records",
+			damage: func(data []byte, lines [][]byte) []byte {
+				return corrupt(data, len(lines[0])+len(lines[1])-3)
+			},
+			wantErr: true,
+		},
+		{
+			name: "CRC mismatch repaired",
+			damage: func(data []byte, lines [][]byte) []byte {
+				return corrupt(data, len(lines[0])+len(lines[1])-3)
+			},
+			repair: true,
+			keys:   []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Option.AOFRepair = tt.repair
+			writeRecords(t, config, []testRecord{
+				{command: "SET", key: "a", value: "1"},
+				{command: "SET", key: "b", value: "2"},
+				{command: "SET", key: "c", value: "3"},
+			})
+
+			path := GetAOFPath(config)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			damaged := tt.damage(data, splitLines(data))
+			if err := os.WriteFile(path, damaged, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := NewAOFReader(config).Load(nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected corruption error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if len(loaded) != len(tt.keys) {
+				t.Fatalf("loaded %d keys, want %v", len(loaded), tt.keys)
+			}
+			for _, key := range tt.keys {
+				if _, isExist := loaded[key]; !isExist {
+					t.Fatalf("key %s missing", key)
+				}
+			}
+
+			// * 損壞的尾端已截斷，再次載入不會再回報損壞
+			scan, err := ScanAOF(path, nil)
+			if err != nil || scan.Corrupt || scan.Records != len(tt.keys) {
+				t.Fatalf("AOF not truncated: %+v %v", scan, err)
+			}
+		})
+	}
+}
+
+func splitLines(data []byte) [][]byte {
+	var lines [][]byte
+	start := 0
+	for i, c := range data {
+		if c == '\n' {
+			lines = append(lines, data[start:i+1])
+			start = i + 1
+		}
+	}
+	return lines
+}
+
+// * 改動 JSON 內容中的一個字元，長度不變但 CRC 不符
+func corrupt(data []byte, index int) []byte {
+	damaged := append([]byte(nil), data...)
+	if damaged[index] == 'x' {
+		damaged[index] = 'y'
+	} else {
+		damaged[index] = 'x'
+	}
+	return damaged
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+)
+
+// * AOF 紀錄格式: #<長度> <CRC32> <JSON>\n，舊版無框架的 JSON 行仍可讀取
+func EncodeRecord(cmd AOF) ([]byte, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal AOF command: %v", err)
+	}
+
+	header := fmt.Sprintf("#%d %08x ", len(data), crc32.ChecksumIEEE(data))
+
+	line := make([]byte, 0, len(header)+len(data)+1)
+	line = append(line, header...)
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+func DecodeRecord(line []byte) (AOF, error) {
+	var cmd AOF
+
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return cmd, fmt.Errorf("incomplete record")
+	}
+	line = bytes.TrimRight(line, "\r\n")
+
+	if len(line) > 0 && line[0] == '{' {
+		if err := json.Unmarshal(line, &cmd); err != nil {
+			return cmd, fmt.Errorf("invalid legacy record: %v", err)
+		}
+		return cmd, nil
+	}
+
+	if len(line) == 0 || line[0] != '#' {
+		return cmd, fmt.Errorf("invalid record header")
+	}
+
+	sizeStr, rest, ok := bytes.Cut(line[1:], []byte(" "))
+	if !ok {
+		return cmd, fmt.Errorf("invalid record header")
+	}
+
+	crcStr, data, ok := bytes.Cut(rest, []byte(" "))
+	if !ok {
+		return cmd, fmt.Errorf("invalid record header")
+	}
+
+	size, err := strconv.Atoi(string(sizeStr))
+	if err != nil || size != len(data) {
+		return cmd, fmt.Errorf("record length mismatch")
+	}
+
+	crc, err := strconv.ParseUint(string(crcStr), 16, 32)
+	if err != nil || uint32(crc) != crc32.ChecksumIEEE(data) {
+		return cmd, fmt.Errorf("record checksum mismatch")
+	}
+
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return cmd, fmt.Errorf("invalid record: %v", err)
+	}
+	return cmd, nil
+}
+
+type AOFScan struct {
+	Records   int
+	Size      int64
+	ValidSize int64
+	Corrupt   bool
+	TornTail  bool
+	Line      int
+	Error     error
+}
+
+// * 依序讀取 AOF，只對第一個損壞紀錄之前的內容呼叫 apply
+// * 損壞之後若仍有可解析的紀錄視為中段損壞，否則為寫入中斷的尾端
+func ScanAOF(path string, apply func(cmd AOF)) (*AOFScan, error) {
+	return ScanAOFFrom(path, 0, apply)
+}
+
+// * 從 offset 開始讀取 AOF，回傳的大小為整個檔案中的位置，行號由 offset 起算
+func ScanAOFFrom(path string, offset int64, apply func(cmd AOF)) (*AOFScan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open AOF file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek AOF file: %v", err)
+	}
+
+	scan := &AOFScan{Size: offset, ValidSize: offset}
+	reader := bufio.NewReaderSize(file, 64*1024)
+	line := 0
+
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			line++
+			scan.Size += int64(len(data))
+
+			if len(bytes.TrimSpace(data)) == 0 && bytes.HasSuffix(data, []byte("\n")) {
+				if !scan.Corrupt {
+					scan.ValidSize = scan.Size
+				}
+			} else if cmd, decodeErr := DecodeRecord(data); decodeErr != nil {
+				if !scan.Corrupt {
+					scan.Corrupt = true
+					scan.TornTail = true
+					scan.Line = line
+					scan.Error = decodeErr
+				}
+			} else if scan.Corrupt {
+				scan.TornTail = false
+			} else {
+				scan.Records++
+				scan.ValidSize = scan.Size
+				if apply != nil {
+					apply(cmd)
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading AOF file: %v", err)
+		}
+	}
+
+	return scan, nil
+}
+
+// * 讀取 offset 位置的一筆紀錄，offset 不在紀錄開頭或紀錄損壞時回傳錯誤
+func ReadAOFRecord(path string, offset int64) (AOF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return AOF{}, fmt.Errorf("failed to open AOF file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return AOF{}, fmt.Errorf("failed to seek AOF file: %v", err)
+	}
+
+	data, err := bufio.NewReaderSize(file, 64*1024).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return AOF{}, fmt.Errorf("error reading AOF file: %v", err)
+	}
+	return DecodeRecord(data)
+}
+
+// * AOF 開頭 BASE 紀錄的序號，用於判斷 AOF 是否在快照之後被重寫；未重寫過的 AOF 為 0
+func ReadAOFBase(path string) int64 {
+	cmd, err := ReadAOFRecord(path, 0)
+	if err != nil || cmd.Command != "BASE" {
+		return 0
+	}
+	return cmd.Seq
+}
+
+func TruncateAOF(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF file: %v", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate AOF file: %v", err)
+	}
+	return file.Sync()
+}
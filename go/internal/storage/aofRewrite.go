@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// * AOF 重寫: 由記憶體快照寫出精簡的暫存檔，期間的新寫入暫存於 buffer，完成時補寫並替換
+type AOFRewrite struct {
+	writer *AOFWriter
+	path   string
+	file   *os.File
+	output *bufio.Writer
+	buffer [][]byte
+	count  int
+	seq    int64
+}
+
+// * 需在資料快照的同一個臨界區內呼叫，確保快照後的寫入都會被保留
+func (w *AOFWriter) StartRewrite() (*AOFRewrite, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.rewrite != nil {
+		return nil, fmt.Errorf("AOF rewrite already in progress")
+	}
+
+	path := w.path + ".rewrite.tmp"
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rewrite file: %v", err)
+	}
+
+	rewrite := &AOFRewrite{
+		writer: w,
+		path:   path,
+		file:   file,
+		output: bufio.NewWriter(file),
+		seq:    w.seq,
+	}
+
+	// * BASE 標記之後到下一筆帶序號的紀錄為序號 seq 當下的完整狀態
+	line, err := EncodeRecord(AOF{
+		Seq:       rewrite.seq,
+		Timestamp: time.Now().Unix(),
+		Command:   "BASE",
+	})
+	if err == nil {
+		_, err = rewrite.output.Write(line)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write rewrite file: %v", err)
+	}
+
+	w.rewrite = rewrite
+	return rewrite, nil
+}
+
+func (r *AOFRewrite) Append(cmd AOF) error {
+	line, err := EncodeRecord(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.output.Write(line); err != nil {
+		return fmt.Errorf("failed to write rewrite file: %v", err)
+	}
+
+	r.count++
+	return nil
+}
+
+func (r *AOFRewrite) Count() int {
+	return r.count
+}
+
+// * 補寫重寫期間的指令並以 rename 原子替換 AOF
+func (r *AOFRewrite) Commit() error {
+	if err := r.output.Flush(); err != nil {
+		r.Abort()
+		return fmt.Errorf("failed to flush rewrite file: %v", err)
+	}
+
+	// * 先在鎖外同步大部分資料，縮短阻塞寫入的時間
+	if err := r.file.Sync(); err != nil {
+		r.Abort()
+		return fmt.Errorf("failed to sync rewrite file: %v", err)
+	}
+
+	w := r.writer
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, line := range r.buffer {
+		if _, err := r.file.Write(line); err != nil {
+			r.abort()
+			return fmt.Errorf("failed to append rewrite buffer: %v", err)
+		}
+	}
+
+	if err := r.file.Sync(); err != nil {
+		r.abort()
+		return fmt.Errorf("failed to sync rewrite file: %v", err)
+	}
+
+	info, err := r.file.Stat()
+	if err != nil {
+		r.abort()
+		return fmt.Errorf("failed to stat rewrite file: %v", err)
+	}
+
+	if err := r.file.Close(); err != nil {
+		r.abort()
+		return fmt.Errorf("failed to close rewrite file: %v", err)
+	}
+
+	if err := os.Rename(r.path, w.path); err != nil {
+		r.abort()
+		return fmt.Errorf("failed to replace AOF file: %v", err)
+	}
+	syncDir(filepath.Dir(w.path))
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		w.rewrite = nil
+		return fmt.Errorf("failed to reopen AOF file: %v", err)
+	}
+
+	// * 新檔已完整 fsync，包含舊檔所有紀錄
+	w.file.Close()
+	w.file = file
+	w.synced = w.count
+	w.size = info.Size()
+	w.baseSize = info.Size()
+	w.base = r.seq
+	w.rewrite = nil
+
+	w.logger.Info("AOF rewrite completed", "path", w.path, "records", r.count, "buffered", len(r.buffer), "size", info.Size())
+	return nil
+}
+
+func (r *AOFRewrite) Abort() {
+	r.writer.mutex.Lock()
+	defer r.writer.mutex.Unlock()
+
+	r.abort()
+}
+
+func (r *AOFRewrite) abort() {
+	r.file.Close()
+	os.Remove(r.path)
+	r.writer.rewrite = nil
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// * 以最少的 AOF 紀錄重現 KEY 的狀態
+func Records(key string, entry *Entry) []AOF {
+	now := time.Now().Unix()
+	var list []AOF
+
+	// * 沒有文檔的 COLLECTION 以 collection 類型的 SET 重建，過期時間一併保留
+	if !entry.IsCollection() || len(entry.Docs) == 0 {
+		record := AOF{
+			Timestamp:  now,
+			Command:    "SET",
+			Key:        key,
+			ExpireAtMs: entry.ExpireAt,
+			Type:       entry.Type,
+		}
+		if !entry.IsCollection() {
+			record.Value = EncodeValue(entry.Text(), entry.Type)
+		}
+		return append(list, record)
+	}
+
+	for _, doc := range entry.Docs {
+		list = append(list, AOF{
+			Timestamp: now,
+			Command:   "ADD",
+			Key:       key,
+			Value:     doc.Data,
+		})
+
+		if doc.ExpireAt != nil {
+			list = append(list, AOF{
+				Timestamp:  now,
+				Command:    "EXPIREAT",
+				Key:        key,
+				Args:       []string{doc.ID},
+				ExpireAtMs: doc.ExpireAt,
+			})
+		}
+	}
+
+	if entry.ExpireAt != nil {
+		list = append(list, AOF{
+			Timestamp:  now,
+			Command:    "EXPIREAT",
+			Key:        key,
+			ExpireAtMs: entry.ExpireAt,
+		})
+	}
+
+	return list
+}
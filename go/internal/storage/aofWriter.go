@@ -3,90 +3,316 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 type AOF struct {
+	Seq       int64       `json:"seq,omitempty"`
 	Timestamp int64       `json:"timestamp"`
 	Command   string      `json:"command"`
 	Key       string      `json:"key"`
 	Value     interface{} `json:"value,omitempty"`
 	Args      []string    `json:"args,omitempty"`
 	ExpireAt  *int64      `json:"expire_at,omitempty"`
+
+	// * 過期時間的 Unix 毫秒，舊版紀錄只有秒數的 ExpireAt
+	ExpireAtMs *int64 `json:"expire_at_ms,omitempty"`
+
+	// * SET 紀錄的值類型，值以 EncodeValue 保存；舊版紀錄沒有類型，值為文字
+	Type string `json:"type,omitempty"`
+}
+
+// * 帶類型的 SET 紀錄還原為原始文字，數字不經過 float64
+func (a *AOF) UnmarshalJSON(data []byte) error {
+	type plain AOF
+	var record struct {
+		plain
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	*a = AOF(record.plain)
+	if len(record.Value) == 0 {
+		return nil
+	}
+
+	if a.Type != "" {
+		value, err := DecodeValue(record.Value)
+		if err != nil {
+			return err
+		}
+		a.Value = value
+		return nil
+	}
+	return json.Unmarshal(record.Value, &a.Value)
 }
 
+// * 紀錄的過期時間 (Unix 毫秒)，舊版紀錄由秒換算
+func (a *AOF) Deadline() *int64 {
+	if a.ExpireAtMs != nil {
+		return a.ExpireAtMs
+	}
+	if a.ExpireAt != nil {
+		expireAt := *a.ExpireAt * 1000
+		return &expireAt
+	}
+	return nil
+}
+
+const tempSuffix = ".tmp"
+
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
 type AOFWriter struct {
-	config Config
-	file   *os.File
-	mutex  sync.Mutex
-	logger *slog.Logger
-	count  int64
+	config   Config
+	path     string
+	file     *os.File
+	mutex    sync.Mutex
+	logger   *slog.Logger
+	count    int64
+	seq      int64
+	size     int64
+	baseSize int64
+	base     int64
+	rewrite  *AOFRewrite
+
+	// * fsync 狀態，syncMu 需先於 mutex 取得
+	fsync  string
+	syncMu sync.Mutex
+	synced int64
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func IsValidFsync(policy string) bool {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return true
+	}
+	return false
 }
 
 func NewAOFWriter(config Config) (*AOFWriter, error) {
 	logger := slog.With("component", "AOF Writer")
 
-	dir := filepath.Join(config.Option.DBPath, "aof")
+	dir := GetAOFDir(config)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create AOF directory: %v", err)
 	}
 
-	path := filepath.Join(dir, fmt.Sprintf("db_%d.aof", config.DB))
+	path := GetAOFPath(config)
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open AOF file: %v", err)
 	}
 
-	logger.Info("AOF file opened", "path", path)
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat AOF file: %v", err)
+	}
+
+	fsync := config.Option.AppendFsync
+	if !IsValidFsync(fsync) {
+		fsync = FsyncAlways
+	}
 
-	return &AOFWriter{
-		config: config,
-		file:   file,
-		mutex:  sync.Mutex{},
-		logger: logger,
-	}, nil
+	logger.Info("AOF file opened", "path", path, "fsync", fsync)
+
+	writer := &AOFWriter{
+		config:   config,
+		path:     path,
+		file:     file,
+		mutex:    sync.Mutex{},
+		logger:   logger,
+		size:     info.Size(),
+		baseSize: info.Size(),
+		base:     ReadAOFBase(path),
+		fsync:    fsync,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go writer.flusher()
+
+	return writer, nil
 }
 
-func (w *AOFWriter) Write(command, key string, value interface{}, args ...string) error {
-	return w.WriteWithTTL(command, key, value, nil, args...)
+// * everysec 模式每秒於背景 fsync 一次
+func (w *AOFWriter) flusher() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mutex.Lock()
+			count := w.count
+			fsync := w.fsync
+			w.mutex.Unlock()
+
+			if fsync != FsyncEverySec {
+				continue
+			}
+
+			if err := w.syncTo(count); err != nil {
+				w.logger.Error("Failed to fsync AOF file", "error", err)
+			}
+		}
+	}
 }
 
-func (w *AOFWriter) WriteWithTTL(command, key string, value interface{}, ttlSeconds *uint64, args ...string) error {
+// * group commit: 等待中的寫入共用同一次 fsync
+func (w *AOFWriter) syncTo(count int64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	if w.synced >= count {
+		return nil
+	}
+
 	w.mutex.Lock()
-	defer w.mutex.Unlock()
+	target := w.count
+	file := w.file
+	w.mutex.Unlock()
 
-	aofCmd := AOF{
-		Timestamp: time.Now().Unix(),
-		Command:   command,
-		Key:       key,
-		Value:     value,
-		Args:      args,
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync AOF file: %v", err)
 	}
 
+	w.synced = target
+	return nil
+}
+
+func (w *AOFWriter) Write(command, key string, value interface{}, args ...string) error {
+	return w.WriteWithTTL(command, key, value, nil, args...)
+}
+
+func (w *AOFWriter) WriteWithTTL(command, key string, value interface{}, ttlSeconds *uint64, args ...string) error {
 	// 處理 TTL
+	var expireAt *int64
 	if ttlSeconds != nil {
-		expireTime := time.Now().Unix() + int64(*ttlSeconds)
-		aofCmd.ExpireAt = &expireTime
+		expireTime := time.Now().UnixMilli() + int64(*ttlSeconds)*1000
+		expireAt = &expireTime
+	}
+
+	return w.WriteAt(command, key, value, expireAt, args...)
+}
+
+func (w *AOFWriter) WriteAt(command, key string, value interface{}, expireAt *int64, args ...string) error {
+	count, err := w.Append(command, key, value, expireAt, args...)
+	if err != nil {
+		return err
+	}
+	return w.Wait(count)
+}
+
+// * expireAt 為 Unix 毫秒
+// * 只寫入紀錄不等待 fsync，呼叫端可在釋放鎖之後再以回傳的計數呼叫 Wait
+func (w *AOFWriter) Append(command, key string, value interface{}, expireAt *int64, args ...string) (int64, error) {
+	aofCmd := AOF{
+		Timestamp:  time.Now().Unix(),
+		Command:    command,
+		Key:        key,
+		Value:      value,
+		Args:       args,
+		ExpireAtMs: expireAt,
+	}
+	if text, ok := value.(string); ok && command == "SET" {
+		aofCmd.Type = ValueType(text)
+		aofCmd.Value = EncodeValue(text, aofCmd.Type)
 	}
 
-	// 序列化為 JSON
-	data, err := json.Marshal(aofCmd)
+	w.mutex.Lock()
+
+	// 序列化為帶序號、長度與 CRC 的紀錄
+	aofCmd.Seq = w.seq + 1
+	line, err := EncodeRecord(aofCmd)
 	if err != nil {
-		return fmt.Errorf("failed to marshal AOF command: %v", err)
+		w.mutex.Unlock()
+		return 0, err
 	}
 
 	// 寫入文件
-	_, err = w.file.WriteString(string(data) + "\n")
+	n, err := w.file.Write(line)
+	w.size += int64(n)
 	if err != nil {
-		return fmt.Errorf("failed to write AOF command: %v", err)
+		w.mutex.Unlock()
+		return 0, fmt.Errorf("failed to write AOF command: %v", err)
+	}
+
+	// 重寫期間同步保留新指令，完成時補寫到新檔
+	if w.rewrite != nil {
+		w.rewrite.buffer = append(w.rewrite.buffer, line)
 	}
 
-	// 強制刷新到磁盤
-	return w.file.Sync()
+	w.seq++
+	w.count++
+	count := w.count
+	w.mutex.Unlock()
+
+	return count, nil
+}
+
+// * 依 fsync 策略等待第 count 筆紀錄寫入磁碟，always 模式下並行的寫入共用同一次 fsync
+func (w *AOFWriter) Wait(count int64) error {
+	if count == 0 || w.Fsync() != FsyncAlways {
+		return nil
+	}
+	return w.syncTo(count)
+}
+
+// * 接續載入時讀到的最大序號，快照依序號判斷需重播的 AOF 尾端
+func (w *AOFWriter) SetSeq(seq int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if seq > w.seq {
+		w.seq = seq
+	}
+}
+
+func (w *AOFWriter) Seq() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.seq
+}
+
+// * 快照對應的 AOF 位置: 最後一筆紀錄的序號、AOF 開頭 BASE 紀錄的序號與目前的檔案大小
+// * 載入時 BASE 序號相同且 Offset 處為下一筆紀錄，才從 Offset 開始重播
+type AOFPosition struct {
+	Seq    int64
+	Base   int64
+	Offset int64
+}
+
+func (w *AOFWriter) Position() AOFPosition {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return AOFPosition{
+		Seq:    w.seq,
+		Base:   w.base,
+		Offset: w.size,
+	}
 }
 
 func (w *AOFWriter) Save(key string, cache Cache) error {
@@ -103,15 +329,123 @@ func (w *AOFWriter) Save(key string, cache Cache) error {
 		return fmt.Errorf("failed to marshal cache data: %v", err)
 	}
 
-	if err := os.WriteFile(path.Filepath, data, 0644); err != nil {
+	if err := writeFileAtomic(path, data); err != nil {
 		w.logger.Error("Failed to write file", "path", path.Filepath, "error", err)
-		return fmt.Errorf("failed to write file: %v", err)
+		return err
 	}
 
 	w.logger.Info("Data written to file", "path", path.Filepath)
 	return nil
 }
 
+// * 先寫入同目錄的暫存檔並 fsync，再以 rename 替換，確保 JSON 檔案不會只寫一半
+func writeFileAtomic(path Path, data []byte) error {
+	file, err := os.CreateTemp(path.FolderPath, path.Filename+".*"+tempSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tempPath := file.Name()
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to chmod temp file: %v", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to sync temp file: %v", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to close temp file: %v", err)
+	}
+
+	if err := os.Rename(tempPath, path.Filepath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+
+	if err := syncDir(path.FolderPath); err != nil {
+		return fmt.Errorf("failed to sync folder: %v", err)
+	}
+	return nil
+}
+
+// * 啟動時處理寫入中斷留下的暫存檔
+// * KEY 仍存在於 AOF 狀態時交由 save 重寫 JSON，否則連同殘留的 JSON 一併移除
+func (w *AOFWriter) Reconcile(data map[string]*Entry, save func(key string, entry *Entry) error) error {
+	root := filepath.Join(w.config.Option.DBPath, strconv.Itoa(w.config.DB))
+
+	temps := make(map[string][]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		name := d.Name()
+		if d.IsDir() || !strings.HasSuffix(name, tempSuffix) {
+			return nil
+		}
+
+		index := strings.Index(name, ".json.")
+		if index < 0 {
+			return nil
+		}
+
+		final := filepath.Join(filepath.Dir(path), name[:index+len(".json")])
+		temps[final] = append(temps[final], path)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan temp files: %v", err)
+	}
+
+	if len(temps) == 0 {
+		return nil
+	}
+
+	keys := make(map[string]string, len(data))
+	for key := range data {
+		keys[GetPath(w.config, key).Filepath] = key
+	}
+
+	for final, list := range temps {
+		for _, path := range list {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove temp file: %v", err)
+			}
+		}
+
+		key, isExist := keys[final]
+		if !isExist {
+			if err := os.Remove(final); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale file: %v", err)
+			}
+			w.logger.Warn("Removed leftover temp file of deleted key", "path", final, "temp_files", len(list))
+			continue
+		}
+
+		if err := save(key, data[key]); err != nil {
+			return err
+		}
+		w.logger.Warn("Rebuilt file from AOF after interrupted write", "key", key, "path", final, "temp_files", len(list))
+	}
+
+	return nil
+}
+
 func (w *AOFWriter) Delete(key string) error {
 	path := GetPath(w.config, key)
 
@@ -126,12 +460,84 @@ func (w *AOFWriter) Delete(key string) error {
 	return nil
 }
 
+func (w *AOFWriter) Size() (int64, int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.size, w.baseSize
+}
+
+// * 檔案超過最小大小，且相較上次重寫成長超過指定百分比
+func (w *AOFWriter) NeedRewrite(percentage int, minSize int64) bool {
+	if percentage <= 0 {
+		return false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.rewrite != nil || w.size < minSize {
+		return false
+	}
+
+	base := w.baseSize
+	if base == 0 {
+		base = 1
+	}
+	return (w.size-base)*100/base >= int64(percentage)
+}
+
+func (w *AOFWriter) Fsync() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.fsync
+}
+
+// * 執行期間切換 fsync 策略，切換為 always 時先補上尚未 fsync 的紀錄
+func (w *AOFWriter) SetFsync(policy string) error {
+	if !IsValidFsync(policy) {
+		return fmt.Errorf("invalid fsync policy: %s", policy)
+	}
+
+	w.mutex.Lock()
+	w.fsync = policy
+	count := w.count
+	w.mutex.Unlock()
+
+	if policy == FsyncAlways {
+		return w.syncTo(count)
+	}
+	return nil
+}
+
+// * 尚未 fsync 的紀錄數
+func (w *AOFWriter) Pending() int64 {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.count - w.synced
+}
+
 func (w *AOFWriter) Close() error {
+	close(w.stop)
+	<-w.done
+
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
 	if w.file != nil {
 		w.logger.Info("Closing AOF file")
+		if err := w.file.Sync(); err != nil {
+			w.logger.Error("Failed to fsync AOF file", "error", err)
+		}
+		w.synced = w.count
 		return w.file.Close()
 	}
 	return nil
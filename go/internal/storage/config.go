@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"bytes"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strconv"
 )
@@ -10,10 +13,35 @@ import (
 type Config struct {
 	Option Option `json:"option"`
 	DB     int    `json:"db"`
+	File   string `json:"-"`
 }
 
 type Option struct {
-	DBPath string `json:"db_path"`
+	Bind                  string `json:"bind"`
+	Port                  int    `json:"port"`
+	TLSPort               int    `json:"tls_port"`
+	TLSCertFile           string `json:"tls_cert_file"`
+	TLSKeyFile            string `json:"tls_key_file"`
+	TLSCACertFile         string `json:"tls_ca_cert_file"`
+	TLSAuthClients        string `json:"tls_auth_clients"`
+	Protocol              string `json:"protocol"`
+	Databases             int    `json:"databases"`
+	ExpireInterval        int    `json:"expire_interval"`
+	Hz                    int    `json:"hz"`
+	LogLevel              string `json:"log_level"`
+	ShutdownTimeout       int    `json:"shutdown_timeout"`
+	UsersFile             string `json:"users_file"`
+	DBPath                string `json:"db_path"`
+	AppendFsync           string `json:"appendfsync"`
+	AutoRewritePercentage int    `json:"auto_aof_rewrite_percentage"`
+	AutoRewriteMinSize    int64  `json:"auto_aof_rewrite_min_size"`
+	AOFRepair             bool   `json:"aof_repair"`
+	MaxMemory             int64  `json:"maxmemory"`
+	MaxMemoryPolicy       string `json:"maxmemory_policy"`
+	MaxMemorySamples      int    `json:"maxmemory_samples"`
+	WarmOnStart           bool   `json:"warm_on_start"`
+	WarmKeys              int    `json:"warm_keys"`
+	WarmBytes             int64  `json:"warm_bytes"`
 }
 
 type Path struct {
@@ -23,23 +51,120 @@ type Path struct {
 }
 
 type Cache struct {
-	Key       string      `json:"key"`
-	Value     interface{} `json:"value"`
-	Type      string      `json:"type"`
-	CreatedAt int64       `json:"created_at"`
-	UpdatedAt int64       `json:"updated_at"`
-	ExpireAt  *int64      `json:"expire_at,omitempty"`
+	Key         string           `json:"key"`
+	Value       interface{}      `json:"value"`
+	Type        string           `json:"type"`
+	CreatedAt   int64            `json:"created_at"`
+	UpdatedAt   int64            `json:"updated_at"`
+	ExpireAt    *int64           `json:"expire_at,omitempty"`
+	DocExpireAt map[string]int64 `json:"doc_expire_at,omitempty"`
+
+	// * 過期時間的 Unix 毫秒，舊版檔案只有秒數的 ExpireAt 與 DocExpireAt
+	ExpireAtMs    *int64           `json:"expire_at_ms,omitempty"`
+	DocExpireAtMs map[string]int64 `json:"doc_expire_at_ms,omitempty"`
+
+	// * 值以 EncodeValue 保存，舊版檔案的值一律為文字
+	Typed bool `json:"typed,omitempty"`
+}
+
+// * 帶類型的值還原為原始文字，數字不經過 float64
+func (c *Cache) UnmarshalJSON(data []byte) error {
+	type plain Cache
+	var file struct {
+		plain
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	*c = Cache(file.plain)
+	if len(file.Value) == 0 {
+		return nil
+	}
+
+	if c.Typed && c.Type != TypeCollection {
+		value, err := DecodeValue(file.Value)
+		if err != nil {
+			return err
+		}
+		c.Value = value
+		return nil
+	}
+	return json.Unmarshal(file.Value, &c.Value)
 }
 
 func NewConfig() Config {
 	return Config{
 		Option: Option{
-			DBPath: "./data",
+			Bind:                  "127.0.0.1",
+			Port:                  7989,
+			TLSAuthClients:        "no",
+			Protocol:              "auto",
+			Databases:             16,
+			ExpireInterval:        60,
+			Hz:                    10,
+			LogLevel:              "info",
+			ShutdownTimeout:       10,
+			DBPath:                "./data",
+			AppendFsync:           "always",
+			AutoRewritePercentage: 100,
+			AutoRewriteMinSize:    64 * 1024 * 1024,
+			MaxMemoryPolicy:       "noeviction",
+			MaxMemorySamples:      5,
+			WarmOnStart:           true,
 		},
 		DB: 0,
 	}
 }
 
+// * 讀取 JSON 設定檔，只覆蓋檔案中出現的欄位
+func LoadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config.Option); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	config.File = path
+	return nil
+}
+
+func SaveConfigFile(path string, option Option) error {
+	data, err := json.MarshalIndent(option, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	folder, filename := filepath.Split(path)
+	if folder == "" {
+		folder = "."
+	}
+
+	return writeFileAtomic(Path{
+		FolderPath: folder,
+		Filepath:   path,
+		Filename:   filename,
+	}, append(data, '\n'))
+}
+
+func GetAOFDir(config Config) string {
+	return filepath.Join(config.Option.DBPath, "aof")
+}
+
+func GetAOFPath(config Config) string {
+	return filepath.Join(GetAOFDir(config), fmt.Sprintf("db_%d.aof", config.DB))
+}
+
+func GetSnapshotPath(config Config) string {
+	return filepath.Join(config.Option.DBPath, "snapshot", fmt.Sprintf("db_%d.snap", config.DB))
+}
+
 func GetPath(config Config, key string) Path {
 	hash := md5.Sum([]byte(key))
 	encode := fmt.Sprintf("%x", hash)
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"go-jsondb/internal/util"
+)
+
+const TypeCollection = "collection"
+
+type Document struct {
+	ID       string                 `json:"_id"`
+	Data     map[string]interface{} `json:"data"`
+	ExpireAt *int64                 `json:"expire_at,omitempty"` // * Unix 毫秒
+}
+
+// * 建立文檔，未指定 _id 時自動產生
+func NewDocument(data map[string]interface{}) (*Document, error) {
+	value, hasID := data["_id"]
+	if !hasID {
+		value = util.NewID()
+		data["_id"] = value
+	}
+
+	id, ok := value.(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("_id must be a non-empty string")
+	}
+
+	return &Document{
+		ID:   id,
+		Data: data,
+	}, nil
+}
+
+func (d *Document) IsExpired(now int64) bool {
+	return d.ExpireAt != nil && now >= *d.ExpireAt
+}
+
+func NewCollection() *Entry {
+	return &Entry{
+		Type: TypeCollection,
+	}
+}
+
+// * 複製 KEY 供背景持久化使用
+// * 文檔內容更新時整筆替換 Data 而不原地修改，因此可共用 Data
+func (e *Entry) Clone() *Entry {
+	clone := *e
+
+	if e.ExpireAt != nil {
+		expireAt := *e.ExpireAt
+		clone.ExpireAt = &expireAt
+	}
+
+	if e.Docs != nil {
+		clone.Docs = make([]*Document, len(e.Docs))
+		for i, doc := range e.Docs {
+			copied := *doc
+			clone.Docs[i] = &copied
+		}
+	}
+	clone.sorted = nil
+
+	return &clone
+}
+
+func (e *Entry) IsExpired(now int64) bool {
+	return e.ExpireAt != nil && now >= *e.ExpireAt
+}
+
+func (e *Entry) IsCollection() bool {
+	return e.Type == TypeCollection
+}
+
+func (e *Entry) List() []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(e.Docs))
+	for _, doc := range e.Docs {
+		list = append(list, doc.Data)
+	}
+	return list
+}
+
+// * 未過期的文檔，讀取指令不刪除過期文檔，由背景清理或下一次寫入處理
+func (e *Entry) LiveList(now int64) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(e.Docs))
+	for _, doc := range e.Docs {
+		if !doc.IsExpired(now) {
+			list = append(list, doc.Data)
+		}
+	}
+	return list
+}
+
+func (e *Entry) IndexOf(id string) int {
+	for i, doc := range e.Docs {
+		if doc.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// * 取得已過期文檔的 _id
+func (e *Entry) ExpiredDocs(now int64) []string {
+	var list []string
+	for _, doc := range e.Docs {
+		if doc.IsExpired(now) {
+			list = append(list, doc.ID)
+		}
+	}
+	return list
+}
+
+// * KEY 或其中任一文檔最早的過期時間，0 代表沒有設定過期
+// * 已移出記憶體的 COLLECTION 只剩 KEY 的過期時間
+func (e *Entry) Deadline() int64 {
+	var deadline int64
+	if e.ExpireAt != nil {
+		deadline = *e.ExpireAt
+	}
+
+	for _, doc := range e.Docs {
+		if doc.ExpireAt != nil && (deadline == 0 || *doc.ExpireAt < deadline) {
+			deadline = *doc.ExpireAt
+		}
+	}
+	return deadline
+}
+
+// * 文檔過期時間，寫入 JSON 檔案用
+func (e *Entry) DocExpireAt() map[string]int64 {
+	var list map[string]int64
+	for _, doc := range e.Docs {
+		if doc.ExpireAt == nil {
+			continue
+		}
+		if list == nil {
+			list = make(map[string]int64)
+		}
+		list[doc.ID] = *doc.ExpireAt
+	}
+	return list
+}
+
+// * 依 _id 取得文檔，只走訪一次文檔列表
+func (e *Entry) FindDocs(ids []string) []*Document {
+	findList := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		findList[id] = true
+	}
+
+	var list []*Document
+	for _, doc := range e.Docs {
+		if findList[doc.ID] {
+			list = append(list, doc)
+		}
+	}
+	return list
+}
+
+func (e *Entry) RemoveDocs(ids []string) int {
+	removeList := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removeList[id] = true
+	}
+
+	isIndexed := len(e.sorted) == len(e.Docs)
+
+	kept := e.Docs[:0]
+	for _, doc := range e.Docs {
+		if !removeList[doc.ID] {
+			kept = append(kept, doc)
+		}
+	}
+
+	removed := len(e.Docs) - len(kept)
+	e.Docs = kept
+
+	if !isIndexed {
+		e.IndexDocs()
+		return removed
+	}
+
+	sorted := e.sorted[:0]
+	for _, doc := range e.sorted {
+		if !removeList[doc.ID] {
+			sorted = append(sorted, doc)
+		}
+	}
+	clear(e.sorted[len(sorted):])
+	e.sorted = sorted
+	return removed
+}
+
+// * 新增文檔並插入 _id 索引，自動產生的 _id 依時間遞增，通常直接接在索引尾端
+func (e *Entry) AddDoc(doc *Document) {
+	e.Docs = append(e.Docs, doc)
+	if len(e.sorted) != len(e.Docs)-1 {
+		e.IndexDocs()
+		return
+	}
+
+	i := sort.Search(len(e.sorted), func(i int) bool { return e.sorted[i].ID > doc.ID })
+	e.sorted = slices.Insert(e.sorted, i, doc)
+}
+
+// * 重建 _id 索引，批次載入文檔後呼叫
+func (e *Entry) IndexDocs() {
+	e.sorted = sortByID(e.Docs)
+}
+
+// * 依 _id 排序的文檔，索引與文檔列表不一致時 (如 Clone 的副本) 回傳臨時排序的結果
+func (e *Entry) SortedDocs() []*Document {
+	if len(e.sorted) == len(e.Docs) {
+		return e.sorted
+	}
+	return sortByID(e.Docs)
+}
+
+func sortByID(docs []*Document) []*Document {
+	list := slices.Clone(docs)
+	slices.SortFunc(list, func(a, b *Document) int { return strings.Compare(a.ID, b.ID) })
+	return list
+}
+
+// * 寫入 JSON 檔案的值，COLLECTION 以文檔陣列儲存
+func (e *Entry) CacheValue() interface{} {
+	if e.IsCollection() {
+		return e.List()
+	}
+	return EncodeValue(e.Text(), e.Type)
+}
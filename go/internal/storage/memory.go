@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	PolicyNoEviction  = "noeviction"
+	PolicyAllKeysLRU  = "allkeys-lru"
+	PolicyAllKeysLFU  = "allkeys-lfu"
+	PolicyVolatileLRU = "volatile-lru"
+	PolicyVolatileTTL = "volatile-ttl"
+
+	// * 粗估的結構額外開銷，只用於 maxmemory 判斷
+	entryOverhead = 96
+	docOverhead   = 64
+	valueOverhead = 16
+
+	// * LFU 計數每閒置一分鐘遞減 1
+	lfuDecayMillis = 60 * 1000
+	lfuMax         = 255
+)
+
+func IsValidPolicy(policy string) bool {
+	switch policy {
+	case PolicyNoEviction, PolicyAllKeysLRU, PolicyAllKeysLFU, PolicyVolatileLRU, PolicyVolatileTTL:
+		return true
+	}
+	return false
+}
+
+// * 估算 KEY 的值在記憶體中佔用的大小，已移出記憶體的 KEY 不計入 maxmemory
+func EstimateSize(key string, e *Entry) int64 {
+	if e.Evicted {
+		return 0
+	}
+
+	size := int64(entryOverhead + len(key) + len(e.Type) + valueSize(e.Value))
+	for _, doc := range e.Docs {
+		size += docOverhead + int64(len(doc.ID)) + estimateValue(doc.Data)
+	}
+	return size
+}
+
+func estimateValue(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		return valueOverhead + int64(len(v))
+	case map[string]interface{}:
+		size := int64(48)
+		for key, item := range v {
+			size += valueOverhead + int64(len(key)) + estimateValue(item)
+		}
+		return size
+	case []interface{}:
+		size := int64(24)
+		for _, item := range v {
+			size += estimateValue(item)
+		}
+		return size
+	default:
+		return valueOverhead
+	}
+}
+
+// * 記錄存取時間與 LFU 計數，now 為毫秒
+// * 讀取指令只持有讀鎖，並行更新時可能少算一次，與 Redis 的近似 LFU 相同
+func (e *Entry) Touch(now int64) {
+	hits := e.Frequency(now)
+	if hits < lfuMax {
+		hits++
+	}
+	atomic.StoreUint32(&e.Hits, hits)
+	atomic.StoreInt64(&e.Access, now)
+}
+
+// * 依閒置時間衰減後的 LFU 計數
+func (e *Entry) Frequency(now int64) uint32 {
+	access := atomic.LoadInt64(&e.Access)
+	hits := atomic.LoadUint32(&e.Hits)
+	if access == 0 {
+		return hits
+	}
+
+	decay := (now - access) / lfuDecayMillis
+	if decay >= int64(hits) {
+		return 0
+	}
+	return hits - uint32(decay)
+}
+
+func (e *Entry) LastAccess() int64 {
+	return atomic.LoadInt64(&e.Access)
+}
+
+func (e *Entry) Stat() AccessStat {
+	return AccessStat{
+		Access: atomic.LoadInt64(&e.Access),
+		Hits:   atomic.LoadUint32(&e.Hits),
+	}
+}
+
+// * KV 的原始文字
+func (e *Entry) Text() string {
+	text, _ := e.Value.(string)
+	return text
+}
+
+// * 釋放值，只保留 KEY、類型與過期時間，值留在 JSON 檔案中
+func (e *Entry) Evict() {
+	e.Value = nil
+	e.Docs = nil
+	e.sorted = nil
+	e.Evicted = true
+}
+
+// * 由 JSON 檔案還原已移出記憶體的值
+func (e *Entry) Restore(cache *Cache) error {
+	if cache == nil {
+		return fmt.Errorf("file not found")
+	}
+
+	restored, err := EntryFromCache(cache)
+	if err != nil {
+		return err
+	}
+
+	e.Reload(restored)
+	return nil
+}
+
+// * 以 JSON 檔案讀出的 KEY 取代已移出記憶體的值
+func (e *Entry) Reload(restored *Entry) {
+	e.Value = restored.Value
+	e.Docs, e.sorted = restored.Docs, restored.sorted
+	e.Evicted = false
+}
+
+func EntryFromCache(cache *Cache) (*Entry, error) {
+	entry := &Entry{
+		Type:     cache.Type,
+		ExpireAt: cache.ExpireAtMs,
+	}
+
+	// * 舊版檔案的過期時間為秒
+	if entry.ExpireAt == nil && cache.ExpireAt != nil {
+		expireAt := *cache.ExpireAt * 1000
+		entry.ExpireAt = &expireAt
+	}
+
+	if cache.Type != TypeCollection {
+		if cache.Typed {
+			entry.Value = cache.Value
+			return entry, nil
+		}
+
+		// * 舊版檔案的值為文字，依內容重新判斷類型
+		value, ok := cache.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for key %s", cache.Key)
+		}
+		entry.Value, entry.Type = value, ValueType(value)
+		return entry, nil
+	}
+
+	list, ok := cache.Value.([]interface{})
+	if !ok && cache.Value != nil {
+		return nil, fmt.Errorf("invalid documents for key %s", cache.Key)
+	}
+
+	for _, item := range list {
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid document for key %s", cache.Key)
+		}
+
+		doc, err := NewDocument(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document for key %s: %v", cache.Key, err)
+		}
+
+		if expireAt, isExist := cache.DocExpireAtMs[doc.ID]; isExist {
+			doc.ExpireAt = &expireAt
+		} else if seconds, isExist := cache.DocExpireAt[doc.ID]; isExist {
+			expireAt := seconds * 1000
+			doc.ExpireAt = &expireAt
+		}
+		entry.Docs = append(entry.Docs, doc)
+	}
+	entry.IndexDocs()
+
+	return entry, nil
+}
@@ -0,0 +1,485 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// * 快照格式:
+// * magic(8) version(u16) db(uvarint) seq(varint) created_at(varint) [aof_base(varint) aof_offset(varint)] keys(uvarint)
+// * 每個 KEY: opcode(1) key type flags [expire_at] 值，以 opEOF 結尾，最後附上整個檔案的 CRC32
+// * 版本 2 起 expire_at 為 Unix 毫秒，版本 1 為秒，載入時換算
+// * 版本 3 起 KV 的值為依類型編碼的 JSON，之前的版本為文字，載入時重新判斷類型
+// * 版本 4 起記錄快照當下 AOF 的 BASE 序號與檔案大小，載入時直接從該位置重播
+// * 版本 5 起 KV 的值為客戶端傳入的原始文字
+const (
+	snapshotMagic   = "JSONDBSN"
+	SnapshotVersion = 5
+
+	snapshotVersionSeconds = 1
+	snapshotVersionText    = 2
+	snapshotVersionTyped   = 3
+	snapshotVersionOffset  = 4
+
+	opString     byte = 1
+	opCollection byte = 2
+	opEOF        byte = 0xFF
+
+	flagExpire byte = 1
+)
+
+// * 版本 4 之前的快照沒有 AOF 位置，Offset 為 -1
+type Snapshot struct {
+	DB        int
+	Seq       int64
+	Base      int64
+	Offset    int64
+	CreatedAt int64
+	Data      map[string]*Entry
+}
+
+type snapshotWriter struct {
+	output *bufio.Writer
+	crc    hash.Hash32
+	buffer [binary.MaxVarintLen64]byte
+	err    error
+}
+
+func (w *snapshotWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.output.Write(data); err != nil {
+		w.err = err
+		return
+	}
+	w.crc.Write(data)
+}
+
+func (w *snapshotWriter) byte(value byte) {
+	w.write([]byte{value})
+}
+
+func (w *snapshotWriter) uvarint(value uint64) {
+	n := binary.PutUvarint(w.buffer[:], value)
+	w.write(w.buffer[:n])
+}
+
+func (w *snapshotWriter) varint(value int64) {
+	n := binary.PutVarint(w.buffer[:], value)
+	w.write(w.buffer[:n])
+}
+
+func (w *snapshotWriter) bytes(data []byte) {
+	w.uvarint(uint64(len(data)))
+	w.write(data)
+}
+
+func (w *snapshotWriter) string(value string) {
+	w.bytes([]byte(value))
+}
+
+func (w *snapshotWriter) expire(flags byte, expireAt *int64) {
+	if expireAt != nil {
+		flags |= flagExpire
+	}
+	w.byte(flags)
+	if expireAt != nil {
+		w.varint(*expireAt)
+	}
+}
+
+func (w *snapshotWriter) entry(key string, entry *Entry) {
+	if entry.IsCollection() {
+		w.byte(opCollection)
+	} else {
+		w.byte(opString)
+	}
+
+	w.string(key)
+	w.string(entry.Type)
+	w.expire(0, entry.ExpireAt)
+
+	if !entry.IsCollection() {
+		w.string(entry.Text())
+		return
+	}
+
+	w.uvarint(uint64(len(entry.Docs)))
+	for _, doc := range entry.Docs {
+		data, err := json.Marshal(doc.Data)
+		if err != nil && w.err == nil {
+			w.err = fmt.Errorf("failed to marshal document %s: %v", doc.ID, err)
+		}
+		w.expire(0, doc.ExpireAt)
+		w.bytes(data)
+	}
+}
+
+// * 寫入暫存檔後 fsync 並 rename，快照檔案不會只寫一半
+// * each 依序以 write 寫入 count 個 KEY，資料不需一次全部保留在記憶體中
+func WriteSnapshot(config Config, position AOFPosition, count int, each func(write func(key string, entry *Entry) error) error) (int64, error) {
+	path := GetSnapshotPath(config)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+
+	temp := path + tempSuffix
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot file: %v", err)
+	}
+
+	w := &snapshotWriter{
+		output: bufio.NewWriterSize(file, 256*1024),
+		crc:    crc32.NewIEEE(),
+	}
+
+	var header [10]byte
+	copy(header[:], snapshotMagic)
+	binary.BigEndian.PutUint16(header[8:], SnapshotVersion)
+	w.write(header[:])
+	w.uvarint(uint64(config.DB))
+	w.varint(position.Seq)
+	w.varint(time.Now().Unix())
+	w.varint(position.Base)
+	w.varint(position.Offset)
+	w.uvarint(uint64(count))
+
+	written := 0
+	err = each(func(key string, entry *Entry) error {
+		w.entry(key, entry)
+		written++
+		return w.err
+	})
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	if written != count && w.err == nil {
+		w.err = fmt.Errorf("wrote %d keys, expected %d", written, count)
+	}
+	w.byte(opEOF)
+
+	if w.err == nil {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], w.crc.Sum32())
+		if _, err := w.output.Write(sum[:]); err != nil {
+			w.err = err
+		}
+	}
+
+	if w.err == nil {
+		w.err = w.output.Flush()
+	}
+	if w.err == nil {
+		w.err = file.Sync()
+	}
+
+	info, statErr := file.Stat()
+	file.Close()
+
+	if w.err != nil {
+		os.Remove(temp)
+		return 0, fmt.Errorf("failed to write snapshot file: %v", w.err)
+	}
+	if statErr != nil {
+		os.Remove(temp)
+		return 0, fmt.Errorf("failed to stat snapshot file: %v", statErr)
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return 0, fmt.Errorf("failed to replace snapshot file: %v", err)
+	}
+	syncDir(filepath.Dir(path))
+
+	return info.Size(), nil
+}
+
+type snapshotReader struct {
+	input   *bufio.Reader
+	crc     hash.Hash32
+	version uint16
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	value, err := r.input.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.crc.Write([]byte{value})
+	return value, nil
+}
+
+func (r *snapshotReader) read(size uint64) ([]byte, error) {
+	if size > uint64(r.input.Size())*1024 {
+		return nil, fmt.Errorf("invalid length %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.input, data); err != nil {
+		return nil, err
+	}
+	r.crc.Write(data)
+	return data, nil
+}
+
+func (r *snapshotReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func (r *snapshotReader) varint() (int64, error) {
+	return binary.ReadVarint(r)
+}
+
+func (r *snapshotReader) string() (string, error) {
+	size, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	data, err := r.read(size)
+	return string(data), err
+}
+
+func (r *snapshotReader) expire() (*int64, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagExpire == 0 {
+		return nil, nil
+	}
+
+	expireAt, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if r.version == snapshotVersionSeconds {
+		expireAt *= 1000
+	}
+	return &expireAt, nil
+}
+
+func (r *snapshotReader) entry(op byte, cold map[string]bool) (string, *Entry, error) {
+	key, err := r.string()
+	if err != nil {
+		return "", nil, err
+	}
+	isCold := cold[key]
+
+	entryType, err := r.string()
+	if err != nil {
+		return "", nil, err
+	}
+
+	expireAt, err := r.expire()
+	if err != nil {
+		return "", nil, err
+	}
+
+	entry := &Entry{Type: entryType, ExpireAt: expireAt}
+
+	switch op {
+	case opString:
+		value, err := r.string()
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch {
+		case r.version <= snapshotVersionText:
+			entry.Value, entry.Type = value, ValueType(value)
+		case isCold:
+		case r.version <= snapshotVersionOffset:
+			if entry.Value, err = DecodeValue([]byte(value)); err != nil {
+				return "", nil, fmt.Errorf("invalid value in key %s: %v", key, err)
+			}
+		default:
+			entry.Value = value
+		}
+	case opCollection:
+		count, err := r.uvarint()
+		if err != nil {
+			return "", nil, err
+		}
+
+		for i := uint64(0); i < count; i++ {
+			docExpireAt, err := r.expire()
+			if err != nil {
+				return "", nil, err
+			}
+
+			size, err := r.uvarint()
+			if err != nil {
+				return "", nil, err
+			}
+			raw, err := r.read(size)
+			if err != nil {
+				return "", nil, err
+			}
+			if isCold {
+				continue
+			}
+
+			var data map[string]interface{}
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return "", nil, fmt.Errorf("invalid document in key %s: %v", key, err)
+			}
+
+			doc, err := NewDocument(data)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid document in key %s: %v", key, err)
+			}
+			doc.ExpireAt = docExpireAt
+			entry.Docs = append(entry.Docs, doc)
+		}
+		entry.IndexDocs()
+	default:
+		return "", nil, fmt.Errorf("unknown opcode 0x%02x", op)
+	}
+
+	if isCold {
+		entry.Evict()
+		entry.Stored = true
+	}
+	return key, entry, nil
+}
+
+// * 開啟快照並讀取標頭，檔案不存在時回傳 nil
+func openSnapshot(config Config) (*os.File, *snapshotReader, *Snapshot, uint64, error) {
+	path := GetSnapshotPath(config)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil, 0, nil
+	}
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("failed to open snapshot file: %v", err)
+	}
+
+	r := &snapshotReader{
+		input: bufio.NewReaderSize(file, 256*1024),
+		crc:   crc32.NewIEEE(),
+	}
+
+	snapshot, count, err := r.header(config)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, 0, err
+	}
+	return file, r, snapshot, count, nil
+}
+
+func (r *snapshotReader) header(config Config) (*Snapshot, uint64, error) {
+	header, err := r.read(10)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if string(header[:8]) != snapshotMagic {
+		return nil, 0, fmt.Errorf("invalid snapshot header")
+	}
+	r.version = binary.BigEndian.Uint16(header[8:])
+	if r.version < snapshotVersionSeconds || r.version > SnapshotVersion {
+		return nil, 0, fmt.Errorf("unsupported snapshot version %d", r.version)
+	}
+
+	db, err := r.uvarint()
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if int(db) != config.DB {
+		return nil, 0, fmt.Errorf("snapshot belongs to DB %d", db)
+	}
+
+	snapshot := &Snapshot{DB: int(db), Offset: -1}
+	if snapshot.Seq, err = r.varint(); err != nil {
+		return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if snapshot.CreatedAt, err = r.varint(); err != nil {
+		return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	if r.version > snapshotVersionTyped {
+		if snapshot.Base, err = r.varint(); err != nil {
+			return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+		}
+		if snapshot.Offset, err = r.varint(); err != nil {
+			return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+		}
+	}
+
+	count, err := r.uvarint()
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid snapshot header: %v", err)
+	}
+	return snapshot, count, nil
+}
+
+// * 只讀取快照標頭 (序號與 AOF 位置)，不含資料
+func ReadSnapshotHeader(config Config) (*Snapshot, error) {
+	file, _, snapshot, _, err := openSnapshot(config)
+	if file != nil {
+		file.Close()
+	}
+	return snapshot, err
+}
+
+// * 讀取快照，檔案不存在時回傳 nil，已過期的 KEY 與文檔會直接略過
+// * cold 中的 KEY 不解析值，直接標記為已移出記憶體，值由 JSON 檔案讀取
+func LoadSnapshot(config Config, cold map[string]bool) (*Snapshot, error) {
+	file, r, snapshot, count, err := openSnapshot(config)
+	if file == nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	now := time.Now().UnixMilli()
+	snapshot.Data = make(map[string]*Entry)
+	keys := uint64(0)
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("truncated snapshot file: %v", err)
+		}
+		if op == opEOF {
+			break
+		}
+
+		key, entry, err := r.entry(op, cold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot entry %d: %v", keys, err)
+		}
+		keys++
+
+		if entry.ExpireAt != nil && now >= *entry.ExpireAt {
+			continue
+		}
+		if entry.IsCollection() {
+			entry.RemoveDocs(entry.ExpiredDocs(now))
+		}
+		snapshot.Data[key] = entry
+	}
+
+	if keys != count {
+		return nil, fmt.Errorf("snapshot key count mismatch: %d/%d", keys, count)
+	}
+
+	expected := r.crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(r.input, sum[:]); err != nil {
+		return nil, fmt.Errorf("truncated snapshot file: %v", err)
+	}
+	if binary.BigEndian.Uint32(sum[:]) != expected {
+		return nil, fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// * KEY 的存取統計，跨重啟保存供快取預熱排序
+// * Evicted 表示保存時值只在 JSON 檔案中，之後沒有修改時重啟可直接標記為已移出記憶體
+type AccessStat struct {
+	Access  int64  `json:"access"`
+	Hits    uint32 `json:"hits"`
+	Evicted bool   `json:"evicted,omitempty"`
+}
+
+// * Seq 為開始收集統計前 AOF 最後一筆紀錄的序號
+type Stats struct {
+	SavedAt int64                 `json:"saved_at"`
+	Seq     int64                 `json:"seq"`
+	Keys    map[string]AccessStat `json:"keys"`
+}
+
+func GetStatsPath(config Config) Path {
+	folder := filepath.Join(config.Option.DBPath, "stats")
+	filename := "db_" + strconv.Itoa(config.DB) + ".json"
+
+	return Path{
+		FolderPath: folder,
+		Filepath:   filepath.Join(folder, filename),
+		Filename:   filename,
+	}
+}
+
+func SaveStats(config Config, stats Stats) error {
+	path := GetStatsPath(config)
+	if err := os.MkdirAll(path.FolderPath, 0755); err != nil {
+		return fmt.Errorf("failed to create stats folder: %v", err)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stats: %v", err)
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// * 讀取存取統計，檔案不存在時回傳 nil
+func LoadStats(config Config) (*Stats, error) {
+	path := GetStatsPath(config)
+
+	data, err := os.ReadFile(path.Filepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats file: %v", err)
+	}
+
+	var stats Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stats: %v", err)
+	}
+
+	return &stats, nil
+}
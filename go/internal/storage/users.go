@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 210000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+)
+
+// * 使用者與其 ACL 規則
+// * Commands 依序套用，例如 ["+@read", "-keys"]；Databases 與 Keys 省略時不限制
+type User struct {
+	Name      string   `json:"name"`
+	Password  string   `json:"password"`
+	Disabled  bool     `json:"disabled,omitempty"`
+	Commands  []string `json:"commands"`
+	Databases []int    `json:"databases,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+}
+
+type UsersFile struct {
+	Users []User `json:"users"`
+}
+
+func LoadUsersFile(path string) ([]User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %v", err)
+	}
+
+	var file UsersFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %v", path, err)
+	}
+
+	names := make(map[string]bool, len(file.Users))
+	for _, user := range file.Users {
+		if user.Name == "" {
+			return nil, fmt.Errorf("users file %s: user name must not be empty", path)
+		}
+		if names[user.Name] {
+			return nil, fmt.Errorf("users file %s: duplicate user %s", path, user.Name)
+		}
+		names[user.Name] = true
+
+		if _, _, _, err := parsePasswordHash(user.Password); err != nil {
+			return nil, fmt.Errorf("users file %s: user %s: %v", path, user.Name, err)
+		}
+	}
+
+	return file.Users, nil
+}
+
+// * 產生 pbkdf2-sha256$<iterations>$<salt>$<hash> 格式的密碼雜湊
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeySize)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	return strings.Join([]string{
+		passwordScheme,
+		strconv.Itoa(passwordIterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// * 以固定時間比對，避免由回應時間推測雜湊內容
+func VerifyPassword(hash, password string) bool {
+	iterations, salt, expected, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+func parsePasswordHash(hash string) (int, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return 0, nil, nil, fmt.Errorf("password must be a %s hash", passwordScheme)
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash iterations")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash salt")
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid password hash")
+	}
+
+	return iterations, salt, key, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// * KV 值在記憶體中一律保存客戶端傳入的原始文字，GET 回傳相同的內容；類型只由文字判斷，不改寫內容
+const (
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeNull   = "null"
+	TypeString = "string"
+	TypeObject = "object"
+	TypeArray  = "array"
+)
+
+// * 依文字判斷類型，前後有空白、不是合法 JSON 或為 JSON 字串時為 string
+// * 數字沒有小數點與指數時為 int，即使超出 int64 範圍 (INCR 時才回報錯誤)
+func ValueType(text string) string {
+	if len(text) == 0 || isSpace(text[0]) || isSpace(text[len(text)-1]) || !json.Valid([]byte(text)) {
+		return TypeString
+	}
+
+	switch text[0] {
+	case '{':
+		return TypeObject
+	case '[':
+		return TypeArray
+	case '"':
+		return TypeString
+	case 't', 'f':
+		return TypeBool
+	case 'n':
+		return TypeNull
+	}
+
+	if strings.ContainsAny(text, ".eE") {
+		return TypeFloat
+	}
+	return TypeInt
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// * AOF、JSON 檔案中保存的值: 已是精簡 JSON 的非字串值直接寫入，其餘以 JSON 字串保存原始文字
+// * 含有 <、>、& 的 JSON 會被 encoding/json 改寫為 \u003c 等跳脫字元，因此也以字串保存
+func EncodeValue(text, valueType string) interface{} {
+	if valueType == TypeString || strings.ContainsAny(text, "<>&\u2028\u2029") {
+		return text
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(text)); err != nil || compact.String() != text {
+		return text
+	}
+	return json.RawMessage(text)
+}
+
+// * 還原 EncodeValue 保存的文字，JSON 字串取其內容，其餘 JSON (包含舊版以類型保存的值) 取精簡後的文字
+func DecodeValue(raw []byte) (string, error) {
+	if len(raw) > 0 && raw[0] == '"' {
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return "", fmt.Errorf("invalid value: %v", err)
+		}
+		return text, nil
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return "", fmt.Errorf("invalid value: %v", err)
+	}
+	return compact.String(), nil
+}
+
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func valueSize(value interface{}) int {
+	if text, ok := value.(string); ok {
+		return len(text)
+	}
+	return 0
+}
+
+// * INCR 系列只接受 int 類型，字串即使內容是數字也視為類型錯誤，超出 int64 範圍的整數同樣拒絕
+func IncrBy(text, valueType string, delta int64) (int64, error) {
+	if valueType != TypeInt {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment or decrement would overflow")
+	}
+	return n + delta, nil
+}
+
+func IncrByFloat(text, valueType string, delta float64) (float64, error) {
+	if valueType != TypeInt && valueType != TypeFloat {
+		return 0, fmt.Errorf("value is not a valid float")
+	}
+
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not a valid float")
+	}
+
+	result := f + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, fmt.Errorf("increment would produce NaN or Infinity")
+	}
+	return result, nil
+}
@@ -0,0 +1,36 @@
+package testutil
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-jsondb/internal/storage"
+)
+
+// * 寫入測試用的使用者檔案，Password 欄位為明文，寫入前轉為雜湊
+func WriteUsers(t *testing.T, dir string, users []storage.User) string {
+	t.Helper()
+
+	file := storage.UsersFile{Users: make([]storage.User, 0, len(users))}
+	for _, user := range users {
+		hash, err := storage.HashPassword(user.Password)
+		if err != nil {
+			t.Fatalf("HashPassword: %v", err)
+		}
+		user.Password = hash
+		file.Users = append(file.Users, user)
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("failed to encode users file: %v", err)
+	}
+
+	path := filepath.Join(dir, "users.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write users file: %v", err)
+	}
+	return path
+}
@@ -1,29 +1,85 @@
 package util
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
-func GetType(value string) string {
-	if _, err := strconv.Atoi(value); err == nil {
-		return "int"
+var (
+	idCounter = randomUint32()
+	idProcess = randomBytes(5)
+)
+
+// * 產生 24 碼 HEX 文檔 ID: 4 bytes 時間 + 5 bytes 行程隨機值 + 3 bytes 計數器
+func NewID() string {
+	var id [12]byte
+
+	binary.BigEndian.PutUint32(id[0:4], uint32(time.Now().Unix()))
+	copy(id[4:9], idProcess)
+
+	count := atomic.AddUint32(&idCounter, 1)
+	id[9] = byte(count >> 16)
+	id[10] = byte(count >> 8)
+	id[11] = byte(count)
+
+	return hex.EncodeToString(id[:])
+}
+
+func randomBytes(n int) []byte {
+	list := make([]byte, n)
+	if _, err := rand.Read(list); err != nil {
+		binary.BigEndian.PutUint32(list, uint32(time.Now().UnixNano()))
 	}
+	return list
+}
+
+func randomUint32() uint32 {
+	return binary.BigEndian.Uint32(randomBytes(4))
+}
+
+// * 解析容量設定，支援 kb/mb/gb 單位，例如 512mb
+func ParseBytes(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
 
-	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
-		var obj map[string]interface{}
-		if json.Unmarshal([]byte(value), &obj) == nil {
-			return "object"
+	unit := int64(1)
+	for _, e := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10}, {"b", 1},
+	} {
+		if strings.HasSuffix(value, e.suffix) {
+			value = strings.TrimSuffix(value, e.suffix)
+			unit = e.size
+			break
 		}
 	}
 
-	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
-		var arr []interface{}
-		if json.Unmarshal([]byte(value), &arr) == nil {
-			return "array"
-		}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
 	}
+	return size * unit, nil
+}
 
-	return "string"
+func ParseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level: %s", value)
 }